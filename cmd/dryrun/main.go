@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow/live"
+)
+
+// dryrun prints the timeline of the live mode for the span without the hardware, so the options could be tuned:
+// go run ./cmd/dryrun --from 18:00 --to 02:00 --format html --out night.html --live.max-delay 1m
+type options struct {
+	From   string       `long:"from" env:"FROM" default:"18:00" description:"start of the span: HH:MM of the date or YYYY-MM-DD HH:MM"`
+	To     string       `long:"to" env:"TO" default:"02:00" description:"end of the span, the end before the start is on the next day"`
+	Date   string       `long:"date" env:"DATE" description:"date of the span as YYYY-MM-DD, today by default"`
+	Format string       `long:"format" env:"FORMAT" default:"json" choice:"json" choice:"csv" choice:"html" description:"format of the timeline"`
+	Out    string       `long:"out" env:"OUT" description:"file to write the timeline to, stdout by default"`
+	Live   live.Options `group:"live" namespace:"live" env-namespace:"LIVE"`
+}
+
+func main() {
+	opts := options{}
+	parser := flags.NewParser(&opts, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return
+		}
+		log.Fatalf("Cannot parse flags :%v", err)
+	}
+
+	err := realMain(context.Background(), opts)
+	if err != nil {
+		log.Fatalf("Dry run failed: %v", err)
+	}
+}
+
+func realMain(ctx context.Context, opts options) error {
+	day := time.Now()
+	if opts.Date != "" {
+		var err error
+		day, err = time.ParseInLocation(time.DateOnly, opts.Date, time.Local)
+		if err != nil {
+			return fmt.Errorf("couldn't parse date: %w", err)
+		}
+	}
+
+	from, to, err := live.ParseSpan(day, opts.From, opts.To)
+	if err != nil {
+		return err
+	}
+
+	t, err := live.DryRun(ctx, internal.BuildingMap.Levels, opts.Live, from, to)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.Out != "" {
+		f, err := os.Create(opts.Out)
+		if err != nil {
+			return fmt.Errorf("couldn't create '%s': %w", opts.Out, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	err = t.Write(out, opts.Format)
+	if err != nil {
+		return fmt.Errorf("couldn't write timeline: %w", err)
+	}
+	log.Printf("live mode from %s to %s, seed %d", from.Format(time.DateTime), to.Format(time.DateTime), t.Seed)
+
+	return nil
+}
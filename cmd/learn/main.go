@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow/live"
+	"github.com/mbobakov/khrushchevka/internal/journal"
+	"github.com/spf13/afero"
+)
+
+// learn builds the model of the learned mode from the journal of the light changes:
+// go run ./cmd/learn --journal ./journal.jsonl --out ./model.json --since 672h
+type options struct {
+	Journal string        `long:"journal" env:"JOURNAL" default:"./journal.jsonl" description:"path to the journal written by the server"`
+	Out     string        `long:"out" env:"OUT" default:"./model.json" description:"path to write the model to"`
+	Flows   []string      `long:"flows" env:"FLOWS" env-delim:"," default:"manual" description:"modes whose lights are learned"`
+	Since   time.Duration `long:"since" env:"SINCE" default:"0" description:"learn only the last period of the journal, 0 learns all of it"`
+}
+
+func main() {
+	opts := options{}
+	parser := flags.NewParser(&opts, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return
+		}
+		log.Fatalf("Cannot parse flags :%v", err)
+	}
+
+	err := realMain(afero.NewOsFs(), opts)
+	if err != nil {
+		log.Fatalf("Learning failed: %v", err)
+	}
+}
+
+func realMain(fs afero.Fs, opts options) error {
+	since := time.Time{}
+	if opts.Since > 0 {
+		since = time.Now().Add(-opts.Since)
+	}
+
+	entries, err := journal.Read(fs, opts.Journal, since)
+	if err != nil {
+		return err
+	}
+
+	m := live.Learn(internal.BuildingMap.Levels, entries, opts.Flows)
+	if m.Observed() == 0 {
+		return fmt.Errorf("journal '%s' has no observations of the modes %v", opts.Journal, opts.Flows)
+	}
+
+	err = m.Save(fs, opts.Out)
+	if err != nil {
+		return err
+	}
+	log.Printf("model of %d flats is learned from %d entries between %s and %s, %s of window observations",
+		len(m.Flats), len(entries), m.From.Format(time.DateTime), m.To.Format(time.DateTime), m.Observed().Round(time.Minute))
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow/replay"
+	"github.com/spf13/afero"
+)
+
+// migrate rewrites the snapshots and the replay files with the lights addressed by the window IDs,
+// so they survive the rewiring of the building:
+// go run ./cmd/migrate --in ./snapshot.json
+type options struct {
+	In  string `long:"in" env:"IN" default:"./snapshot.json" description:"snapshot or replay file written with the boards and the pins"`
+	Out string `long:"out" env:"OUT" description:"path to write the migrated file to, the input is replaced and kept with the .bak suffix by default"`
+}
+
+func main() {
+	opts := options{}
+	parser := flags.NewParser(&opts, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return
+		}
+		log.Fatalf("Cannot parse flags :%v", err)
+	}
+
+	err := realMain(afero.NewOsFs(), opts)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+}
+
+func realMain(fs afero.Fs, opts options) error {
+	in, err := afero.ReadFile(fs, opts.In)
+	if err != nil {
+		return fmt.Errorf("couldn't read file '%s': %w", opts.In, err)
+	}
+
+	out := &bytes.Buffer{}
+	dropped, err := replay.Migrate(bytes.NewReader(in), out, internal.BuildingMap.Levels)
+	if err != nil {
+		return fmt.Errorf("couldn't migrate file '%s': %w", opts.In, err)
+	}
+	for _, err := range dropped {
+		log.Printf("light is dropped: %v", err)
+	}
+
+	path := opts.Out
+	if path == "" {
+		path = opts.In
+		err = afero.WriteFile(fs, opts.In+".bak", in, 0644)
+		if err != nil {
+			return fmt.Errorf("couldn't write backup '%s.bak': %w", opts.In, err)
+		}
+	}
+	err = afero.WriteFile(fs, path, out.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't write file '%s': %w", path, err)
+	}
+	log.Printf("file '%s' is migrated to '%s', %d lights are dropped", opts.In, path, len(dropped))
+
+	return nil
+}
@@ -6,32 +6,54 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"path/filepath"
 	"strconv"
 	"strings"
+	_ "time/tzdata" // schedule timezones on the boards without tz database
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
 	"github.com/mbobakov/khrushchevka/internal/flow"
 	"github.com/mbobakov/khrushchevka/internal/flow/live"
 	"github.com/mbobakov/khrushchevka/internal/flow/manual"
+	"github.com/mbobakov/khrushchevka/internal/flow/overlay"
+	"github.com/mbobakov/khrushchevka/internal/flow/playlist"
 	"github.com/mbobakov/khrushchevka/internal/flow/replay"
+	"github.com/mbobakov/khrushchevka/internal/flow/script"
+	"github.com/mbobakov/khrushchevka/internal/flow/vacation"
+	"github.com/mbobakov/khrushchevka/internal/journal"
 	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/mbobakov/khrushchevka/internal/schedule"
 	"github.com/mbobakov/khrushchevka/internal/shutdown"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
 	"github.com/mbobakov/khrushchevka/internal/snapshot/file"
+	"github.com/mbobakov/khrushchevka/internal/state"
 	"github.com/mbobakov/khrushchevka/internal/web"
 	"github.com/spf13/afero"
 	"golang.org/x/sync/errgroup"
 )
 
 type options struct {
-	Listen string         `long:"listen" env:"LISTEN" default:":8080" description:"Listen address"`
-	Boards []string       `long:"boards" env:"BOARDS" default:"20,21,22,23,24,25" env-delim:"," description:"Boards to validate"`
-	NoOp   bool           `long:"noop" env:"NOOP" description:"If true fake board will be used"`
-	Live   live.Options   `group:"live" namespace:"live" env-namespace:"LIVE"`
-	Replay replay.Options `group:"replay" namespace:"replay" env-namespace:"REPLAY"`
-	Snap   file.Options   `group:"snap" namespace:"snap" env-namespace:"SNAP"`
+	Listen string           `long:"listen" env:"LISTEN" default:":8080" description:"Listen address"`
+	Boards []string         `long:"boards" env:"BOARDS" default:"20,21,22,23,24,25" env-delim:"," description:"Boards to validate"`
+	NoOp   bool             `long:"noop" env:"NOOP" description:"If true fake board will be used"`
+	Flow   flow.Options     `group:"flow" namespace:"flow" env-namespace:"FLOW"`
+	Live   live.Options     `group:"live" namespace:"live" env-namespace:"LIVE"`
+	Replay replay.Options   `group:"replay" namespace:"replay" env-namespace:"REPLAY"`
+	Vacat  vacation.Options `group:"vacation" namespace:"vacation" env-namespace:"VACATION"`
+	Snap   file.Options     `group:"snap" namespace:"snap" env-namespace:"SNAP"`
+	Sched  schedule.Options `group:"schedule" namespace:"schedule" env-namespace:"SCHEDULE"`
+	Script script.Options   `group:"script" namespace:"script" env-namespace:"SCRIPT"`
+	List   playlist.Options `group:"playlist" namespace:"playlist" env-namespace:"PLAYLIST"`
+	Over   overlay.Options  `group:"overlay" namespace:"overlay" env-namespace:"OVERLAY"`
+	State  state.Options    `group:"state" namespace:"state" env-namespace:"STATE"`
+	Jrnl   journal.Options  `group:"journal" namespace:"journal" env-namespace:"JOURNAL"`
 }
 
+// legacySnapshot is the snapshot file of the previous versions
+const legacySnapshot = "./snapshot.json"
+
 func main() {
 	opts := options{}
 	parser := flags.NewParser(&opts, flags.Default)
@@ -78,32 +100,108 @@ func realMain(appctx context.Context, opts options) error {
 
 	g, ctx := errgroup.WithContext(appctx)
 
-	snap := file.New(opts.Snap, afero.NewOsFs(), prov, internal.BuildingMap.Levels)
+	snaps := file.New(opts.Snap, afero.NewOsFs())
+	// the snapshot file of the previous versions is imported once as the default sequence
+	legacy := legacySnapshot
+	if opts.Snap.Path != "" {
+		slog.Warn("--snap.path is deprecated, snapshots are the sequences of --snap.dir", slog.String("path", opts.Snap.Path), slog.String("dir", opts.Snap.Dir))
+		legacy = opts.Snap.Path
+	}
+	err = snaps.Import(legacy, snapshot.DefaultSequence)
+	if err != nil {
+		return fmt.Errorf("couldn't import snapshot file: %w", err)
+	}
+	// scenes are kept alongside the sequences
+	scenes := file.New(file.Options{Dir: filepath.Join(opts.Snap.Dir, "scenes")}, afero.NewOsFs())
+
+	arb := flow.NewArbiter(prov, internal.BuildingMap.Levels)
 
 	lf := live.New(
-		prov,
+		clock.Real(),
+		arb.For(live.FlowName),
+		internal.BuildingMap.Levels,
+		opts.Live,
+	)
+	learned := live.NewLearned(
+		clock.Real(),
+		afero.NewOsFs(),
+		arb.For(live.LearnedName),
 		internal.BuildingMap.Levels,
 		opts.Live,
 	)
-	mf := manual.New(prov, internal.BuildingMap.Levels)
-	rep := replay.New(afero.NewOsFs(), prov, opts.Replay)
+	mf := manual.New(arb.For(manual.FlowName), internal.BuildingMap.Levels, scenes)
+	rep := replay.New(clock.Real(), afero.NewOsFs(), snaps, arb.For(replay.FlowName), internal.BuildingMap.Levels, opts.Replay)
+	vac := vacation.New(
+		clock.Real(),
+		afero.NewOsFs(),
+		arb.For(vacation.FlowName),
+		live.New(clock.Real(), arb.For(vacation.FlowName), internal.BuildingMap.Levels, opts.Live),
+		opts.Vacat,
+	)
+
+	flowCtrl, err := flow.NewController(clock.Real(), arb, opts.Flow, lf, learned, mf, rep, vac)
+	if err != nil {
+		return fmt.Errorf("couldn't initiate flow controller: %w", err)
+	}
 
-	flowCtrl := flow.NewController(lf, mf, rep)
+	for _, o := range []flow.Flow{
+		overlay.NewBlink(clock.Real(), arb.ForOverlay(overlay.BlinkName), internal.BuildingMap.Levels, opts.Over),
+		overlay.NewDoorbell(clock.Real(), arb.ForOverlay(overlay.DoorbellName), internal.BuildingMap.Levels, opts.Over),
+	} {
+		err = flowCtrl.RegisterOverlay(o)
+		if err != nil {
+			return fmt.Errorf("couldn't register overlay: %w", err)
+		}
+	}
+
+	scripts := script.NewManager(
+		clock.Real(),
+		afero.NewOsFs(),
+		flowCtrl,
+		func(name string) script.LightsController { return arb.For(name) },
+		internal.BuildingMap.Levels,
+		opts.Script,
+	)
+	err = scripts.Load()
+	if err != nil {
+		return fmt.Errorf("couldn't load scripts: %w", err)
+	}
+
+	playlists, err := playlist.NewManager(clock.Real(), afero.NewOsFs(), flowCtrl, opts.List)
+	if err != nil {
+		return fmt.Errorf("couldn't load playlists: %w", err)
+	}
+
+	sched, err := schedule.New(afero.NewOsFs(), flowCtrl, opts.Sched)
+	if err != nil {
+		return fmt.Errorf("couldn't initiate scheduler: %w", err)
+	}
+
+	store := state.New(afero.NewOsFs(), flowCtrl, sched, prov, internal.BuildingMap.Levels, opts.State)
+	jrnl := journal.New(afero.NewOsFs(), flowCtrl, prov, internal.BuildingMap.Levels, opts.Jrnl)
+	err = store.Restore(ctx)
+	if err != nil {
+		slog.Error("couldn't restore state", slog.Any("err", err))
+	}
 
-	srv, err := web.NewServer(prov, flowCtrl, snap, internal.BuildingMap.Levels)
+	srv, err := web.NewServer(prov, flowCtrl, snaps, internal.BuildingMap.Levels, web.WithScheduler(sched), web.WithScripts(scripts), web.WithPlaylists(playlists), web.WithLive(lf), web.WithReplay(rep), web.WithScenes(scenes))
 	if err != nil {
 		return fmt.Errorf("couln't initiate web server: %w", err)
 	}
 
 	g.Go(func() error { return srv.Listen(ctx, opts.Listen) })
 	g.Go(func() error { return srv.NotifyViaSSE(ctx) })
+	g.Go(func() error { return sched.Run(ctx) })
+	g.Go(func() error { return scripts.Run(ctx) })
+	g.Go(func() error { return store.Run(ctx) })
+	g.Go(func() error { return jrnl.Run(ctx) })
 	g.Go(func() error {
 		errCh := flowCtrl.SubscribeToErrors()
 		for {
 			select {
 			case err := <-errCh:
 				if err != nil {
-					slog.Error("Flow error: %v", err)
+					slog.Error("Flow error", slog.Any("err", err))
 				}
 			case <-ctx.Done():
 				return nil
@@ -121,7 +219,7 @@ func realMain(appctx context.Context, opts options) error {
 		return nil
 	}
 
-	slog.Error("Service shut down unexpectedly: %v", err)
+	slog.Error("Service shut down unexpectedly", slog.Any("err", err))
 
 	return err
 }
@@ -0,0 +1,26 @@
+package clock
+
+import "time"
+
+// Clock is the source of the time for the flows
+// Flows take it as a dependency, so their timelines could be executed faster than the real time under tests
+type Clock interface {
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real returns the clock backed by the time package
+func Real() Clock {
+	return real{}
+}
+
+type real struct{}
+
+func (real) Now() time.Time {
+	return time.Now()
+}
+
+func (real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
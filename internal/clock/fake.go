@@ -0,0 +1,100 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is the clock which moves only when it is advanced
+// Waiters are fired in the order of their deadlines. Waiters abandoned by the receivers are still counted
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+
+	f.waiters = append(f.waiters, &waiter{at: f.now.Add(d), ch: ch})
+	sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+	f.cond.Broadcast()
+
+	return ch
+}
+
+// Advance moves the clock forward and fires the waiters with the passed deadlines
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.set(f.now.Add(d))
+}
+
+// AdvanceToNext moves the clock to the nearest deadline and fires its waiters
+// It reports false if nobody waits
+func (f *Fake) AdvanceToNext() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.waiters) == 0 {
+		return false
+	}
+
+	f.set(f.waiters[0].at)
+	return true
+}
+
+// BlockUntil waits until at least n waiters wait for the clock
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// must be called under the lock
+func (f *Fake) set(now time.Time) {
+	if now.After(f.now) {
+		f.now = now
+	}
+
+	fired := 0
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			break
+		}
+		w.ch <- f.now
+		fired++
+	}
+	f.waiters = f.waiters[fired:]
+}
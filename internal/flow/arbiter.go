@@ -0,0 +1,389 @@
+package flow
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+)
+
+// Group is the named set of lights which could be assigned to the flow
+type Group struct {
+	Name   string
+	Lights []internal.LightAddress
+}
+
+const (
+	// GroupAll contains every light of the building
+	GroupAll = "all"
+	// GroupStairwell contains entrance and landing lights
+	GroupStairwell = "stairwell"
+
+	floorPrefix = "floor-"
+)
+
+// Arbiter keeps the ownership of the lights by the flows
+// Every flow gets the scoped lights controller which affects only the lights owned by the flow
+// Overlays take lights over the owners for a while. Changes made by the owner during the overlay
+// are kept in the shadow state and applied when the overlay ends
+type Arbiter struct {
+	lights lights.ControllerI
+	groups []Group
+
+	// writes serializes changes of the lights, so overlays don't miss the state
+	// mu isn't held during the changes because subscribers of the lights ask for the owners
+	writes  sync.Mutex
+	mu      sync.RWMutex
+	owner   map[internal.LightAddress]string
+	overlay map[internal.LightAddress]string
+	shadow  map[internal.LightAddress]bool
+}
+
+func NewArbiter(l lights.ControllerI, mapping [][]internal.Light) *Arbiter {
+	return &Arbiter{
+		lights:  l,
+		groups:  groupsFromMapping(mapping),
+		owner:   make(map[internal.LightAddress]string),
+		overlay: make(map[internal.LightAddress]string),
+		shadow:  make(map[internal.LightAddress]bool),
+	}
+}
+
+// groupsFromMapping builds groups for the whole building, every side, the stairwell and every floor
+func groupsFromMapping(mapping [][]internal.Light) []Group {
+	var (
+		all       = Group{Name: GroupAll}
+		stairwell = Group{Name: GroupStairwell}
+		sides     = []Group{{Name: "front"}, {Name: "right"}, {Name: "back"}, {Name: "left"}}
+		floors    = []Group{}
+	)
+
+	for lvl, row := range mapping {
+		floor := Group{Name: fmt.Sprintf("%s%d", floorPrefix, lvl)}
+		for _, l := range row {
+			if l.Addr.Pin == "" {
+				continue
+			}
+
+			all.Lights = append(all.Lights, l.Addr)
+			floor.Lights = append(floor.Lights, l.Addr)
+
+			if l.Kind == internal.LightTypeServiceEntrance || l.Kind == internal.LightTypeServiceNoManLand {
+				stairwell.Lights = append(stairwell.Lights, l.Addr)
+				continue
+			}
+
+			switch l.Side {
+			case internal.SideFront:
+				sides[0].Lights = append(sides[0].Lights, l.Addr)
+			case internal.SideRight:
+				sides[1].Lights = append(sides[1].Lights, l.Addr)
+			case internal.SideBack:
+				sides[2].Lights = append(sides[2].Lights, l.Addr)
+			case internal.SideLeft:
+				sides[3].Lights = append(sides[3].Lights, l.Addr)
+			}
+		}
+		floors = append(floors, floor)
+	}
+
+	result := append([]Group{all, stairwell}, sides...)
+	return append(result, floors...)
+}
+
+// Groups returns names of the groups
+func (a *Arbiter) Groups() []string {
+	names := make([]string, 0, len(a.groups))
+	for _, g := range a.groups {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+func (a *Arbiter) group(name string) (Group, bool) {
+	for _, g := range a.groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return Group{}, false
+}
+
+// floorsTopDown splits the lights by the floors starting from the top one
+func (a *Arbiter) floorsTopDown(addrs []internal.LightAddress) [][]internal.LightAddress {
+	result := [][]internal.LightAddress{}
+	for i := len(a.groups) - 1; i >= 0; i-- {
+		g := a.groups[i]
+		if !strings.HasPrefix(g.Name, floorPrefix) {
+			continue
+		}
+
+		floor := []internal.LightAddress{}
+		for _, addr := range g.Lights {
+			if slices.Contains(addrs, addr) {
+				floor = append(floor, addr)
+			}
+		}
+		result = append(result, floor)
+	}
+
+	return result
+}
+
+// Owner returns the name of the flow which owns the light or empty string
+func (a *Arbiter) Owner(addr internal.LightAddress) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.owner[addr]
+}
+
+// Owned returns the lights owned by the flow
+func (a *Arbiter) Owned(flow string) []internal.LightAddress {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return sorted(a.owner, flow)
+}
+
+// Overlaid returns the lights taken by the overlay
+func (a *Arbiter) Overlaid(overlay string) []internal.LightAddress {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return sorted(a.overlay, overlay)
+}
+
+// sorted returns the lights belonging to the name
+func sorted(m map[internal.LightAddress]string, name string) []internal.LightAddress {
+	result := []internal.LightAddress{}
+	for addr, o := range m {
+		if o == name {
+			result = append(result, addr)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b internal.LightAddress) int {
+		if a.Board != b.Board {
+			return int(a.Board) - int(b.Board)
+		}
+		if a.Pin < b.Pin {
+			return -1
+		}
+		if a.Pin > b.Pin {
+			return 1
+		}
+		return 0
+	})
+
+	return result
+}
+
+// Owners returns the flows which own at least one light
+func (a *Arbiter) Owners() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := []string{}
+	for _, o := range a.owner {
+		if !slices.Contains(result, o) {
+			result = append(result, o)
+		}
+	}
+	slices.Sort(result)
+
+	return result
+}
+
+// assign gives the lights to the flow
+func (a *Arbiter) assign(flow string, addrs []internal.LightAddress) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, addr := range addrs {
+		a.owner[addr] = flow
+	}
+}
+
+// transfer gives all lights of one flow to another
+func (a *Arbiter) transfer(from, to string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for addr, o := range a.owner {
+		if o == from {
+			a.owner[addr] = to
+		}
+	}
+}
+
+// release takes all lights from the flow
+func (a *Arbiter) release(flow string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for addr, o := range a.owner {
+		if o == flow {
+			delete(a.owner, addr)
+		}
+	}
+}
+
+// startOverlay gives the lights to the overlay. Current state of the lights is kept to be restored afterwards
+// Lights already taken by another overlay are taken over keeping the original state
+func (a *Arbiter) startOverlay(overlay string, addrs []internal.LightAddress) {
+	a.writes.Lock()
+	defer a.writes.Unlock()
+
+	for _, addr := range addrs {
+		a.mu.RLock()
+		_, overlaid := a.overlay[addr]
+		a.mu.RUnlock()
+
+		isOn := false
+		if !overlaid {
+			var err error
+			isOn, err = a.lights.IsOn(addr)
+			if err != nil {
+				slog.Error("couldn't get state of the light for the overlay", slog.Any("addr", addr), slog.Any("err", err))
+			}
+		}
+
+		a.mu.Lock()
+		if !overlaid {
+			a.shadow[addr] = isOn
+		}
+		a.overlay[addr] = overlay
+		a.mu.Unlock()
+	}
+}
+
+// endOverlay returns the lights still taken by the overlay to the state expected by their owners
+func (a *Arbiter) endOverlay(overlay string) error {
+	a.writes.Lock()
+	defer a.writes.Unlock()
+
+	restore := map[internal.LightAddress]bool{}
+	a.mu.Lock()
+	for addr, o := range a.overlay {
+		if o != overlay {
+			continue
+		}
+		restore[addr] = a.shadow[addr]
+		delete(a.overlay, addr)
+		delete(a.shadow, addr)
+	}
+	a.mu.Unlock()
+
+	var result error
+	for addr, isOn := range restore {
+		err := a.lights.Set(addr, isOn)
+		if err != nil && result == nil {
+			result = fmt.Errorf("couldn't restore light '%v': %w", addr, err)
+		}
+	}
+
+	return result
+}
+
+// SetLight changes the light on behalf of its owner, e.g. on the click on the page
+// The change is shadowed during the overlay as the changes of the owner are
+func (a *Arbiter) SetLight(addr internal.LightAddress, isOn bool) error {
+	return a.For(a.Owner(addr)).Set(addr, isOn)
+}
+
+// For returns the lights controller for the flow
+func (a *Arbiter) For(flow string) *Scoped {
+	return &Scoped{arbiter: a, flow: flow}
+}
+
+// ForOverlay returns the lights controller for the overlay
+func (a *Arbiter) ForOverlay(overlay string) *Scoped {
+	return &Scoped{arbiter: a, flow: overlay, isOverlay: true}
+}
+
+var _ lights.ControllerI = (*Scoped)(nil)
+
+// Scoped is the lights controller which affects only the lights owned by the flow
+// Changes of the lights owned by other flows are silently dropped
+type Scoped struct {
+	arbiter   *Arbiter
+	flow      string
+	isOverlay bool
+}
+
+func (s *Scoped) Set(addr internal.LightAddress, isON bool) error {
+	a := s.arbiter
+	a.writes.Lock()
+	defer a.writes.Unlock()
+
+	if !s.allowed(addr, isON) {
+		return nil
+	}
+
+	return a.lights.Set(addr, isON)
+}
+
+// allowed reports whether the change goes to the light. Changes of the owner during the overlay are shadowed
+func (s *Scoped) allowed(addr internal.LightAddress, isON bool) bool {
+	a := s.arbiter
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if s.isOverlay {
+		return a.overlay[addr] == s.flow
+	}
+
+	if a.owner[addr] != s.flow {
+		return false
+	}
+
+	if _, ok := a.overlay[addr]; ok {
+		a.shadow[addr] = isON
+		return false
+	}
+
+	return true
+}
+
+// IsOn returns the state expected by the owner while the light is taken by the overlay
+func (s *Scoped) IsOn(addr internal.LightAddress) (bool, error) {
+	a := s.arbiter
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if _, ok := a.overlay[addr]; ok && !s.isOverlay && a.owner[addr] == s.flow {
+		return a.shadow[addr], nil
+	}
+
+	return a.lights.IsOn(addr)
+}
+
+func (s *Scoped) Subscribe(ch chan<- internal.PinState) {
+	s.arbiter.lights.Subscribe(ch)
+}
+
+func (s *Scoped) Boards() []uint8 {
+	return s.arbiter.lights.Boards()
+}
+
+// Reset switches off only the lights owned by the flow
+func (s *Scoped) Reset() error {
+	addrs := s.arbiter.Owned(s.flow)
+	if s.isOverlay {
+		addrs = s.arbiter.Overlaid(s.flow)
+	}
+
+	for _, addr := range addrs {
+		err := s.Set(addr, false)
+		if err != nil {
+			return fmt.Errorf("couldn't switch off light '%v': %w", addr, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package flow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArbiter_Overlay(t *testing.T) {
+	var (
+		a1 = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		a2 = internal.LightAddress{Board: 0x20, Pin: "A1"}
+		a3 = internal.LightAddress{Board: 0x20, Pin: "A2"}
+	)
+
+	hw := lights.NewTestController([]uint8{0x20})
+	arb := NewArbiter(hw, nil)
+	arb.assign("live", []internal.LightAddress{a1, a2, a3})
+
+	live := arb.For("live")
+	require.NoError(t, live.Set(a1, true))
+
+	arb.startOverlay("blink", []internal.LightAddress{a1, a2})
+	blink := arb.ForOverlay("blink")
+
+	// overlay owns the lights, changes of the owner are shadowed
+	require.NoError(t, blink.Set(a1, false))
+	require.NoError(t, blink.Set(a2, true))
+	require.NoError(t, live.Set(a2, false))
+	require.NoError(t, live.Set(a3, true))
+	require.NoError(t, blink.Set(a3, false)) // not taken by the overlay
+
+	requireState(t, hw, map[internal.LightAddress]bool{a1: false, a2: true, a3: true})
+
+	isOn, err := live.IsOn(a1)
+	require.NoError(t, err)
+	require.True(t, isOn, "owner sees its own state during the overlay")
+
+	// the latest overlay takes the light over keeping the original state
+	arb.startOverlay("doorbell", []internal.LightAddress{a2})
+	require.NoError(t, arb.endOverlay("blink"))
+	requireState(t, hw, map[internal.LightAddress]bool{a1: true, a2: true, a3: true})
+
+	require.NoError(t, arb.endOverlay("doorbell"))
+	requireState(t, hw, map[internal.LightAddress]bool{a1: true, a2: false, a3: true})
+	require.Empty(t, arb.Overlaid("doorbell"))
+}
+
+func TestController_AssignFlowGroups(t *testing.T) {
+	var (
+		front = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		back  = internal.LightAddress{Board: 0x20, Pin: "A1"}
+		stair = internal.LightAddress{Board: 0x21, Pin: "A0"}
+	)
+	mapping := [][]internal.Light{
+		{
+			{Number: 1, Side: internal.SideFront, Kind: internal.LightTypeShortWindow, Addr: front},
+			{Number: 1, Side: internal.SideBack, Kind: internal.LightTypeShortWindow, Addr: back},
+			{Side: internal.SideFront, Kind: internal.LightTypeServiceEntrance, Addr: stair},
+		},
+	}
+	hw := lights.NewTestController([]uint8{0x20, 0x21})
+	arb := NewArbiter(hw, mapping)
+	flows := map[string]*seededFlow{}
+	for _, name := range []string{"live", "manual"} {
+		flows[name] = &seededFlow{name: name, seeds: make(chan State, 10), started: make(chan struct{}, 10)}
+	}
+	c, err := NewController(clock.Real(), arb, Options{Transition: "none"}, flows["live"], flows["manual"])
+	require.NoError(t, err)
+	go func() {
+		for range c.SubscribeToErrors() {
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, c.AssignFlow(ctx, "live", GroupAll, nil))
+	<-flows["live"].started
+	require.NoError(t, c.AssignFlow(ctx, "manual", "front", nil))
+	<-flows["manual"].started
+	require.ErrorContains(t, c.AssignFlow(ctx, "manual", "roof", nil), "group roof not found")
+
+	require.Equal(t, "manual", c.Owner(front))
+	require.Equal(t, "live", c.Owner(back))
+	require.Equal(t, "live", c.Owner(stair))
+	require.Equal(t, []string{"live", "manual"}, arb.Owners())
+
+	// writes to the lights of other flows are dropped
+	live, manual := arb.For("live"), arb.For("manual")
+	require.NoError(t, live.Set(front, true))
+	require.NoError(t, live.Set(back, true))
+	require.NoError(t, live.Set(stair, true))
+	requireState(t, hw, map[internal.LightAddress]bool{front: false, back: true, stair: true})
+	require.NoError(t, manual.Set(front, true))
+	require.NoError(t, manual.Set(back, false))
+	requireState(t, hw, map[internal.LightAddress]bool{front: true, back: true, stair: true})
+
+	// reset switches off only the own lights
+	require.NoError(t, live.Reset())
+	requireState(t, hw, map[internal.LightAddress]bool{front: true, back: false, stair: false})
+
+	// the flow losing all lights is stopped
+	require.NoError(t, c.AssignFlow(ctx, "manual", GroupAll, nil))
+	<-flows["manual"].started
+	require.Equal(t, []string{"manual"}, c.Running())
+}
+
+func TestArbiter_SetLight(t *testing.T) {
+	var (
+		a1 = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		a2 = internal.LightAddress{Board: 0x20, Pin: "A1"}
+	)
+	hw := lights.NewTestController([]uint8{0x20})
+	arb := NewArbiter(hw, nil)
+	arb.assign("live", []internal.LightAddress{a1, a2})
+
+	require.NoError(t, arb.SetLight(a1, true))
+	requireState(t, hw, map[internal.LightAddress]bool{a1: true})
+
+	// the click during the overlay is shadowed and applied when the overlay ends
+	arb.startOverlay("blink", []internal.LightAddress{a2})
+	require.NoError(t, arb.SetLight(a2, true))
+	requireState(t, hw, map[internal.LightAddress]bool{a2: false})
+	require.NoError(t, arb.endOverlay("blink"))
+	requireState(t, hw, map[internal.LightAddress]bool{a2: true})
+}
+
+func requireState(t *testing.T, hw lights.ControllerI, want map[internal.LightAddress]bool) {
+	t.Helper()
+
+	for addr, isOn := range want {
+		got, err := hw.IsOn(addr)
+		require.NoError(t, err)
+		require.Equal(t, isOn, got, "state of %v", addr)
+	}
+}
+
+// nestingFlow runs the child flow on its lights like the playlist step
+type nestingFlow struct {
+	name, child string
+	c           *Controller
+	nested      chan error
+}
+
+func (f *nestingFlow) Start(ctx context.Context) error {
+	f.nested <- f.c.RunNested(ctx, f.name, f.child, nil)
+	<-ctx.Done()
+	return nil
+}
+
+func (f *nestingFlow) Stop()        {}
+func (f *nestingFlow) Name() string { return f.name }
+
+func TestController_UnregisterNested(t *testing.T) {
+	var (
+		a1 = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		a2 = internal.LightAddress{Board: 0x20, Pin: "A1"}
+	)
+	mapping := [][]internal.Light{{{Number: 1, Addr: a1}, {Number: 2, Addr: a2}}}
+	arb := NewArbiter(lights.NewTestController([]uint8{0x20}), mapping)
+	child := &seededFlow{name: "script:chaser", seeds: make(chan State, 1), started: make(chan struct{}, 1)}
+	parent := &nestingFlow{name: "playlist:evening", child: child.name, nested: make(chan error, 1)}
+	c, err := NewController(clock.Real(), arb, Options{Transition: "none"}, parent, child)
+	require.NoError(t, err)
+	parent.c = c
+	go func() {
+		for range c.SubscribeToErrors() {
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, c.AssignFlow(ctx, parent.name, GroupAll, nil))
+	<-child.started
+	require.Equal(t, child.name, c.Owner(a1))
+
+	// the step is finished and the lights are returned to the playlist
+	c.Unregister(child.name)
+	require.NoError(t, <-parent.nested)
+	require.Equal(t, parent.name, c.Owner(a1))
+	require.Equal(t, parent.name, c.Owner(a2))
+	require.Equal(t, []string{parent.name}, c.Running())
+	require.NotContains(t, c.FlowNames(), child.name)
+}
@@ -2,7 +2,15 @@ package flow
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
 )
 
 // Flow is the interface for implementation of ligths patterns
@@ -12,18 +20,82 @@ type Flow interface {
 	Name() string
 }
 
-// Controller executes the selected flow
+// Controller executes the selected flows
+// Every flow owns a region of the building. Regions are assigned by the groups of lights
+// so different flows could run on different parts of the building simultaneously
 type Controller struct {
-	registry    []Flow
-	currentFlow Flow
-	errorChan   chan error
+	registry  []Flow
+	overlays  []Flow
+	arbiter   *Arbiter
+	clock     clock.Clock
+	errorChan chan error
+
+	transition         Transition
+	transitionDuration time.Duration
+
+	// switching serializes changes of the flows. Transitions are executed without mu
+	// because subscribers of the lights ask for the running flows
+	switching sync.Mutex
+
+	// changes signals that flows are switched
+	changes chan struct{}
+
+	mu          sync.RWMutex
+	assignments []Assignment
+	running     map[string]Flow
+	nested      map[string]*nestedRun
+	overlaid    map[string]*overlayRun
+	// rand orders the lights of the dissolve transition
+	rand *rand.Rand
 }
 
-func NewController(flows ...Flow) *Controller {
-	return &Controller{
-		registry:  flows,
-		errorChan: make(chan error),
+// Assignment is the selection of the flow on the group of lights
+// Flows in execution are reproduced by applying assignments in order
+type Assignment struct {
+	Flow   string `json:"flow"`
+	Group  string `json:"group"`
+	Params Params `json:"params,omitempty"`
+}
+
+// MaxOverlayDuration limits the time the overlay could keep the lights
+const MaxOverlayDuration = 10 * time.Minute
+
+// overlayRun is the overlay in execution
+type overlayRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// nestedRun is the flow executed on the lights of the parent flow
+type nestedRun struct {
+	parent string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewController(clk clock.Clock, arbiter *Arbiter, opts Options, flows ...Flow) (*Controller, error) {
+	t, err := ParseTransition(opts.Transition)
+	if err != nil {
+		return nil, err
 	}
+
+	if opts.TransitionDuration < 0 || opts.TransitionDuration > MaxTransitionDuration {
+		return nil, fmt.Errorf("transition duration must be in [0, %s], got %s", MaxTransitionDuration, opts.TransitionDuration)
+	}
+
+	return &Controller{
+		registry:           flows,
+		arbiter:            arbiter,
+		clock:              clk,
+		rand:               rand.New(rand.NewSource(clk.Now().UnixNano())), //no-lint: gosec
+		errorChan:          make(chan error),
+		changes:            make(chan struct{}, 1),
+		transition:         t,
+		transitionDuration: opts.TransitionDuration,
+		running:            make(map[string]Flow),
+		nested:             make(map[string]*nestedRun),
+		overlaid:           make(map[string]*overlayRun),
+	}, nil
 }
 
 // SubscribeToErrors returns the channel to subscribe to errors
@@ -31,28 +103,403 @@ func (c *Controller) SubscribeToErrors() <-chan error {
 	return c.errorChan
 }
 
-// SelectFlow selects the flow to be executed
-// previous flow will be stopped
+// SelectFlow selects the flow to be executed on the whole building
+// previous flows will be stopped
 func (c *Controller) SelectFlow(ctx context.Context, name string) error {
-	if c.currentFlow != nil {
-		c.currentFlow.Stop()
+	return c.SelectFlowWith(ctx, name, nil)
+}
+
+// SelectFlowWith selects the flow to be executed on the whole building with the flow specific params
+// previous flows will be stopped
+func (c *Controller) SelectFlowWith(ctx context.Context, name string, params Params) error {
+	return c.AssignFlow(ctx, name, GroupAll, params)
+}
+
+// AssignFlow executes the flow on the group of lights
+// Flows which lose all of their lights are stopped. Flow is restarted if it is already in execution
+func (c *Controller) AssignFlow(ctx context.Context, name, group string, params Params) error {
+	c.switching.Lock()
+	defer c.switching.Unlock()
+
+	c.mu.Lock()
+	if n, ok := c.nested[name]; ok {
+		// flow is executed inside another one, e.g. in the playlist. The owner has to be stopped first
+		top := c.top(name)
+		if r, ok := c.running[top]; ok {
+			r.Stop()
+			delete(c.running, top)
+		}
+		c.mu.Unlock()
+		<-n.done
+		c.mu.Lock()
 	}
 
-	for _, flow := range c.registry {
-		if flow.Name() == name {
-			c.currentFlow = flow
-			go func() {
-				c.errorChan <- flow.Start(ctx)
-			}()
+	f, t, d, err := c.prepare(name, group, params)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	c.applyTransition(ctx, f, t, d)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.running[name] = f
+	go func() {
+		c.errorChan <- f.Start(ctx)
+	}()
+
+	c.remember(Assignment{Flow: name, Group: group, Params: withoutTransition(params)})
+
+	return nil
+}
+
+// remember adds the assignment and forgets the ones of the stopped flows
+// must be called under the lock
+func (c *Controller) remember(a Assignment) {
+	if a.Group == GroupAll {
+		c.assignments = nil
+	}
+
+	c.assignments = slices.DeleteFunc(c.assignments, func(a Assignment) bool {
+		_, ok := c.running[a.Flow]
+		return !ok
+	})
+	c.assignments = append(c.assignments, a)
+
+	c.notify()
+}
+
+// notify signals about the change of the flows
+func (c *Controller) notify() {
+	select {
+	case c.changes <- struct{}{}:
+	default: // change is already signaled
+	}
+}
+
+// Changes returns the channel which signals that flows are switched
+func (c *Controller) Changes() <-chan struct{} {
+	return c.changes
+}
 
-			return nil
+// Assignments returns the assignments reproducing the flows in execution
+func (c *Controller) Assignments() []Assignment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.Clone(c.assignments)
+}
+
+// prepare stops the flow and gives it the lights of the group. Flows which lose all of their lights are stopped
+// must be called under the lock
+func (c *Controller) prepare(name, group string, params Params) (Flow, Transition, time.Duration, error) {
+	f, ok := c.flow(name)
+	if !ok {
+		return nil, "", 0, fmt.Errorf("flow %s not found", name)
+	}
+
+	g, ok := c.arbiter.group(group)
+	if !ok {
+		return nil, "", 0, fmt.Errorf("group %s not found", group)
+	}
+
+	t, d, params, err := c.transitionFrom(params)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	cfg, configurable := f.(Configurable)
+	if !configurable && len(params) > 0 {
+		return nil, "", 0, fmt.Errorf("flow %s doesn't accept params", name)
+	}
+
+	if r, ok := c.running[name]; ok {
+		r.Stop()
+		delete(c.running, name)
+	}
+
+	if configurable {
+		err := cfg.Configure(params)
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("couldn't configure flow %s: %w", name, err)
+		}
+	}
+
+	c.arbiter.assign(name, g.Lights)
+
+	// stop flows without lights
+	owned := c.ownedByTop()
+	for n, r := range c.running {
+		if owned[n] == 0 {
+			r.Stop()
+			delete(c.running, n)
+		}
+	}
+
+	return f, t, d, nil
+}
+
+// RunNested executes the flow on the lights of the parent flow until the flow is finished or the context is done
+// It is used by the flows composed from other flows. Lights are returned to the parent afterwards
+func (c *Controller) RunNested(ctx context.Context, parent, name string, params Params) error {
+	c.mu.Lock()
+
+	f, ok := c.flow(name)
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("flow %s not found", name)
+	}
+
+	_, isRunning := c.running[name]
+	_, isNested := c.nested[name]
+	if name == parent || isRunning || isNested {
+		c.mu.Unlock()
+		return fmt.Errorf("flow %s is already in execution", name)
+	}
+
+	t, d, params, err := c.transitionFrom(params)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+
+	cfg, configurable := f.(Configurable)
+	if !configurable && len(params) > 0 {
+		c.mu.Unlock()
+		return fmt.Errorf("flow %s doesn't accept params", name)
+	}
+
+	if configurable {
+		err := cfg.Configure(params)
+		if err != nil {
+			c.mu.Unlock()
+			return fmt.Errorf("couldn't configure flow %s: %w", name, err)
 		}
 	}
-	return fmt.Errorf("flow %s not found", name)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n := &nestedRun{parent: parent, cancel: cancel, done: make(chan struct{})}
+	c.nested[name] = n
+	c.arbiter.transfer(parent, name)
+	c.mu.Unlock()
+
+	c.applyTransition(ctx, f, t, d)
+
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.arbiter.transfer(name, parent)
+		delete(c.nested, name)
+		close(n.done)
+	}()
+
+	return runBounded(ctx, f)
+}
+
+// runBounded executes the flow until it is finished or the context is done
+func runBounded(ctx context.Context, f Flow) error {
+	result := make(chan error, 1)
+	go func() { result <- f.Start(ctx) }()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		f.Stop()
+		return <-result
+	}
+}
+
+// Overlay executes the overlay on the group of lights for the duration
+// Flows owning the lights keep running, their changes are applied when the overlay ends.
+// The overlay in execution is restarted, the latest overlay wins on the shared lights
+func (c *Controller) Overlay(ctx context.Context, name, group string, d time.Duration, params Params) error {
+	if d <= 0 || d > MaxOverlayDuration {
+		return fmt.Errorf("overlay duration must be in (0, %s], got %s", MaxOverlayDuration, d)
+	}
+
+	c.mu.Lock()
+	for {
+		run, ok := c.overlaid[name]
+		if !ok {
+			break
+		}
+		run.cancel()
+		c.mu.Unlock()
+		<-run.done
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.overlay(name)
+	if !ok {
+		return fmt.Errorf("overlay %s not found", name)
+	}
+
+	g, ok := c.arbiter.group(group)
+	if !ok {
+		return fmt.Errorf("group %s not found", group)
+	}
+
+	cfg, configurable := f.(Configurable)
+	if !configurable && len(params) > 0 {
+		return fmt.Errorf("overlay %s doesn't accept params", name)
+	}
+
+	if configurable {
+		err := cfg.Configure(params)
+		if err != nil {
+			return fmt.Errorf("couldn't configure overlay %s: %w", name, err)
+		}
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, d)
+	run := &overlayRun{cancel: cancel, done: make(chan struct{})}
+	c.overlaid[name] = run
+	c.arbiter.startOverlay(name, g.Lights)
+
+	go func() {
+		defer cancel()
+
+		err := runBounded(runCtx, f)
+		restoreErr := c.arbiter.endOverlay(name)
+
+		c.mu.Lock()
+		delete(c.overlaid, name)
+		close(run.done)
+		c.mu.Unlock()
+
+		c.errorChan <- errors.Join(err, restoreErr)
+	}()
+
+	return nil
+}
+
+// RegisterOverlay adds the overlay to the registry
+func (c *Controller) RegisterOverlay(f Flow) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.overlay(f.Name()); ok {
+		return fmt.Errorf("overlay %s is already registered", f.Name())
+	}
+
+	c.overlays = append(c.overlays, f)
+	return nil
+}
+
+// OverlayNames returns the list of available overlays
+func (c *Controller) OverlayNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := []string{}
+	for _, f := range c.overlays {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+// must be called under the lock
+func (c *Controller) overlay(name string) (Flow, bool) {
+	for _, f := range c.overlays {
+		if f.Name() == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// top returns the top-level flow for the nested one
+// must be called under the lock
+func (c *Controller) top(name string) string {
+	for {
+		n, ok := c.nested[name]
+		if !ok {
+			return name
+		}
+		name = n.parent
+	}
+}
+
+// ownedByTop returns the number of lights owned by the top-level flows including their nested flows
+// must be called under the lock
+func (c *Controller) ownedByTop() map[string]int {
+	result := map[string]int{}
+	for _, o := range c.arbiter.Owners() {
+		result[c.top(o)] += len(c.arbiter.Owned(o))
+	}
+	return result
+}
+
+// Register adds the flow to the registry
+func (c *Controller) Register(f Flow) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.flow(f.Name()); ok {
+		return fmt.Errorf("flow %s is already registered", f.Name())
+	}
+
+	c.registry = append(c.registry, f)
+	return nil
+}
+
+// Unregister removes the flow from the registry
+// Flow is stopped and its lights are released if it is in execution. Lights of the nested flow are returned to the parent
+func (c *Controller) Unregister(name string) {
+	c.switching.Lock()
+	defer c.switching.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n, ok := c.nested[name]; ok {
+		// RunNested gives the lights back to the parent, the parent continues with its next step
+		n.cancel()
+		c.mu.Unlock()
+		<-n.done
+		c.mu.Lock()
+	}
+
+	if r, ok := c.running[name]; ok {
+		r.Stop()
+		delete(c.running, name)
+	}
+
+	c.arbiter.release(name)
+
+	c.assignments = slices.DeleteFunc(c.assignments, func(a Assignment) bool { return a.Flow == name })
+	c.notify()
+
+	for i, f := range c.registry {
+		if f.Name() == name {
+			c.registry = append(c.registry[:i:i], c.registry[i+1:]...)
+			return
+		}
+	}
+}
+
+// must be called under the lock
+func (c *Controller) flow(name string) (Flow, bool) {
+	for _, f := range c.registry {
+		if f.Name() == name {
+			return f, true
+		}
+	}
+	return nil, false
 }
 
 // FlowNames returns the list of available flows
 func (c *Controller) FlowNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	var flows []string
 	for _, flow := range c.registry {
 		flows = append(flows, flow.Name())
@@ -60,11 +507,51 @@ func (c *Controller) FlowNames() []string {
 	return flows
 }
 
-// FlowNames returns the list of available flows
+// Groups returns the names of the light groups flows could be assigned to
+func (c *Controller) Groups() []string {
+	return c.arbiter.Groups()
+}
+
+// Owner returns the name of the flow which owns the light
+func (c *Controller) Owner(addr internal.LightAddress) string {
+	return c.arbiter.Owner(addr)
+}
+
+// SetLight changes the light on behalf of the flow which owns it
+func (c *Controller) SetLight(addr internal.LightAddress, isOn bool) error {
+	return c.arbiter.SetLight(addr, isOn)
+}
+
+// Active returns the name of the flow which owns the most of the lights
 func (c *Controller) Active() string {
-	if c.currentFlow == nil {
-		return ""
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	active, max := "", 0
+	for n, owned := range c.ownedByTop() {
+		if _, ok := c.running[n]; !ok {
+			continue
+		}
+		if owned > max || (owned == max && n < active) {
+			active, max = n, owned
+		}
+	}
+
+	return active
+}
+
+// Running returns the names of the top-level flows in execution
+func (c *Controller) Running() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := []string{}
+	for n := range c.ownedByTop() {
+		if _, ok := c.running[n]; ok {
+			result = append(result, n)
+		}
 	}
+	slices.Sort(result)
 
-	return c.currentFlow.Name()
+	return result
 }
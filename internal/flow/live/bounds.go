@@ -0,0 +1,291 @@
+package live
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+// week is the period the time of the awake flats is evened out over
+const week = 7 * 24 * time.Hour
+
+// bounds keep the lit windows spread over the facade, the windows of the awake flats count as lit
+type bounds struct {
+	// limits are the fractions of the windows, zero max means no limit
+	minLit, maxLit   float64
+	minFace, maxFace float64
+	maxAdjacent      int
+
+	windows map[int][]internal.Light
+	total   int
+	faces   map[internal.Side]int
+	// adjacent are the windows of the same face next to the window in the row of the floor, above and below it
+	adjacent map[internal.LightAddress][]internal.LightAddress
+}
+
+func newBounds(mapping [][]internal.Light, opts Options) (*bounds, error) {
+	for _, v := range []struct {
+		name    string
+		percent float64
+	}{{"min-lit", opts.MinLit}, {"max-lit", opts.MaxLit}, {"min-face-lit", opts.MinFaceLit}, {"max-face-lit", opts.MaxFaceLit}} {
+		if v.percent < 0 || v.percent > 100 {
+			return nil, fmt.Errorf("%s must be from 0 to 100 percent, got %g", v.name, v.percent)
+		}
+	}
+	if opts.MaxLit > 0 && opts.MinLit > opts.MaxLit {
+		return nil, fmt.Errorf("min-lit %g is above max-lit %g", opts.MinLit, opts.MaxLit)
+	}
+	if opts.MaxFaceLit > 0 && opts.MinFaceLit > opts.MaxFaceLit {
+		return nil, fmt.Errorf("min-face-lit %g is above max-face-lit %g", opts.MinFaceLit, opts.MaxFaceLit)
+	}
+	if opts.MaxAdjacent < 0 {
+		return nil, fmt.Errorf("max-adjacent must not be negative, got %d", opts.MaxAdjacent)
+	}
+
+	b := &bounds{
+		minLit:      opts.MinLit / 100,
+		maxLit:      opts.MaxLit / 100,
+		minFace:     opts.MinFaceLit / 100,
+		maxFace:     opts.MaxFaceLit / 100,
+		maxAdjacent: opts.MaxAdjacent,
+		windows:     map[int][]internal.Light{},
+		faces:       map[internal.Side]int{},
+		adjacent:    map[internal.LightAddress][]internal.LightAddress{},
+	}
+
+	type key struct {
+		floor, pos int
+		side       internal.Side
+	}
+	grid := map[key]internal.LightAddress{}
+	for floor, row := range mapping {
+		var (
+			prev *internal.Light
+			pos  = map[internal.Side]int{}
+		)
+		for i, light := range row {
+			switch {
+			case light.Kind == internal.LightTypeWallStub:
+				continue // walls don't split the row
+			case light.Number == 0:
+				prev = nil // the stairwell does
+				continue
+			}
+
+			b.windows[light.Number] = append(b.windows[light.Number], light)
+			b.faces[light.Side]++
+			b.total++
+
+			if prev != nil && prev.Side == light.Side {
+				b.adjacent[light.Addr] = append(b.adjacent[light.Addr], prev.Addr)
+				b.adjacent[prev.Addr] = append(b.adjacent[prev.Addr], light.Addr)
+			}
+			k := key{floor: floor, pos: pos[light.Side], side: light.Side}
+			if below, ok := grid[key{floor: floor - 1, pos: k.pos, side: k.side}]; ok {
+				b.adjacent[light.Addr] = append(b.adjacent[light.Addr], below)
+				b.adjacent[below] = append(b.adjacent[below], light.Addr)
+			}
+			grid[k] = light.Addr
+			pos[light.Side]++
+			prev = &row[i]
+		}
+	}
+
+	return b, nil
+}
+
+// lit counts the lit windows of the building and of every face
+func (b *bounds) lit(awake []int) (int, map[internal.Side]int) {
+	total, faces := 0, map[internal.Side]int{}
+	for _, n := range awake {
+		for _, w := range b.windows[n] {
+			faces[w.Side]++
+			total++
+		}
+	}
+	return total, faces
+}
+
+// wants returns 1 if more windows should be lit, -1 if some windows should be dark and 0 if the bounds are kept
+// The faces returned are the ones breaking the bounds, empty if the whole building does
+func (b *bounds) wants(awake []int) (int, map[internal.Side]bool) {
+	total, faces := b.lit(awake)
+	below, above := map[internal.Side]bool{}, map[internal.Side]bool{}
+	for side, windows := range b.faces {
+		if float64(faces[side]) < b.minFace*float64(windows) {
+			below[side] = true
+		}
+		if b.maxFace > 0 && float64(faces[side]) > b.maxFace*float64(windows) {
+			above[side] = true
+		}
+	}
+
+	switch {
+	case float64(total) < b.minLit*float64(b.total):
+		return 1, map[internal.Side]bool{}
+	case len(below) > 0:
+		return 1, below
+	case b.maxLit > 0 && float64(total) > b.maxLit*float64(b.total):
+		return -1, map[internal.Side]bool{}
+	case len(above) > 0:
+		return -1, above
+	}
+
+	return 0, nil
+}
+
+// allows reports whether the flat could arrive or leave without breaking the bounds
+// Only the faces of the flat are checked, so the flat which fixes one face is fine if the others were broken before
+func (b *bounds) allows(awake []int, flat int, arriving bool) bool {
+	total, faces := b.lit(awake)
+	own := map[internal.Side]int{}
+	for _, w := range b.windows[flat] {
+		own[w.Side]++
+	}
+
+	if !arriving {
+		if float64(total-len(b.windows[flat])) < b.minLit*float64(b.total) {
+			return false
+		}
+		for side, n := range own {
+			if float64(faces[side]-n) < b.minFace*float64(b.faces[side]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if b.maxLit > 0 && float64(total+len(b.windows[flat])) > b.maxLit*float64(b.total) {
+		return false
+	}
+	for side, n := range own {
+		if b.maxFace > 0 && float64(faces[side]+n) > b.maxFace*float64(b.faces[side]) {
+			return false
+		}
+	}
+
+	return b.maxAdjacent == 0 || b.cluster(append(slices.Clip(awake), flat), flat) <= b.maxAdjacent
+}
+
+// touches returns the number of the faces the flat has windows on
+func (b *bounds) touches(flat int, faces map[internal.Side]bool) int {
+	touched := map[internal.Side]bool{}
+	for _, w := range b.windows[flat] {
+		if faces[w.Side] {
+			touched[w.Side] = true
+		}
+	}
+	return len(touched)
+}
+
+// cluster returns the size of the largest group of the lit windows next to each other the windows of the flat are in
+func (b *bounds) cluster(awake []int, flat int) int {
+	lit := map[internal.LightAddress]bool{}
+	for _, n := range awake {
+		for _, w := range b.windows[n] {
+			lit[w.Addr] = true
+		}
+	}
+
+	largest, seen := 0, map[internal.LightAddress]bool{}
+	for _, w := range b.windows[flat] {
+		if seen[w.Addr] {
+			continue
+		}
+		size, queue := 0, []internal.LightAddress{w.Addr}
+		seen[w.Addr] = true
+		for len(queue) > 0 {
+			addr := queue[0]
+			queue = queue[1:]
+			size++
+			for _, next := range b.adjacent[addr] {
+				if lit[next] && !seen[next] {
+					seen[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+		largest = max(largest, size)
+	}
+
+	return largest
+}
+
+// ledger keeps the time the flats were awake during the last week
+type ledger struct {
+	stays []awakeTime
+}
+
+type awakeTime struct {
+	flat         int
+	since, until time.Time
+}
+
+func (lg *ledger) add(flat int, since, until time.Time) {
+	if until.After(since) {
+		lg.stays = append(lg.stays, awakeTime{flat: flat, since: since, until: until})
+	}
+}
+
+// week returns the time every flat was awake during the week before the moment
+// The stays in progress are counted till the moment
+func (lg *ledger) week(now time.Time, awake map[int]stay) map[int]time.Duration {
+	from := now.Add(-week)
+	kept := lg.stays[:0]
+	for _, s := range lg.stays {
+		if s.until.After(from) {
+			kept = append(kept, s)
+		}
+	}
+	lg.stays = kept
+
+	result := map[int]time.Duration{}
+	count := func(flat int, since, until time.Time) {
+		if since.Before(from) {
+			since = from
+		}
+		if until.After(now) {
+			until = now
+		}
+		if until.After(since) {
+			result[flat] += until.Sub(since)
+		}
+	}
+	for _, s := range lg.stays {
+		count(s.flat, s.since, s.until)
+	}
+	for n, s := range awake {
+		count(n, s.since, now)
+	}
+
+	return result
+}
+
+// shares returns the multipliers of the chances of the flats to wake up
+// The flats awake less than the others during the week get the larger share, strength 0 keeps the chances equal
+func (lg *ledger) shares(now time.Time, flats []int, awake map[int]stay, strength float64) map[int]float64 {
+	result := make(map[int]float64, len(flats))
+	if strength <= 0 || len(flats) == 0 {
+		for _, n := range flats {
+			result[n] = 1
+		}
+		return result
+	}
+
+	times := lg.week(now, awake)
+	mean := 0.0
+	for _, n := range flats {
+		mean += times[n].Hours()
+	}
+	mean /= float64(len(flats))
+
+	for _, n := range flats {
+		// an hour is added, so the first stays of the week don't swing the chances
+		result[n] = math.Pow((mean+1)/(times[n].Hours()+1), strength)
+	}
+
+	return result
+}
@@ -0,0 +1,238 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+)
+
+// maxDryRun limits the span of the dry run, it is executed on the board as well
+const maxDryRun = 7 * 24 * time.Hour
+
+// names of the light properties in the timeline
+var (
+	sideNames = map[internal.Side]string{
+		internal.SideFront: "front",
+		internal.SideRight: "right",
+		internal.SideBack:  "back",
+		internal.SideLeft:  "left",
+	}
+	kindNames = map[internal.LightType]string{
+		internal.LightTypeServiceEntrance:  "entrance",
+		internal.LightTypeServiceNoManLand: "landing",
+		internal.LightTypeShortWindow:      "short",
+		internal.LightTypeLongWindow:       "long",
+	}
+)
+
+// Timeline is the result of the live mode executed on the virtual clock
+type Timeline struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	Seed int64     `json:"seed"`
+	// Personas are the personas of the flats by number
+	Personas map[int]string `json:"personas"`
+	// Lights are sorted by flat, the stairwell is the flat 0
+	Lights []LightTimeline `json:"lights"`
+}
+
+// LightTimeline is the periods the light was on
+type LightTimeline struct {
+	Flat int                   `json:"flat"`
+	Side string                `json:"side"`
+	Kind string                `json:"kind"`
+	Addr internal.LightAddress `json:"addr"`
+	Lit  []Period              `json:"lit"`
+	// Total is the time the light was on during the span
+	Total time.Duration `json:"-"`
+}
+
+// Period is the time the light was on, lights which are on at the end of the span are cut by it
+type Period struct {
+	On  time.Time `json:"on"`
+	Off time.Time `json:"off"`
+}
+
+// DryRun executes the live mode with the params on the virtual clock for the span and returns the timeline of the lights
+// Nothing is switched on the hardware
+func (l *Live) DryRun(ctx context.Context, from, to time.Time, p flow.Params) (Timeline, error) {
+	l.mu.RLock()
+	opts := l.base
+	l.mu.RUnlock()
+
+	err := flow.ApplyParams(&opts, p)
+	if err != nil {
+		return Timeline{}, err
+	}
+
+	return DryRun(ctx, l.mapping, opts, from, to)
+}
+
+// DryRun executes the live mode on the virtual clock with the test controller for the span and returns the timeline of the lights
+// Zero seed is replaced by the random one, it is returned in the timeline
+func DryRun(ctx context.Context, mapping [][]internal.Light, opts Options, from, to time.Time) (Timeline, error) {
+	if !to.After(from) {
+		return Timeline{}, errors.New("end of the dry run must be after the start")
+	}
+	if to.Sub(from) > maxDryRun {
+		return Timeline{}, fmt.Errorf("dry run is limited to %s, got %s", maxDryRun, to.Sub(from))
+	}
+	err := validate(mapping, opts)
+	if err != nil {
+		return Timeline{}, err
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+
+	clk := clock.NewFake(from)
+	rec := &recorder{
+		ctrl:    lights.NewTestController(nil),
+		clock:   clk,
+		changes: map[internal.LightAddress][]recorded{},
+	}
+
+	l := New(clk, rec, mapping, opts)
+	l.log = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// the flow is stopped only by Stop, so it always waits for the clock while the span isn't over
+	result := make(chan error, 1)
+	go func() { result <- l.Start(context.Background()) }()
+
+	for clk.Now().Before(to) {
+		clk.BlockUntil(1)
+		if ctx.Err() != nil {
+			l.Stop()
+			<-result
+			return Timeline{}, ctx.Err()
+		}
+		clk.AdvanceToNext()
+	}
+	clk.BlockUntil(1) // changes of the last moment are recorded
+
+	l.Stop()
+	err = <-result
+	if err != nil {
+		return Timeline{}, fmt.Errorf("live mode has failed: %w", err)
+	}
+
+	t := rec.timeline(mapping, from, to)
+	t.Seed = opts.Seed
+	t.Personas = l.Personas()
+
+	return t, nil
+}
+
+// ParseSpan parses the start and the end of the dry run as 'HH:MM' of the day or 'YYYY-MM-DD HH:MM'
+// The end before the start is on the next day, e.g. 18:00-02:00 is the night
+func ParseSpan(day time.Time, from, to string) (time.Time, time.Time, error) {
+	start, err := parseMoment(day, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := parseMoment(start, to)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end: %w", err)
+	}
+	if !end.After(start) {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	return start, end, nil
+}
+
+func parseMoment(day time.Time, raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	t, err := time.ParseInLocation("2006-01-02 15:04", raw, day.Location())
+	if err == nil {
+		return t, nil
+	}
+	t, err = time.Parse("15:04", raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is neither 'HH:MM' nor 'YYYY-MM-DD HH:MM'", raw)
+	}
+
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location()), nil
+}
+
+type recorded struct {
+	at   time.Time
+	isOn bool
+}
+
+// recorder keeps the changes of the lights switched by the live mode
+// Live mode runs in the single goroutine, so the recorder isn't locked
+type recorder struct {
+	ctrl    *lights.TestController
+	clock   clock.Clock
+	changes map[internal.LightAddress][]recorded
+}
+
+func (r *recorder) Set(addr internal.LightAddress, isON bool) error {
+	was, err := r.ctrl.IsOn(addr)
+	if err != nil {
+		return err
+	}
+	if was != isON {
+		r.changes[addr] = append(r.changes[addr], recorded{at: r.clock.Now(), isOn: isON})
+	}
+
+	return r.ctrl.Set(addr, isON)
+}
+
+func (r *recorder) Reset() error {
+	for addr, changes := range r.changes {
+		if changes[len(changes)-1].isOn {
+			r.changes[addr] = append(changes, recorded{at: r.clock.Now(), isOn: false})
+		}
+	}
+
+	return r.ctrl.Reset()
+}
+
+// timeline returns the periods the lights were on cut by the span
+func (r *recorder) timeline(mapping [][]internal.Light, from, to time.Time) Timeline {
+	result := Timeline{From: from, To: to, Lights: []LightTimeline{}}
+	for _, row := range mapping {
+		for _, light := range row {
+			if light.Addr.Pin == "" {
+				continue
+			}
+
+			lt := LightTimeline{Flat: light.Number, Side: sideNames[light.Side], Kind: kindNames[light.Kind], Addr: light.Addr, Lit: []Period{}}
+			var on time.Time
+			for _, c := range r.changes[light.Addr] {
+				switch {
+				case !c.at.Before(to):
+					// the last moment could be after the end of the span
+				case c.isOn && on.IsZero():
+					on = c.at
+				case !c.isOn && !on.IsZero():
+					lt.Lit = append(lt.Lit, Period{On: on, Off: c.at})
+					on = time.Time{}
+				}
+			}
+			if !on.IsZero() {
+				lt.Lit = append(lt.Lit, Period{On: on, Off: to})
+			}
+			for _, p := range lt.Lit {
+				lt.Total += p.Off.Sub(p.On)
+			}
+
+			result.Lights = append(result.Lights, lt)
+		}
+	}
+	sort.SliceStable(result.Lights, func(i, j int) bool { return result.Lights[i].Flat < result.Lights[j].Flat })
+
+	return result
+}
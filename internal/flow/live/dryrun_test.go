@@ -0,0 +1,89 @@
+package live
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	opts := Options{
+		MaxDelay:   30 * time.Second,
+		FlatTTL:    time.Hour,
+		ServiceTTL: 20 * time.Second,
+		FloorTime:  4 * time.Second,
+		MaxChanges: 25,
+		Seed:       42,
+	}
+	from := time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+
+	first, err := DryRun(context.Background(), internal.BuildingMap.Levels, opts, from, to)
+	require.NoError(t, err)
+	second, err := DryRun(context.Background(), internal.BuildingMap.Levels, opts, from, to)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+
+	require.Equal(t, int64(42), first.Seed)
+	require.Equal(t, 0, first.Lights[0].Flat, "stairwell goes first")
+	lit := 0
+	for _, lt := range first.Lights {
+		for _, p := range lt.Lit {
+			require.False(t, p.On.Before(from), "%v is on before the start", lt.Addr)
+			require.False(t, p.Off.After(to), "%v is on after the end", lt.Addr)
+			require.True(t, p.Off.After(p.On) || p.Off.Equal(p.On))
+		}
+		if lt.Flat != 0 && lt.Total > 0 {
+			lit++
+		}
+	}
+	require.Greater(t, lit, 20)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, first.Write(buf, FormatCSV))
+	rows, err := csv.NewReader(buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, "flat", rows[0][0])
+	require.Greater(t, len(rows), 100)
+
+	buf.Reset()
+	require.NoError(t, first.Write(buf, FormatHTML))
+	require.Contains(t, buf.String(), "Flat 1")
+
+	require.Error(t, first.Write(buf, "pdf"))
+
+	_, err = DryRun(context.Background(), internal.BuildingMap.Levels, opts, to, from)
+	require.Error(t, err)
+}
+
+func TestParseSpan(t *testing.T) {
+	day := time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		from, to string
+		want     []time.Time
+		wantErr  bool
+	}{
+		{name: "evening", from: "18:00", to: "23:00", want: []time.Time{
+			time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC), time.Date(2024, 1, 5, 23, 0, 0, 0, time.UTC)}},
+		{name: "night", from: "18:00", to: "02:00", want: []time.Time{
+			time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC), time.Date(2024, 1, 6, 2, 0, 0, 0, time.UTC)}},
+		{name: "dates", from: "2024-12-31 20:00", to: "2025-01-01 03:00", want: []time.Time{
+			time.Date(2024, 12, 31, 20, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)}},
+		{name: "bad", from: "evening", to: "02:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := ParseSpan(day, tt.from, tt.to)
+			require.Equal(t, tt.wantErr, err != nil, "err: %v", err)
+			if err == nil {
+				require.Equal(t, tt.want, []time.Time{from, to})
+			}
+		})
+	}
+}
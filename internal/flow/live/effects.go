@@ -0,0 +1,169 @@
+package live
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+const (
+	effectSteady   = "steady"
+	effectTV       = "tv"
+	effectLamp     = "lamp"
+	effectParty    = "party"
+	effectBathroom = "bathroom"
+)
+
+// effect expands the lit period of the window into the changes of the light
+// Effects with the zero params keep the window steady
+type effect func(r *rand.Rand, lit time.Duration, opts Options) windowSchedule
+
+var effects = map[string]effect{
+	effectSteady:   steady,
+	effectTV:       tv,
+	effectLamp:     steady, // lights can't be dimmed, the bedside lamp is the steady light
+	effectParty:    party,
+	effectBathroom: bathroom,
+}
+
+func steady(_ *rand.Rand, lit time.Duration, _ Options) windowSchedule {
+	return windowSchedule{{isOn: true, duration: lit}}
+}
+
+// tv goes dark for a moment when the picture changes
+func tv(r *rand.Rand, lit time.Duration, opts Options) windowSchedule {
+	if opts.TVInterval <= 0 {
+		return steady(r, lit, opts)
+	}
+
+	return toggles(lit, func(isOn bool) time.Duration {
+		if isOn {
+			return randomizeDuration(r, opts.TVInterval, 0.8)
+		}
+		return time.Duration(50+r.Intn(250)) * time.Millisecond
+	})
+}
+
+// party alternates the bursts of the rapid toggles with the steady light
+func party(r *rand.Rand, lit time.Duration, opts Options) windowSchedule {
+	if opts.PartyBurst <= 0 {
+		return steady(r, lit, opts)
+	}
+
+	result := windowSchedule{}
+	for burst := true; lit > 0; burst = !burst {
+		d := min(lit, randomizeDuration(r, opts.PartyBurst, 0.5))
+		lit -= d
+		if !burst {
+			result = append(result, steady(r, d, opts)...)
+			continue
+		}
+		result = append(result, toggles(d, func(bool) time.Duration {
+			return time.Duration(200+r.Intn(300)) * time.Millisecond
+		})...)
+	}
+
+	return result
+}
+
+// bathroom is lit for a short while and dark for the rest of the period
+func bathroom(r *rand.Rand, lit time.Duration, opts Options) windowSchedule {
+	if opts.BathroomTime <= 0 {
+		return steady(r, lit, opts)
+	}
+
+	on := min(lit, randomizeDuration(r, opts.BathroomTime, 0.5))
+	result := windowSchedule{{isOn: true, duration: on}}
+	if lit > on {
+		result = append(result, windowSchedule{{isOn: false, duration: lit - on}}...)
+	}
+
+	return result
+}
+
+// toggles switches the light on and off starting from on until the period is over
+func toggles(period time.Duration, next func(isOn bool) time.Duration) windowSchedule {
+	result := windowSchedule{}
+	for isOn := true; period > 0; isOn = !isOn {
+		d := min(period, next(isOn))
+		result = append(result, windowSchedule{{isOn: isOn, duration: d}}...)
+		period -= d
+	}
+
+	return result
+}
+
+type weighted struct {
+	effect string
+	weight float64
+}
+
+// chooseEffect selects the effect of the lit period by the room, the time of day and the persona
+// Short windows are kitchens and bathrooms, long windows are living rooms and bedrooms
+func chooseEffect(r *rand.Rand, kind internal.LightType, p Persona, at time.Time) string {
+	var (
+		h     = at.Hour()
+		night = h >= 23 || h < 6
+		// nights after Friday and Saturday
+		weekendNight = (h >= 21 && (at.Weekday() == time.Friday || at.Weekday() == time.Saturday)) ||
+			(h < 4 && (at.Weekday() == time.Saturday || at.Weekday() == time.Sunday))
+		choice []weighted
+	)
+
+	switch {
+	case kind == internal.LightTypeShortWindow && night:
+		choice = []weighted{{effectBathroom, 3}, {effectSteady, 1}}
+	case kind == internal.LightTypeShortWindow:
+		choice = []weighted{{effectSteady, 3}, {effectBathroom, 1}}
+	case weekendNight:
+		choice = []weighted{{effectParty, 1}, {effectTV, 1}, {effectSteady, 2}}
+	case night:
+		choice = []weighted{{effectLamp, 2}, {effectTV, 0.5}, {effectSteady, 1}}
+	case h >= 18:
+		choice = []weighted{{effectTV, 2}, {effectSteady, 2}}
+	default:
+		choice = []weighted{{effectSteady, 3}, {effectTV, 0.5}}
+	}
+
+	total := 0.0
+	for i := range choice {
+		if like, ok := p.likes[choice[i].effect]; ok {
+			choice[i].weight *= like
+		}
+		total += choice[i].weight
+	}
+
+	x := r.Float64() * total
+	for _, c := range choice {
+		x -= c.weight
+		if x < 0 {
+			return c.effect
+		}
+	}
+
+	return choice[len(choice)-1].effect
+}
+
+// withEffects expands the lit periods of the schedule starting from the moment by the effects of the window
+// It returns the start of the first party as well, zero time if there is no party
+func withEffects(r *rand.Rand, schedule windowSchedule, at time.Time, kind internal.LightType, p Persona, opts Options) (windowSchedule, time.Time) {
+	var (
+		result  = windowSchedule{}
+		partyAt time.Time
+	)
+	for _, s := range schedule {
+		if s.isOn {
+			e := chooseEffect(r, kind, p, at)
+			if e == effectParty && partyAt.IsZero() {
+				partyAt = at
+			}
+			result = append(result, effects[e](r, s.duration, opts)...)
+		} else {
+			result = append(result, s)
+		}
+		at = at.Add(s.duration)
+	}
+
+	return result, partyAt
+}
@@ -0,0 +1,62 @@
+package live
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffects(t *testing.T) {
+	opts := Options{TVInterval: 2 * time.Second, PartyBurst: 15 * time.Second, BathroomTime: 3 * time.Minute}
+	tests := []struct {
+		effect  string
+		lit     time.Duration
+		minimum int // changes of the light
+	}{
+		{effect: effectSteady, lit: time.Hour, minimum: 1},
+		{effect: effectLamp, lit: time.Hour, minimum: 1},
+		{effect: effectTV, lit: time.Minute, minimum: 20},
+		{effect: effectParty, lit: time.Minute, minimum: 30},
+		{effect: effectBathroom, lit: time.Hour, minimum: 2},
+		{effect: effectBathroom, lit: time.Minute, minimum: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.effect, func(t *testing.T) {
+			got := effects[tt.effect](rand.New(rand.NewSource(1)), tt.lit, opts)
+
+			require.GreaterOrEqual(t, len(got), tt.minimum)
+			require.True(t, got[0].isOn)
+			total := time.Duration(0)
+			for _, s := range got {
+				require.Positive(t, s.duration)
+				total += s.duration
+			}
+			require.Equal(t, tt.lit, total)
+		})
+	}
+}
+
+func Test_chooseEffect(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	friday := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	pensioner, _ := personaByName("pensioner")
+
+	count := func(kind internal.LightType, p Persona, at time.Time) map[string]int {
+		result := map[string]int{}
+		for i := 0; i < 1000; i++ {
+			result[chooseEffect(r, kind, p, at)]++
+		}
+		return result
+	}
+
+	night := count(internal.LightTypeShortWindow, personas[0], friday.Add(2*time.Hour))
+	require.Greater(t, night[effectBathroom], night[effectSteady])
+
+	party := count(internal.LightTypeLongWindow, personas[0], friday.Add(22*time.Hour))
+	require.Positive(t, party[effectParty])
+	require.Zero(t, count(internal.LightTypeLongWindow, pensioner, friday.Add(22*time.Hour))[effectParty])
+	require.Zero(t, count(internal.LightTypeLongWindow, personas[0], friday.Add(12*time.Hour))[effectParty])
+}
@@ -0,0 +1,246 @@
+package live
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+const (
+	// EventNewYear wakes up the building for the countdown, almost every window is lit at midnight
+	EventNewYear = "new-year"
+	// EventOutage switches off the whole building for a few minutes
+	EventOutage = "outage"
+	// EventFootball is the match evening: everybody watches TV and boils the kettle at the half-time
+	EventFootball = "football"
+)
+
+const (
+	newYearCountdown = 10 * time.Minute
+	newYearLit       = 15 * time.Minute
+	newYearParty     = time.Hour
+	outageTime       = 3 * time.Minute
+	footballHalfTime = 45 * time.Minute
+	footballBreak    = 15 * time.Minute
+	footballMatch    = 2 * time.Hour
+)
+
+// EventNames returns the names of the building events
+func EventNames() []string {
+	return []string{EventNewYear, EventOutage, EventFootball}
+}
+
+// Trigger starts the building event now
+func (l *Live) Trigger(name string) error {
+	if !slices.Contains(EventNames(), name) {
+		return fmt.Errorf("unknown event '%s', known are %s", name, strings.Join(EventNames(), ", "))
+	}
+
+	l.mu.RLock()
+	s := l.session
+	l.mu.RUnlock()
+	if s == nil {
+		return errors.New("live mode isn't running")
+	}
+
+	select {
+	case s.lp.inbox <- func() error { return s.startEvent(name) }:
+		return nil
+	default:
+		return errors.New("another event is being started")
+	}
+}
+
+// dated is the building event planned by date
+type dated struct {
+	name string
+	// year is zero for the events repeated every year
+	year                     int
+	month, day, hour, minute int
+}
+
+// parseEvents parses 'event@MM-DD HH:MM' (every year) and 'event@YYYY-MM-DD HH:MM' (once) items separated by commas
+func parseEvents(raw string) ([]dated, error) {
+	result := []dated{}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, when, ok := strings.Cut(item, "@")
+		if !ok {
+			return nil, fmt.Errorf("event '%s' is not in the event@date form", item)
+		}
+		name = strings.TrimSpace(name)
+		if !slices.Contains(EventNames(), name) {
+			return nil, fmt.Errorf("unknown event '%s', known are %s", name, strings.Join(EventNames(), ", "))
+		}
+
+		d := dated{name: name}
+		when = strings.TrimSpace(when)
+		t, err := time.Parse("2006-01-02 15:04", when)
+		if err == nil {
+			d.year = t.Year()
+		} else {
+			t, err = time.Parse("01-02 15:04", when)
+			if err != nil {
+				return nil, fmt.Errorf("date of event '%s' is neither 'MM-DD HH:MM' nor 'YYYY-MM-DD HH:MM'", item)
+			}
+		}
+		d.month, d.day, d.hour, d.minute = int(t.Month()), t.Day(), t.Hour(), t.Minute()
+		result = append(result, d)
+	}
+
+	return result, nil
+}
+
+// next returns the moment of the event after the moment, false if the event is in the past
+func (d dated) next(after time.Time) (time.Time, bool) {
+	year := d.year
+	if year == 0 {
+		year = after.Year()
+	}
+
+	at := time.Date(year, time.Month(d.month), d.day, d.hour, d.minute, 0, 0, after.Location())
+	if at.After(after) {
+		return at, true
+	}
+	if d.year != 0 {
+		return time.Time{}, false
+	}
+
+	return at.AddDate(1, 0, 0), true
+}
+
+func (s *session) planDated(d dated) {
+	at, ok := d.next(s.lp.clock.Now())
+	if !ok {
+		return
+	}
+
+	s.lp.at(at, func() error {
+		s.planDated(d)
+		return s.startEvent(d.name)
+	})
+}
+
+func (s *session) startEvent(name string) error {
+	if s.event != "" {
+		s.l.log.Info("building event is skipped, another one is in progress", slog.String("event", name), slog.String("current", s.event))
+		return nil
+	}
+
+	s.l.log.Info("building event starts", slog.String("event", name))
+	s.event = name
+	defer s.publish()
+
+	switch name {
+	case EventNewYear:
+		return s.newYear()
+	case EventOutage:
+		return s.outage()
+	case EventFootball:
+		return s.football()
+	}
+
+	return nil
+}
+
+func (s *session) endEvent() {
+	s.l.log.Info("building event ends", slog.String("event", s.event))
+	s.event, s.boost, s.mood = "", 0, nil
+	s.publish()
+}
+
+// newYear wakes up everybody at home, lights almost every window at midnight and parties after
+func (s *session) newYear() error {
+	s.boost = 1
+	s.mood = map[string]float64{effectParty: 5}
+
+	s.lp.after(newYearCountdown, func() error {
+		lit := []internal.LightAddress{}
+		for _, n := range s.residents.numbers {
+			if s.personas[n].away {
+				continue
+			}
+			for _, w := range s.residents.windows[n] {
+				if s.l.rand.Float64() < 0.95 {
+					lit = append(lit, w.Addr)
+				}
+			}
+		}
+
+		err := s.l.power.hold(lit, true)
+		if err != nil {
+			return err
+		}
+		s.lp.after(newYearLit, func() error { return s.l.power.release(lit) })
+		s.lp.after(newYearParty, func() error {
+			s.endEvent()
+			return nil
+		})
+		return nil
+	})
+
+	return nil
+}
+
+// outage switches off every light of the building, the lights return to the state of the flats
+func (s *session) outage() error {
+	all := []internal.LightAddress{}
+	for _, row := range s.l.mapping {
+		for _, light := range row {
+			if light.Addr.Pin != "" {
+				all = append(all, light.Addr)
+			}
+		}
+	}
+
+	err := s.l.power.hold(all, false)
+	if err != nil {
+		return err
+	}
+
+	s.lp.after(randomizeDuration(s.l.rand, outageTime, 0.6), func() error {
+		defer s.endEvent()
+		return s.l.power.release(all)
+	})
+
+	return nil
+}
+
+// football keeps the flats watching TV, the kitchens are lit at the half-time
+func (s *session) football() error {
+	s.boost = 0.3
+	s.mood = map[string]float64{effectTV: 5}
+
+	s.lp.after(footballHalfTime, func() error {
+		kitchens := []internal.LightAddress{}
+		for _, n := range s.residents.flats(true) {
+			for _, w := range s.residents.windows[n] {
+				if w.Kind == internal.LightTypeShortWindow {
+					kitchens = append(kitchens, w.Addr)
+				}
+			}
+		}
+
+		err := s.l.power.hold(kitchens, true)
+		if err != nil {
+			return err
+		}
+		s.lp.after(footballBreak, func() error { return s.l.power.release(kitchens) })
+		return nil
+	})
+	s.lp.after(footballMatch, func() error {
+		s.endEvent()
+		return nil
+	})
+
+	return nil
+}
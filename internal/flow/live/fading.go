@@ -0,0 +1,66 @@
+package live
+
+import (
+	"sync"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+)
+
+// fading keeps windows left on by the previous flow until they are switched off or taken by the flat cycle
+type fading struct {
+	mu    sync.Mutex
+	addrs map[internal.LightAddress]bool
+}
+
+func newFading(mapping [][]internal.Light, seed flow.State) *fading {
+	f := &fading{addrs: map[internal.LightAddress]bool{}}
+	for _, row := range mapping {
+		for _, w := range row {
+			if w.Number != 0 && seed[w.Addr] {
+				f.addrs[w.Addr] = true
+			}
+		}
+	}
+	return f
+}
+
+// pending returns the windows waiting to be switched off
+func (f *fading) pending() []internal.LightAddress {
+	if f == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]internal.LightAddress, 0, len(f.addrs))
+	for addr := range f.addrs {
+		result = append(result, addr)
+	}
+	return result
+}
+
+// take reports whether the window is still waiting and removes it
+func (f *fading) take(addr internal.LightAddress) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ok := f.addrs[addr]
+	delete(f.addrs, addr)
+	return ok
+}
+
+// forget removes windows controlled by the flat cycle
+func (f *fading) forget(addrs []internal.LightAddress) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, addr := range addrs {
+		delete(f.addrs, addr)
+	}
+}
@@ -0,0 +1,168 @@
+package live
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats of the dry run timeline
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatHTML = "html"
+)
+
+// csvTime keeps the milliseconds, the flicker is shorter than a second
+const csvTime = "2006-01-02T15:04:05.000Z07:00"
+
+//go:embed gantt.gotmpl
+var ganttRaw string
+
+var ganttTmpl = template.Must(template.New("gantt").Parse(ganttRaw))
+
+// ContentType returns the content type of the timeline format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// Write writes the timeline in the format: json, csv or html
+func (t Timeline) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON, "":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	case FormatCSV:
+		return t.writeCSV(w)
+	case FormatHTML:
+		return ganttTmpl.Execute(w, t.gantt())
+	}
+
+	return fmt.Errorf("unknown format '%s', known are %s, %s, %s", format, FormatJSON, FormatCSV, FormatHTML)
+}
+
+// writeCSV writes a row for every period the light was on
+func (t Timeline) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"flat", "persona", "side", "kind", "board", "pin", "on", "off", "seconds"})
+	if err != nil {
+		return fmt.Errorf("couldn't write csv header: %w", err)
+	}
+
+	for _, lt := range t.Lights {
+		for _, p := range lt.Lit {
+			err = cw.Write([]string{
+				strconv.Itoa(lt.Flat),
+				t.Personas[lt.Flat],
+				lt.Side,
+				lt.Kind,
+				fmt.Sprintf("0x%x", lt.Addr.Board),
+				lt.Addr.Pin,
+				p.On.Format(csvTime),
+				p.Off.Format(csvTime),
+				strconv.FormatFloat(p.Off.Sub(p.On).Seconds(), 'f', 1, 64),
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't write csv row: %w", err)
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+type ganttContext struct {
+	Timeline
+	Hours  []ganttHour
+	Groups []ganttGroup
+}
+
+type ganttHour struct {
+	Label string
+	Left  float64
+}
+
+type ganttGroup struct {
+	Title string
+	Rows  []ganttRow
+}
+
+type ganttRow struct {
+	Label string
+	Total time.Duration
+	Bars  []ganttBar
+}
+
+type ganttBar struct {
+	Left, Width float64
+	Title       string
+}
+
+// gantt lays out the periods of the lights as the bars in percents of the span grouped by flat
+func (t Timeline) gantt() ganttContext {
+	var (
+		span   = t.To.Sub(t.From)
+		result = ganttContext{Timeline: t}
+		pct    = func(d time.Duration) float64 { return float64(d) / float64(span) * 100 }
+		// the periods closer than the pixel of the wide screen are merged, the flicker isn't visible anyway
+		gap = span / 2000
+	)
+
+	step := time.Hour
+	if span > 2*24*time.Hour {
+		step = 6 * time.Hour
+	}
+	for h := t.From.Truncate(time.Hour); !h.After(t.To); h = h.Add(step) {
+		if h.Before(t.From) {
+			continue
+		}
+		result.Hours = append(result.Hours, ganttHour{Label: h.Format("15:04"), Left: pct(h.Sub(t.From))})
+	}
+
+	for _, lt := range t.Lights {
+		title := "Stairwell"
+		if lt.Flat != 0 {
+			title = fmt.Sprintf("Flat %d", lt.Flat)
+			if p := t.Personas[lt.Flat]; p != "" {
+				title += ", " + p
+			}
+		}
+		if len(result.Groups) == 0 || result.Groups[len(result.Groups)-1].Title != title {
+			result.Groups = append(result.Groups, ganttGroup{Title: title})
+		}
+
+		row := ganttRow{Label: fmt.Sprintf("%s %s %s", lt.Side, lt.Kind, lt.Addr.Pin), Total: lt.Total.Round(time.Minute)}
+		merged := []Period{}
+		for _, p := range lt.Lit {
+			if n := len(merged); n > 0 && p.On.Sub(merged[n-1].Off) < gap {
+				merged[n-1].Off = p.Off
+				continue
+			}
+			merged = append(merged, p)
+		}
+		for _, p := range merged {
+			row.Bars = append(row.Bars, ganttBar{
+				Left:  pct(p.On.Sub(t.From)),
+				Width: pct(p.Off.Sub(p.On)),
+				Title: fmt.Sprintf("%s - %s", p.On.Format("15:04:05"), p.Off.Format("15:04:05")),
+			})
+		}
+		g := &result.Groups[len(result.Groups)-1]
+		g.Rows = append(g.Rows, row)
+	}
+
+	return result
+}
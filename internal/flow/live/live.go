@@ -4,12 +4,17 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"math/rand"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/mbobakov/khrushchevka/internal"
-	"golang.org/x/sync/errgroup"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/spf13/afero"
 )
 
 type LightsController interface {
@@ -18,98 +23,204 @@ type LightsController interface {
 }
 
 const (
-	name = "live"
+	// FlowName is the name of the flow in the registry
+	FlowName = "live"
+	// LearnedName is the name of the live mode driven by the learned model
+	LearnedName = "learned"
 )
 
-// 1. once in maxDelay we are selecting randow flat
-// 2. then send signal to enable service lights in stack for Service TTL
-// 3. Livetime of random flat is ttl +/- 40% randomly
-// 4. if ttl is reached, then switch off all lights in flat
-// 5. During live time each window of the flat performing a program generated for the live time
-// 6. Program contains <maxchanges> changes of light state with random delay between 0 and <maxInternalDelay>
+// 1. once in maxDelay we are comparing awake flats with the occupancy curve of the hour
+// 2. random flat arrives if there are less flats than expected, random awake flat leaves if there are more
+//    the bounds of the lit windows win over the curve, flats awake less during the week are preferred
+// 3. then resident walks the stairwell: the entrance flickers, landings are lit one by one for Service TTL
+// 4. Livetime of random flat is ttl +/- 40% randomly
+// 5. if ttl is reached, then switch off all lights in flat
+// 6. During live time each window of the flat performing a program generated for the live time
+// 7. Program contains <maxchanges> changes of light state with random delay between 0 and <maxInternalDelay>
 //     max live time for the interval in program : <livetime>/<maxchanges> +/- 30%
 
 type Options struct {
 	MaxDelay   time.Duration `long:"max-delay" env:"MAX_DELAY" default:"30s" description:"max delay between flat selection"`
 	FlatTTL    time.Duration `long:"flat-ttl" env:"FLAT_TTL" default:"5h" description:"flat live time"`
-	ServiceTTL time.Duration `long:"service-ttl" env:"SERVICE_TTL" default:"20s" description:"service live time"`
+	ServiceTTL time.Duration `long:"service-ttl" env:"SERVICE_TTL" default:"20s" description:"time the landing is lit after the resident has passed it"`
+	FloorTime  time.Duration `long:"floor-time" env:"FLOOR_TIME" default:"4s" description:"time the resident climbs one floor"`
 	MaxChanges uint          `long:"max-changes" env:"MAX_CHANGES" default:"25" description:"max changes in flat per window"`
+	Seed       int64         `long:"seed" env:"SEED" default:"0" description:"seed of the randomness to replay the session, 0 means random seed"`
+	Weekday    string        `long:"weekday" env:"WEEKDAY" default:"0.15,0.08,0.05,0.03,0.03,0.08,0.3,0.45,0.25,0.1,0.08,0.08,0.1,0.1,0.1,0.12,0.2,0.4,0.6,0.75,0.8,0.75,0.55,0.3" description:"expected fraction of the awake flats for every hour of the weekday, empty keeps the building busy around the clock"`
+	Weekend    string        `long:"weekend" env:"WEEKEND" default:"0.3,0.2,0.1,0.05,0.03,0.03,0.05,0.1,0.25,0.4,0.45,0.4,0.35,0.35,0.3,0.3,0.35,0.45,0.6,0.7,0.75,0.75,0.6,0.45" description:"expected fraction of the awake flats for every hour of the weekend"`
+	Guests     float64       `long:"guests" env:"GUESTS" default:"0.05" description:"chance of the guest visit between the awake flats on every flat selection"`
+	PartyWake  float64       `long:"party-wake" env:"PARTY_WAKE" default:"0.5" description:"chance of the neighbours above and below the party to wake up"`
+	Events     string        `long:"events" env:"EVENTS" default:"new-year@12-31 23:50" description:"building events by date: 'event@MM-DD HH:MM' every year or 'event@YYYY-MM-DD HH:MM' once, separated by commas"`
+	Model      string        `long:"model" env:"MODEL" default:"./model.json" description:"path to the model learned from the journal by cmd/learn, used by the learned mode"`
+	Personas   string        `long:"personas" env:"PERSONAS" default:"random" description:"personas of the flats: 'random' and 'flat=persona' items separated by commas, unlisted flats are regular unless random is set"`
+
+	// window effects, zero keeps the window steady
+	TVInterval   time.Duration `long:"tv-interval" env:"TV_INTERVAL" default:"2s" description:"mean time between the flickers of the TV"`
+	PartyBurst   time.Duration `long:"party-burst" env:"PARTY_BURST" default:"15s" description:"mean length of the light bursts of the party"`
+	BathroomTime time.Duration `long:"bathroom-time" env:"BATHROOM_TIME" default:"3m" description:"mean time the light is on in the bathroom or the kitchen"`
+	BulbInterval time.Duration `long:"bulb-interval" env:"BULB_INTERVAL" default:"10m" description:"mean time between the blinks of the failing entrance bulb, 0 disables"`
+
+	// spread of the lit windows over the facade, zero disables the limit
+	MinLit      float64 `long:"min-lit" env:"MIN_LIT" default:"0" description:"minimum percent of the lit windows of the building"`
+	MaxLit      float64 `long:"max-lit" env:"MAX_LIT" default:"0" description:"maximum percent of the lit windows of the building"`
+	MinFaceLit  float64 `long:"min-face-lit" env:"MIN_FACE_LIT" default:"5" description:"minimum percent of the lit windows of every face of the building"`
+	MaxFaceLit  float64 `long:"max-face-lit" env:"MAX_FACE_LIT" default:"0" description:"maximum percent of the lit windows of every face of the building"`
+	MaxAdjacent int     `long:"max-adjacent" env:"MAX_ADJACENT" default:"0" description:"maximum number of the lit windows next to each other on a face"`
+	Fairness    float64 `long:"fairness" env:"FAIRNESS" default:"1" description:"strength of evening out the time the flats are awake during the week"`
 }
 
+// Live is executed in the single goroutine by the event loop, so the same seed gives the same timeline of the lights
 type Live struct {
+	name    string
 	lights  LightsController
+	power   *power
+	ledger  *ledger
 	mapping [][]internal.Light
+	clock   clock.Clock
+	base    Options
 	opts    Options
 	done    chan struct{}
 	log     *slog.Logger
 	rand    *rand.Rand
+	// fs is set for the learned mode, the model is read on every start
+	fs    afero.Fs
+	model *Model
+
+	mu        sync.RWMutex
+	isActive  bool
+	inherited flow.State
+	fading    *fading
+	status    Status
+	personas  map[int]string
+	session   *session
+}
+
+// Status is the state of the building in the live mode
+type Status struct {
+	Occupancy Occupancy    `json:"occupancy"`
+	Event     string       `json:"event,omitempty"`
+	Flats     []FlatStatus `json:"flats"`
+}
 
-	mu       sync.RWMutex
-	isActive bool
+// FlatStatus is the stay of the flat, the flat is sleeping if it isn't awake
+type FlatStatus struct {
+	Number  int        `json:"number"`
+	Persona string     `json:"persona"`
+	Awake   bool       `json:"awake"`
+	Since   *time.Time `json:"since,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
 }
 
 //go:generate ../../../bin/moq -out mocks_test.go . LightsController
-func New(l LightsController, mapping [][]internal.Light, opts Options) *Live {
+func New(clk clock.Clock, l LightsController, mapping [][]internal.Light, opts Options) *Live {
+	p := newPower(l)
 	return &Live{
-		lights:  l,
+		name:    FlowName,
+		lights:  p,
+		power:   p,
+		ledger:  &ledger{},
+		clock:   clk,
+		base:    opts,
 		opts:    opts,
 		mapping: mapping,
-		log:     slog.With("flow", name),
-		rand:    rand.New(rand.NewSource(time.Now().UnixNano())), //no-lint: gosec
+		log:     slog.With("flow", FlowName),
 		done:    make(chan struct{}),
 	}
 }
 
+// NewLearned creates the live mode whose flats and windows follow the model learned from the journal
+func NewLearned(clk clock.Clock, fs afero.Fs, l LightsController, mapping [][]internal.Light, opts Options) *Live {
+	result := New(clk, l, mapping, opts)
+	result.name = LearnedName
+	result.fs = fs
+	result.log = slog.With("flow", LearnedName)
+	return result
+}
+
 func (l *Live) Name() string {
-	return name
+	return l.name
 }
 
-func (l *Live) serviceOn(ctx context.Context, sig <-chan struct{}, ttl time.Duration) error {
-	allServiceLights := []internal.LightAddress{}
-	for _, l := range l.mapping {
-		for _, f := range l {
-			if f.Kind == internal.LightTypeServiceNoManLand {
-				allServiceLights = append(allServiceLights, f.Addr)
-			}
-		}
+// Configure applies params on top of the options the flow was created with
+func (l *Live) Configure(p flow.Params) error {
+	opts := l.base
+	err := flow.ApplyParams(&opts, p)
+	if err != nil {
+		return err
+	}
+	err = validate(l.mapping, opts)
+	if err != nil {
+		return err
 	}
 
-	t := time.NewTimer(ttl)
-	for {
-		select {
-		case <-sig:
-			// switch on all service lights
-			for _, v := range allServiceLights {
-				err := l.lights.Set(v, true)
-				if err != nil {
-					return fmt.Errorf("couldn't switch on light '%v': %w", v, err)
-				}
-			}
-			t.Reset(ttl)
-		case <-ctx.Done():
-			return nil
-		case <-t.C:
-			// switch off all service lights
-			for _, v := range allServiceLights {
-				err := l.lights.Set(v, false)
-				if err != nil {
-					return fmt.Errorf("couldn't switch off light '%v': %w", v, err)
-				}
-			}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.opts = opts
 
-		}
+	return nil
+}
+
+// validate checks the options parsed on the start of the flow
+func validate(mapping [][]internal.Light, opts Options) error {
+	_, err := parseCurves(opts)
+	if err != nil {
+		return err
+	}
+	_, err = parseCasting(opts.Personas)
+	if err != nil {
+		return err
 	}
+	_, err = parseEvents(opts.Events)
+	if err != nil {
+		return err
+	}
+	_, err = newBounds(mapping, opts)
+	return err
+}
+
+// Seed keeps the lights as they are on the next start
+// Windows which are on fade out one by one unless their flat is selected before
+func (l *Live) Seed(state flow.State) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inherited = state
 }
 
 func (l *Live) Start(ctx context.Context) error {
-	l.log.Info("starting flow", slog.Any("opts", l.opts))
-	l.done = make(chan struct{})
 	l.mu.Lock()
+	l.done = make(chan struct{})
 	l.isActive = true
+	inherited := l.inherited
+	l.inherited = nil
+	l.fading = newFading(l.mapping, inherited)
+	opts := l.opts
+	l.status = Status{}
+	var err error
+	if l.fs != nil {
+		l.model, err = LoadModel(l.fs, opts.Model)
+	}
 	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	daily, err := parseCurves(opts)
+	if err != nil {
+		return err
+	}
+	events, err := parseEvents(opts.Events)
+	if err != nil {
+		return err
+	}
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = l.clock.Now().UnixNano()
+	}
+	l.rand = rand.New(rand.NewSource(seed)) //no-lint: gosec
+	l.log.Info("starting flow", slog.Any("opts", opts), slog.Int64("seed", seed))
 
-	// switch on entrance light
-	l.log.Info("swithching off all lights but entrance")
 	entrance := internal.Light{}
 LOOP:
 	for _, row := range l.mapping {
@@ -121,9 +232,15 @@ LOOP:
 		}
 	}
 
-	err := l.lights.Reset()
-	if err != nil {
-		return fmt.Errorf("couldn't switch off lights: %w", err)
+	if l.power != nil {
+		l.power.forget()
+	}
+	if inherited == nil {
+		l.log.Info("swithching off all lights but entrance")
+		err := l.lights.Reset()
+		if err != nil {
+			return fmt.Errorf("couldn't switch off lights: %w", err)
+		}
 	}
 
 	err = l.lights.Set(entrance.Addr, true)
@@ -131,7 +248,22 @@ LOOP:
 		return fmt.Errorf("couldn't switch on entrance light '%v': %w", entrance.Addr, err)
 	}
 
-	return l.mainCycle(ctx)
+	l.log.Info("starting real life flow", slog.Any("opts", opts))
+	s, err := l.newSession(daily)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.session = s
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.session = nil
+		l.mu.Unlock()
+	}()
+
+	return s.run(ctx, events)
 }
 
 func (l *Live) Stop() {
@@ -140,115 +272,108 @@ func (l *Live) Stop() {
 
 	if l.isActive {
 		l.log.Info("stopping flow")
+		l.status = Status{}
 		l.isActive = false
 		close(l.done)
 	}
 }
 
-func (l *Live) mainCycle(ctx context.Context) error {
-	slog.Info("starting real life flow", slog.Any("opts", l.opts))
-	var (
-		serviceOnChan       = make(chan struct{})
-		nextFlatSelectionIn = time.Duration(0)
-		timer               = time.NewTimer(l.opts.MaxDelay)
-		onGoing             = map[int]bool{}
-		flats               = []int{}
-	)
-	// collect flats
-	for _, l := range l.mapping {
-		for _, w := range l {
-			if w.Number != 0 {
-				flats = append(flats, w.Number)
-			}
-		}
-	}
-
-	// make error group to wait for all goroutines
-	group, ctx := errgroup.WithContext(ctx)
-
-	// Start service lights
-	group.Go(func() error { return l.serviceOn(ctx, serviceOnChan, l.opts.ServiceTTL) })
-
-	for {
-		l.log.Info("next flat selection in", slog.Duration("duration", nextFlatSelectionIn))
+// Occupancy returns the target of the awake flats for the current hour
+// It reports false if the flow isn't running
+func (l *Live) Occupancy() (Occupancy, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-		timer.Reset(nextFlatSelectionIn)
+	return l.status.Occupancy, l.isActive && l.status.Occupancy.Flats > 0
+}
 
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-l.done:
-			return nil
-		case <-timer.C:
-			if len(onGoing) == len(flats) {
-				l.log.Info("all flats are busy, waiting for next flat selection")
-				continue
-			}
+// Status returns the stays of the flats, it reports false if the flow isn't running
+func (l *Live) Status() (Status, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-			selectedFlat := 0
-			for {
-				nominant := flats[l.rand.Intn(len(flats))]
-				if onGoing[nominant] {
-					continue
-				}
-				selectedFlat = nominant
-				onGoing[selectedFlat] = true
-				break
-			}
+	s := l.status
+	s.Flats = slices.Clone(s.Flats)
+	return s, l.isActive && s.Occupancy.Flats > 0
+}
 
-			l.log.Info("selected flat", slog.Int("flat", selectedFlat))
+// Personas returns the names of the personas by flat since the last start
+func (l *Live) Personas() map[int]string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-			serviceOnChan <- struct{}{}
+	return maps.Clone(l.personas)
+}
 
-			// start the flat routine
-			group.Go(func() error {
-				err := l.flatCycle(ctx, group, selectedFlat)
-				if err != nil {
-					l.log.Error("couldn't process flat cycle", slog.Int("flat", selectedFlat), slog.Any("err", err))
-					return fmt.Errorf("couldn't process flat cycle: %w", err)
-				}
+// flatCycle plans the windows of the flat and returns the start of the party in the flat if any
+func (l *Live) flatCycle(lp *loop, flat int, at time.Time, flatWindows []internal.Light, ttl time.Duration, persona Persona, alive func() bool) (time.Time, bool) {
+	l.log.Info("flat cycle", slog.Int("flat", flat), slog.Duration("ttl", ttl))
 
-				delete(onGoing, selectedFlat)
-				return nil
-			})
+	// flat is alive now, windows left by the previous flow are under its control
+	addrs := make([]internal.LightAddress, 0, len(flatWindows))
+	for _, fw := range flatWindows {
+		addrs = append(addrs, fw.Addr)
+	}
+	l.fadingLights().forget(addrs)
 
-			nextFlatSelectionIn = randomizeDuration(l.rand, l.opts.MaxDelay, 0.4)
+	// Start flat live
+	var firstParty time.Time
+	for _, fw := range flatWindows {
+		var schedule windowSchedule
+		if l.model != nil {
+			schedule = l.model.schedule(l.rand, flat, at, ttl)
+		} else {
+			schedule = getWindowSchedule(l.rand, ttl, persona.maxChanges(l.opts.MaxChanges))
 		}
+		schedule, partyAt := withEffects(l.rand, schedule, at, fw.Kind, persona, l.opts)
+		if !partyAt.IsZero() && (firstParty.IsZero() || partyAt.Before(firstParty)) {
+			firstParty = partyAt
+		}
+		l.log.Debug("window schedule", slog.Int("flat", flat), slog.Any("addr", fw.Addr), slog.String("schedule", schedule.String()))
+
+		l.planWindow(lp, at, schedule, fw.Addr, alive)
 	}
+
+	return firstParty, !firstParty.IsZero()
 }
 
-func (l *Live) flatCycle(ctx context.Context, group *errgroup.Group, flat int) error {
-	flatWindows := []internal.LightAddress{}
+func (l *Live) fadingLights() *fading {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-	for _, l := range l.mapping {
-		for _, w := range l {
-			if w.Number == flat && w.Kind != internal.LightTypeWallStub {
-				flatWindows = append(flatWindows, w.Addr)
-			}
-		}
+	return l.fading
+}
 
+// planFadeOut switches off windows left by the previous flow at the random moments during the max delay
+func (l *Live) planFadeOut(lp *loop) {
+	f := l.fadingLights()
+	addrs := f.pending()
+	if len(addrs) == 0 {
+		return
 	}
-	l.log.Info("flat cycle", slog.Int("flat", flat), slog.Duration("ttl", l.opts.FlatTTL))
 
-	// Start flat live
-	for _, fw := range flatWindows {
-		schedule := getWindowSchedule(l.rand, l.opts.FlatTTL, l.opts.MaxChanges)
-		l.log.Info("window schedule", slog.Int("flat", flat), slog.Any("addr", fw), slog.String("schedule", schedule.String()))
-
-		fw := fw
-		// Start window routine
-		group.Go(func() error { return l.executeScheduleFor(ctx, schedule, fw) })
-	}
+	l.log.Info("fading out windows of the previous flow", slog.Int("count", len(addrs)))
+	// pending windows come from the map, the order is fixed to keep the timeline reproducible
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].Board != addrs[j].Board {
+			return addrs[i].Board < addrs[j].Board
+		}
+		return addrs[i].Pin < addrs[j].Pin
+	})
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-l.done:
+	for _, addr := range addrs {
+		addr := addr
+		lp.after(time.Duration(l.rand.Int63n(int64(l.opts.MaxDelay)+1)), func() error {
+			if !f.take(addr) {
+				return nil
+			}
+			err := l.lights.Set(addr, false)
+			if err != nil {
+				return fmt.Errorf("couldn't switch off light '%v': %w", addr, err)
+			}
 			return nil
-		}
+		})
 	}
-
 }
 
 // executeScheduleFor executes the given schedule for a specific light address.
@@ -257,26 +382,32 @@ func (l *Live) flatCycle(ctx context.Context, group *errgroup.Group, flat int) e
 // If the context is done or the execution is interrupted, it returns nil.
 // If there is an error while switching the light, it returns an error with a formatted message.
 func (l *Live) executeScheduleFor(ctx context.Context, schedule windowSchedule, addr internal.LightAddress) error {
-	// Start executing program
-	timer := time.NewTimer(l.opts.FlatTTL)
-	for _, p := range schedule {
-		timer.Reset(p.duration)
+	lp := newLoop(l.clock)
+	l.planWindow(lp, lp.clock.Now(), schedule, addr, func() bool { return true })
+
+	return lp.run(ctx, l.done)
+}
+
+// planWindow plans the changes of the window by the schedule starting from the moment
+// The rest of the schedule is dropped when the flat isn't alive anymore
+func (l *Live) planWindow(lp *loop, at time.Time, schedule windowSchedule, addr internal.LightAddress, alive func() bool) {
+	if len(schedule) == 0 {
+		return
+	}
+
+	p := schedule[0]
+	lp.at(at, func() error {
+		if !alive() {
+			return nil
+		}
 		err := l.lights.Set(addr, p.isOn)
 		if err != nil {
 			return fmt.Errorf("couldn't switch light '%v': %w", addr, err)
 		}
 
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-l.done:
-			return nil
-		case <-timer.C:
-			continue
-		}
-	}
-
-	return nil
+		l.planWindow(lp, at.Add(p.duration), schedule[1:], addr, alive)
+		return nil
+	})
 }
 
 // randomizeDuration randomize time <t> in the border of +/- <fluctuation * 100 > percent
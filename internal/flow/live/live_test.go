@@ -2,12 +2,17 @@ package live
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"log/slog"
+	"math/rand"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -56,3 +61,356 @@ func TestLive_executeScheduleFor(t *testing.T) {
 		})
 	}
 }
+
+type change struct {
+	at   time.Duration
+	addr internal.LightAddress
+	isOn bool
+}
+
+// night runs the live flow on the fake clock and returns the timeline of the changes
+func night(t *testing.T, seed int64, length time.Duration) []change {
+	t.Helper()
+
+	opts := Options{
+		MaxDelay:   30 * time.Second,
+		FlatTTL:    5 * time.Hour,
+		ServiceTTL: 20 * time.Second,
+		MaxChanges: 25,
+		Seed:       seed,
+	}
+	return run(t, opts, length, func(*Live) {})
+}
+
+// run executes live mode from the Friday evening with the fake clock and returns the timeline of the lights
+// each is called after every step of the clock
+func run(t *testing.T, opts Options, length time.Duration, each func(l *Live)) []change {
+	t.Helper()
+
+	start := time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	timeline := []change{}
+
+	ctrlMoq := &LightsControllerMock{
+		SetFunc: func(addr internal.LightAddress, isON bool) error {
+			timeline = append(timeline, change{at: clk.Now().Sub(start), addr: addr, isOn: isON})
+			return nil
+		},
+		ResetFunc: func() error { return nil },
+	}
+
+	l := New(clk, ctrlMoq, internal.BuildingMap.Levels, opts)
+	l.log = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
+
+	result := make(chan error, 1)
+	go func() { result <- l.Start(context.Background()) }()
+
+	for clk.Now().Sub(start) < length {
+		clk.BlockUntil(1)
+		clk.AdvanceToNext()
+		each(l)
+	}
+	clk.BlockUntil(1) // events of the last moment are processed
+
+	l.Stop()
+	require.NoError(t, <-result)
+
+	return timeline
+}
+
+func TestLive_deterministic(t *testing.T) {
+	first := night(t, 42, 12*time.Hour)
+	second := night(t, 42, 12*time.Hour)
+	other := night(t, 43, 12*time.Hour)
+
+	require.Greater(t, len(first), 1000)
+	require.Equal(t, first, second)
+	require.NotEqual(t, first, other)
+}
+
+func TestLive_occupancy(t *testing.T) {
+	// nobody is at home before noon, the half of the flats is awake after
+	curve := strings.Repeat("0,", 12) + strings.TrimSuffix(strings.Repeat("0.5,", 12), ",")
+	flats := len(newResidents(internal.BuildingMap.Levels).numbers)
+
+	seen := map[int]Occupancy{}
+	run(t, Options{
+		MaxDelay:   30 * time.Second,
+		FlatTTL:    5 * time.Hour,
+		ServiceTTL: 20 * time.Second,
+		MaxChanges: 25,
+		Seed:       42,
+		Weekday:    curve,
+		Weekend:    curve,
+	}, 10*time.Hour, func(l *Live) {
+		if o, ok := l.Occupancy(); ok {
+			seen[o.Hour] = o
+		}
+	})
+
+	require.Equal(t, (flats+1)/2, seen[23].Target)
+	require.InDelta(t, seen[23].Target, seen[23].Awake, 1)
+	require.Equal(t, 0, seen[3].Target)
+	require.Equal(t, 0, seen[3].Awake)
+}
+
+func Test_parseCurve(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    float64 // value of the last hour
+		wantErr bool
+	}{
+		{name: "empty", raw: "", want: 1},
+		{name: "hourly", raw: strings.Repeat("0.1, ", 23) + "0.7", want: 0.7},
+		{name: "short", raw: "0.1,0.2", wantErr: true},
+		{name: "out of range", raw: strings.Repeat("0.1,", 23) + "1.5", wantErr: true},
+		{name: "not a number", raw: strings.Repeat("0.1,", 23) + "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCurve(tt.raw)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got[23])
+		})
+	}
+}
+
+func TestLive_personas(t *testing.T) {
+	away := map[internal.LightAddress]bool{}
+	for _, w := range newResidents(internal.BuildingMap.Levels).windows[1] {
+		away[w.Addr] = true
+	}
+
+	var personas map[int]string
+	timeline := run(t, Options{
+		MaxDelay:   30 * time.Second,
+		FlatTTL:    time.Hour,
+		ServiceTTL: 20 * time.Second,
+		MaxChanges: 25,
+		Seed:       42,
+		Personas:   "random, 1=vacation",
+	}, 12*time.Hour, func(l *Live) {
+		personas = l.Personas()
+	})
+
+	require.Equal(t, "vacation", personas[1])
+	for _, c := range timeline {
+		require.False(t, away[c.addr] && c.isOn, "window %v of the flat on vacation is on at %s", c.addr, c.at)
+	}
+}
+
+func Test_parseCasting(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[int]string
+		wantErr bool
+	}{
+		{name: "empty", raw: "", want: map[int]string{1: "regular", 2: "regular"}},
+		{name: "explicit", raw: "2=night-owl", want: map[int]string{1: "regular", 2: "night-owl"}},
+		{name: "unknown persona", raw: "2=astronaut", wantErr: true},
+		{name: "unknown flat", raw: "3=pensioner", wantErr: true},
+		{name: "not a pair", raw: "pensioner", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCasting(tt.raw)
+			if err == nil {
+				var assigned map[int]Persona
+				assigned, err = c.assign(rand.New(rand.NewSource(1)), []int{1, 2})
+				if err == nil {
+					got := map[int]string{}
+					for n, p := range assigned {
+						got[n] = p.Name
+					}
+					require.Equal(t, tt.want, got)
+				}
+			}
+			require.Equal(t, tt.wantErr, err != nil, "err: %v", err)
+		})
+	}
+}
+
+func TestLive_lifecycle(t *testing.T) {
+	stays := map[int]map[time.Time]bool{}
+	var last Status
+	run(t, Options{
+		MaxDelay:   30 * time.Second,
+		FlatTTL:    time.Hour,
+		ServiceTTL: 20 * time.Second,
+		FloorTime:  4 * time.Second,
+		MaxChanges: 25,
+		Seed:       42,
+	}, 12*time.Hour, func(l *Live) {
+		s, ok := l.Status()
+		if !ok {
+			return
+		}
+		last = s
+		for _, f := range s.Flats {
+			if !f.Awake {
+				// the stay of the sleeping flat is omitted
+				raw, err := json.Marshal(f)
+				require.NoError(t, err)
+				require.NotContains(t, string(raw), "since")
+				require.NotContains(t, string(raw), "until")
+				continue
+			}
+			if stays[f.Number] == nil {
+				stays[f.Number] = map[time.Time]bool{}
+			}
+			stays[f.Number][*f.Since] = true
+		}
+	})
+
+	// every flat is busy around the clock, so the flats leave after the stay and come back
+	require.Len(t, stays, len(last.Flats))
+	for n, s := range stays {
+		require.Greater(t, len(s), 5, "flat %d", n)
+	}
+	require.Equal(t, last.Occupancy.Flats, last.Occupancy.Target)
+	require.InDelta(t, last.Occupancy.Target, last.Occupancy.Awake, 2)
+}
+
+func TestLive_outage(t *testing.T) {
+	var events []string
+	timeline := run(t, Options{
+		MaxDelay:   30 * time.Second,
+		FlatTTL:    5 * time.Hour,
+		ServiceTTL: 20 * time.Second,
+		FloorTime:  4 * time.Second,
+		MaxChanges: 25,
+		Seed:       42,
+		Events:     "outage@2024-01-05 19:00",
+	}, 2*time.Hour, func(l *Live) {
+		if s, ok := l.Status(); ok && s.Event != "" && !slices.Contains(events, s.Event) {
+			events = append(events, s.Event)
+		}
+	})
+
+	lit := func(at time.Duration) int {
+		state := map[internal.LightAddress]bool{}
+		for _, c := range timeline {
+			if c.at > at {
+				break
+			}
+			state[c.addr] = c.isOn
+		}
+		result := 0
+		for _, isOn := range state {
+			if isOn {
+				result++
+			}
+		}
+		return result
+	}
+
+	require.Equal(t, []string{EventOutage}, events)
+	require.Greater(t, lit(time.Hour-time.Second), 10)
+	require.Zero(t, lit(time.Hour+time.Minute))
+	// the flats are lit again as they were before the outage
+	require.Greater(t, lit(time.Hour+5*time.Minute), 10)
+}
+
+func Test_parseEvents(t *testing.T) {
+	after := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		raw     string
+		want    []time.Time
+		wantErr bool
+	}{
+		{name: "empty", raw: "", want: []time.Time{}},
+		{name: "every year", raw: "new-year@12-31 23:50", want: []time.Time{time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)}},
+		{name: "passed this year", raw: "football@05-01 20:00", want: []time.Time{time.Date(2025, 5, 1, 20, 0, 0, 0, time.UTC)}},
+		{name: "once", raw: "outage@2024-06-02 19:00, football@2024-05-01 20:00", want: []time.Time{time.Date(2024, 6, 2, 19, 0, 0, 0, time.UTC), {}}},
+		{name: "unknown event", raw: "wedding@06-02 19:00", wantErr: true},
+		{name: "no date", raw: "outage", wantErr: true},
+		{name: "bad date", raw: "outage@tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := parseEvents(tt.raw)
+			require.Equal(t, tt.wantErr, err != nil, "err: %v", err)
+			if err != nil {
+				return
+			}
+			got := []time.Time{}
+			for _, e := range events {
+				at, _ := e.next(after)
+				got = append(got, at)
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLive_bounds(t *testing.T) {
+	opts := Options{
+		MaxDelay:    30 * time.Second,
+		FlatTTL:     time.Hour,
+		ServiceTTL:  20 * time.Second,
+		FloorTime:   4 * time.Second,
+		MaxChanges:  25,
+		Seed:        42,
+		Weekday:     strings.TrimSuffix(strings.Repeat("0.05,", 24), ","),
+		MinFaceLit:  20,
+		MaxFaceLit:  60,
+		MaxAdjacent: 8,
+	}
+	b, err := newBounds(internal.BuildingMap.Levels, opts)
+	require.NoError(t, err)
+
+	// flats leave by themselves after the stay, so the face could be below the minimum till the next selection
+	var broken time.Time
+	checked := 0
+	run(t, opts, 6*time.Hour, func(l *Live) {
+		s, ok := l.Status()
+		if !ok || l.clock.Now().Hour() < 19 {
+			return // the building is filled up during the first hour
+		}
+		awake := []int{}
+		for _, f := range s.Flats {
+			if f.Awake {
+				awake = append(awake, f.Number)
+			}
+		}
+
+		dir, _ := b.wants(awake)
+		switch {
+		case dir == 0:
+			broken = time.Time{}
+		case broken.IsZero():
+			broken = l.clock.Now()
+		default:
+			require.Less(t, l.clock.Now().Sub(broken), 5*opts.MaxDelay, "bounds are broken since %s, awake %v", broken, awake)
+		}
+		for _, n := range awake {
+			require.LessOrEqual(t, b.cluster(awake, n), 8, "flat %d at %s", n, l.clock.Now())
+		}
+		checked++
+	})
+	require.Greater(t, checked, 100)
+}
+
+func Test_ledger(t *testing.T) {
+	now := time.Date(2024, 1, 8, 12, 0, 0, 0, time.UTC)
+	lg := &ledger{}
+	lg.add(1, now.Add(-8*24*time.Hour), now.Add(-8*24*time.Hour+time.Hour)) // before the week
+	lg.add(1, now.Add(-7*24*time.Hour-time.Hour), now.Add(-7*24*time.Hour+2*time.Hour))
+	lg.add(2, now.Add(-10*time.Hour), now.Add(-5*time.Hour))
+
+	times := lg.week(now, map[int]stay{3: {since: now.Add(-time.Hour)}})
+	require.Equal(t, map[int]time.Duration{1: 2 * time.Hour, 2: 5 * time.Hour, 3: time.Hour}, times)
+	require.Len(t, lg.stays, 2)
+
+	shares := lg.shares(now, []int{1, 2, 3, 4}, nil, 1)
+	require.Greater(t, shares[4], shares[1])
+	require.Greater(t, shares[1], shares[2])
+	require.Equal(t, map[int]float64{1: 1, 2: 1}, lg.shares(now, []int{1, 2}, nil, 0))
+}
@@ -0,0 +1,94 @@
+package live
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/clock"
+)
+
+// event is the action of the flow planned at the moment
+type event struct {
+	at  time.Time
+	seq uint64
+	do  func() error
+}
+
+// loop executes events in the order of their time in the single goroutine
+// Events at the same moment are executed in the order of planning, so the timeline depends only on the seed
+type loop struct {
+	clock  clock.Clock
+	events eventHeap
+	seq    uint64
+	// inbox passes the actions from the other goroutines, they are executed while the loop waits
+	inbox chan func() error
+}
+
+func newLoop(c clock.Clock) *loop {
+	if c == nil {
+		// flows built without the constructor live in the real time
+		c = clock.Real()
+	}
+	return &loop{clock: c, inbox: make(chan func() error, 1)}
+}
+
+// at plans the action at the moment
+func (lp *loop) at(t time.Time, do func() error) {
+	lp.seq++
+	heap.Push(&lp.events, &event{at: t, seq: lp.seq, do: do})
+}
+
+// after plans the action after the duration from now
+func (lp *loop) after(d time.Duration, do func() error) {
+	lp.at(lp.clock.Now().Add(d), do)
+}
+
+// run executes events until there are no more of them, the context is done or the flow is stopped
+func (lp *loop) run(ctx context.Context, done <-chan struct{}) error {
+	for lp.events.Len() > 0 {
+		next := lp.events[0]
+
+		if wait := next.at.Sub(lp.clock.Now()); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-done:
+				return nil
+			case do := <-lp.inbox:
+				err := do()
+				if err != nil {
+					return err
+				}
+				continue
+			case <-lp.clock.After(wait):
+			}
+		}
+
+		heap.Pop(&lp.events)
+		err := next.do()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type eventHeap []*event
+
+func (h eventHeap) Len() int { return len(h) }
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x any)   { *h = append(*h, x.(*event)) }
+func (h *eventHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,217 @@
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/journal"
+	"github.com/spf13/afero"
+)
+
+const (
+	// modelStep is the step of the sampling of the learned windows
+	modelStep = time.Minute
+	// modelPrior is the time of the observation the statistics of the whole building are worth
+	// The hours with less observations of the flat look like the building
+	modelPrior = time.Hour
+)
+
+// Model is the Markov model of the windows learned from the journal
+// Lit and dark windows switch with the rates of the flat at the hour
+type Model struct {
+	LearnedAt time.Time `json:"learned_at"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	// Flats are the statistics of the windows of the flat by number
+	Flats map[int]*FlatModel `json:"flats"`
+	// Building are the statistics of all windows, they are used for the hours the flat wasn't observed
+	Building FlatModel `json:"building"`
+}
+
+// FlatModel is the statistics of the windows of the flat at every hour of the day
+type FlatModel struct {
+	Hours [24]HourStats `json:"hours"`
+}
+
+// HourStats is the time the windows were lit and dark and the number of the switches
+type HourStats struct {
+	Lit       time.Duration `json:"lit"`
+	Dark      time.Duration `json:"dark"`
+	SwitchOff int           `json:"switch_off"`
+	SwitchOn  int           `json:"switch_on"`
+}
+
+// Learn builds the model from the journal entries of the flows
+func Learn(mapping [][]internal.Light, entries []journal.Entry, flows []string) *Model {
+	m := &Model{LearnedAt: time.Now(), Flats: map[int]*FlatModel{}}
+
+	flat := map[internal.LightAddress]int{}
+	for _, row := range mapping {
+		for _, light := range row {
+			if light.Number != 0 && light.Addr.Pin != "" {
+				flat[light.Addr] = light.Number
+			}
+		}
+	}
+
+	for addr, periods := range journal.Periods(entries, flows) {
+		n, ok := flat[addr]
+		if !ok {
+			continue
+		}
+		for i, p := range periods {
+			m.flat(n).account(p.From, p.To, p.IsOn)
+			m.Building.account(p.From, p.To, p.IsOn)
+
+			// the switch is seen only if the light was observed right before it
+			if i == 0 || !periods[i-1].To.Equal(p.From) || periods[i-1].IsOn == p.IsOn {
+				continue
+			}
+			hours := &m.flat(n).Hours[p.From.Hour()]
+			building := &m.Building.Hours[p.From.Hour()]
+			if p.IsOn {
+				hours.SwitchOn++
+				building.SwitchOn++
+			} else {
+				hours.SwitchOff++
+				building.SwitchOff++
+			}
+		}
+	}
+	if len(entries) > 0 {
+		m.From, m.To = entries[0].At, entries[len(entries)-1].At
+	}
+
+	return m
+}
+
+func (m *Model) flat(n int) *FlatModel {
+	f, ok := m.Flats[n]
+	if !ok {
+		f = &FlatModel{}
+		m.Flats[n] = f
+	}
+	return f
+}
+
+// account adds the state of the window during the period split by the hours
+func (f *FlatModel) account(from, to time.Time, isOn bool) {
+	for from.Before(to) {
+		end := from.Truncate(time.Hour).Add(time.Hour)
+		if end.After(to) {
+			end = to
+		}
+		if isOn {
+			f.Hours[from.Hour()].Lit += end.Sub(from)
+		} else {
+			f.Hours[from.Hour()].Dark += end.Sub(from)
+		}
+		from = end
+	}
+}
+
+// Observed returns the time the windows were observed
+func (m *Model) Observed() time.Duration {
+	total := time.Duration(0)
+	for _, h := range m.Building.Hours {
+		total += h.Lit + h.Dark
+	}
+	return total
+}
+
+// stats returns the statistics of the flat at the hour backed by the statistics of the building
+func (m *Model) stats(flat, hour int) (own HourStats, building HourStats) {
+	if f, ok := m.Flats[flat]; ok {
+		own = f.Hours[hour]
+	}
+	return own, m.Building.Hours[hour]
+}
+
+// rate returns the number of the switches of the lit or dark window per hour
+func (m *Model) rate(flat, hour int, isOn bool) float64 {
+	own, building := m.stats(flat, hour)
+
+	count, spent := own.SwitchOn, own.Dark
+	bCount, bSpent := building.SwitchOn, building.Dark
+	if isOn {
+		count, spent = own.SwitchOff, own.Lit
+		bCount, bSpent = building.SwitchOff, building.Lit
+	}
+
+	// the building without observations switches once an hour
+	prior := (float64(bCount) + 1) / (bSpent.Hours() + 1)
+	return (float64(count) + prior*modelPrior.Hours()) / (spent.Hours() + modelPrior.Hours())
+}
+
+// activity returns the share of the time the windows of the flat were lit at the hour
+func (m *Model) activity(flat, hour int) float64 {
+	own, building := m.stats(flat, hour)
+
+	prior := 0.5
+	if total := building.Lit + building.Dark; total > 0 {
+		prior = building.Lit.Hours() / total.Hours()
+	}
+	return (own.Lit.Hours() + prior*modelPrior.Hours()) / ((own.Lit + own.Dark).Hours() + modelPrior.Hours())
+}
+
+// schedule samples the changes of the window of the flat from the moment for the ttl, the window is lit first
+func (m *Model) schedule(r *rand.Rand, flat int, at time.Time, ttl time.Duration) windowSchedule {
+	var (
+		result  = windowSchedule{}
+		isOn    = true
+		current time.Duration
+	)
+	for elapsed := time.Duration(0); elapsed < ttl; elapsed += modelStep {
+		step := min(modelStep, ttl-elapsed)
+		current += step
+
+		rate := m.rate(flat, at.Add(elapsed).Hour(), isOn)
+		if r.Float64() < 1-math.Exp(-rate*step.Hours()) {
+			result = append(result, windowSchedule{{isOn: isOn, duration: current}}...)
+			isOn, current = !isOn, 0
+		}
+	}
+	if current > 0 {
+		result = append(result, windowSchedule{{isOn: isOn, duration: current}}...)
+	}
+
+	return result
+}
+
+// Save writes the model to the file
+func (m *Model) Save(fs afero.Fs, path string) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal model: %w", err)
+	}
+
+	err = afero.WriteFile(fs, path, buf, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't write model '%s': %w", path, err)
+	}
+
+	return nil
+}
+
+// LoadModel reads the model from the file
+func LoadModel(fs afero.Fs, path string) (*Model, error) {
+	buf, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read model '%s': %w", path, err)
+	}
+
+	m := &Model{}
+	err = json.Unmarshal(buf, m)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal model '%s': %w", path, err)
+	}
+	if m.Flats == nil {
+		m.Flats = map[int]*FlatModel{}
+	}
+
+	return m, nil
+}
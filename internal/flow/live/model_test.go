@@ -0,0 +1,80 @@
+package live
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/journal"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLearn(t *testing.T) {
+	var first, second internal.Light
+	for _, row := range internal.BuildingMap.Levels {
+		for _, light := range row {
+			if light.Number == 0 || light.Addr.Pin == "" {
+				continue
+			}
+			if first.Number == 0 {
+				first = light
+			} else if light.Number != first.Number && second.Number == 0 {
+				second = light
+			}
+		}
+	}
+	require.NotZero(t, second.Number)
+
+	at := func(h, m int) time.Time { return time.Date(2024, 1, 5, h, m, 0, 0, time.UTC) }
+	entry := func(tm time.Time, l internal.Light, isOn bool, flow string) journal.Entry {
+		return journal.Entry{At: tm, Board: l.Addr.Board, Pin: l.Addr.Pin, IsOn: isOn, Flow: flow}
+	}
+	start := func(e journal.Entry) journal.Entry { e.Start = true; return e }
+
+	entries := []journal.Entry{
+		start(entry(at(20, 0), first, false, "manual")),
+		start(entry(at(20, 0), second, false, "live")),
+		entry(at(20, 30), first, true, "manual"),
+		entry(at(21, 0), second, true, "manual"),
+		entry(at(21, 15), first, false, "manual"),
+		entry(at(21, 30), second, true, "live"),
+		{At: at(22, 0), Stop: true},
+		// the server was down, nothing is observed till the next start
+		start(entry(at(23, 0), first, true, "manual")),
+		entry(at(23, 30), first, false, "manual"),
+	}
+
+	m := Learn(internal.BuildingMap.Levels, entries, []string{"manual"})
+	require.Equal(t, at(20, 0), m.From)
+	require.Equal(t, at(23, 30), m.To)
+
+	require.Equal(t, HourStats{Lit: 30 * time.Minute, Dark: 30 * time.Minute, SwitchOn: 1}, m.Flats[first.Number].Hours[20])
+	require.Equal(t, HourStats{Lit: 15 * time.Minute, Dark: 45 * time.Minute, SwitchOff: 1}, m.Flats[first.Number].Hours[21])
+	require.Equal(t, HourStats{}, m.Flats[first.Number].Hours[22])
+	require.Equal(t, HourStats{Lit: 30 * time.Minute, SwitchOff: 1}, m.Flats[first.Number].Hours[23])
+	require.Equal(t, HourStats{Lit: 30 * time.Minute}, m.Flats[second.Number].Hours[21], "observed only while owned by manual")
+	require.Equal(t, 3*time.Hour, m.Observed())
+
+	require.Greater(t, m.activity(first.Number, 23), m.activity(first.Number, 20))
+
+	r := rand.New(rand.NewSource(1))
+	s := m.schedule(r, first.Number, at(20, 0), 3*time.Hour)
+	total := time.Duration(0)
+	for i, p := range s {
+		require.Equal(t, i%2 == 0, p.isOn, "states alternate starting lit")
+		total += p.duration
+	}
+	require.Equal(t, 3*time.Hour, total)
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, m.Save(fs, "model.json"))
+	loaded, err := LoadModel(fs, "model.json")
+	require.NoError(t, err)
+	require.Equal(t, m.Flats, loaded.Flats)
+	require.Equal(t, m.Building, loaded.Building)
+
+	_, err = LoadModel(fs, "missing.json")
+	require.Error(t, err)
+}
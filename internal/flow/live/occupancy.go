@@ -0,0 +1,177 @@
+package live
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+// curve is the expected fraction of the awake flats for every hour of the day
+type curve [24]float64
+
+// parseCurve parses 24 comma separated fractions from 0 to 1
+// Empty curve keeps the building busy around the clock
+func parseCurve(raw string) (curve, error) {
+	if strings.TrimSpace(raw) == "" {
+		return constant(1), nil
+	}
+
+	c := curve{}
+	parts := strings.Split(raw, ",")
+	if len(parts) != len(c) {
+		return c, fmt.Errorf("curve must have %d hourly values, got %d", len(c), len(parts))
+	}
+	for h, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return c, fmt.Errorf("couldn't parse value of hour %d: %w", h, err)
+		}
+		if f < 0 || f > 1 {
+			return c, fmt.Errorf("value of hour %d must be from 0 to 1, got %g", h, f)
+		}
+		c[h] = f
+	}
+
+	return c, nil
+}
+
+// curves are the daily occupancy of the building
+type curves struct {
+	weekday curve
+	weekend curve
+}
+
+func parseCurves(opts Options) (curves, error) {
+	weekday, err := parseCurve(opts.Weekday)
+	if err != nil {
+		return curves{}, fmt.Errorf("invalid weekday curve: %w", err)
+	}
+	weekend, err := parseCurve(opts.Weekend)
+	if err != nil {
+		return curves{}, fmt.Errorf("invalid weekend curve: %w", err)
+	}
+
+	return curves{weekday: weekday, weekend: weekend}, nil
+}
+
+// Occupancy is the target number of the awake flats at the moment
+type Occupancy struct {
+	Weekend  bool    `json:"weekend"`
+	Hour     int     `json:"hour"`
+	Fraction float64 `json:"fraction"`
+	Target   int     `json:"target"`
+	Awake    int     `json:"awake"`
+	Flats    int     `json:"flats"`
+}
+
+func (c curves) at(t time.Time, awake, flats int) Occupancy {
+	o := Occupancy{
+		Weekend: t.Weekday() == time.Saturday || t.Weekday() == time.Sunday,
+		Hour:    t.Hour(),
+		Awake:   awake,
+		Flats:   flats,
+	}
+
+	o.Fraction = c.weekday[o.Hour]
+	if o.Weekend {
+		o.Fraction = c.weekend[o.Hour]
+	}
+	o.Target = int(math.Round(o.Fraction * float64(flats)))
+
+	return o
+}
+
+// residents track the flats which are awake
+// Every stay of the flat has its own number, so the events planned for the previous stay are skipped
+type residents struct {
+	windows map[int][]internal.Light
+	floors  map[int]int
+	// position of the flat on its floor
+	position map[int]int
+	numbers  []int
+	awake    map[int]stay
+	seq      int
+}
+
+type stay struct {
+	seq   int
+	since time.Time
+	until time.Time
+}
+
+func newResidents(mapping [][]internal.Light) *residents {
+	r := &residents{windows: map[int][]internal.Light{}, floors: map[int]int{}, position: map[int]int{}, awake: map[int]stay{}}
+	for floor, row := range mapping {
+		for _, w := range row {
+			if w.Number == 0 {
+				continue
+			}
+			if !slices.Contains(r.numbers, w.Number) {
+				r.numbers = append(r.numbers, w.Number)
+				r.floors[w.Number] = floor
+				r.position[w.Number] = len(r.flatsOn(floor)) - 1
+			}
+			if w.Kind != internal.LightTypeWallStub {
+				r.windows[w.Number] = append(r.windows[w.Number], w)
+			}
+		}
+	}
+	slices.Sort(r.numbers)
+
+	return r
+}
+
+// flats returns the awake or sleeping flats in the stable order
+func (r *residents) flats(awake bool) []int {
+	result := []int{}
+	for _, n := range r.numbers {
+		if _, ok := r.awake[n]; ok == awake {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// flatsOn returns the flats of the floor
+func (r *residents) flatsOn(floor int) []int {
+	result := []int{}
+	for _, n := range r.numbers {
+		if f, ok := r.floors[n]; ok && f == floor {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// neighbours returns the flats right above and below the flat
+func (r *residents) neighbours(flat int) []int {
+	result := []int{}
+	for _, n := range r.numbers {
+		if r.position[n] == r.position[flat] && (r.floors[n] == r.floors[flat]-1 || r.floors[n] == r.floors[flat]+1) {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// arrive marks the flat awake for the period and returns the number of its stay
+func (r *residents) arrive(flat int, since, until time.Time) int {
+	r.seq++
+	r.awake[flat] = stay{seq: r.seq, since: since, until: until}
+	return r.seq
+}
+
+// within reports whether the flat is still awake within the stay
+func (r *residents) within(flat, seq int) bool {
+	s, ok := r.awake[flat]
+	return ok && s.seq == seq
+}
+
+func (r *residents) leave(flat int) {
+	delete(r.awake, flat)
+}
@@ -0,0 +1,197 @@
+package live
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// randomPersonas assigns random personas to the flats which aren't listed explicitly
+const randomPersonas = "random"
+
+// Persona is the habit of the residents of the flat
+type Persona struct {
+	Name string
+	// hours is the chance of the flat to be awake at every hour of the day relative to the other flats
+	hours curve
+	// stay is the share of the flat ttl
+	stay float64
+	// changes is the share of the max changes of the windows, less changes keep the windows steady
+	changes float64
+	// likes are the multipliers of the chances of the window effects
+	likes map[string]float64
+	// away residents don't wake up even for the building events
+	away bool
+}
+
+var personas = []Persona{
+	{Name: "regular", hours: constant(1), stay: 1, changes: 1},
+	{Name: "night-owl", stay: 1.2, changes: 1, likes: map[string]float64{effectParty: 3}, hours: curve{
+		1, 1, 1, 0.8, 0.3, 0, 0, 0, 0, 0, 0, 0.1, 0.3, 0.4, 0.5, 0.5, 0.6, 0.7, 0.8, 0.9, 1, 1, 1, 1,
+	}},
+	{Name: "early-riser", stay: 0.8, changes: 1, hours: curve{
+		0, 0, 0, 0, 0.3, 1, 1, 1, 1, 0.7, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.6, 0.7, 0.7, 0.5, 0.3, 0.1, 0, 0,
+	}},
+	{Name: "pensioner", stay: 1.5, changes: 0.3, likes: map[string]float64{effectTV: 4, effectParty: 0}, hours: curve{
+		0.05, 0, 0, 0, 0, 0, 0.3, 0.6, 0.6, 0.6, 0.6, 0.6, 0.6, 0.6, 0.6, 0.6, 0.8, 1, 1, 1, 1, 1, 0.6, 0.2,
+	}},
+	{Name: "shift-worker", stay: 1, changes: 0.7, hours: curve{
+		1, 1, 1, 1, 1, 1, 0.8, 0.2, 0, 0, 0, 0, 0.1, 0.3, 0.5, 0.5, 0.3, 0.2, 0.2, 0.3, 0.5, 0.8, 1, 1,
+	}},
+	{Name: "vacation", stay: 0, changes: 0, hours: constant(0), away: true},
+}
+
+func constant(v float64) curve {
+	c := curve{}
+	for h := range c {
+		c[h] = v
+	}
+	return c
+}
+
+// PersonaNames returns the names of the built-in personas
+func PersonaNames() []string {
+	result := make([]string, 0, len(personas))
+	for _, p := range personas {
+		result = append(result, p.Name)
+	}
+	return result
+}
+
+func personaByName(name string) (Persona, bool) {
+	idx := slices.IndexFunc(personas, func(p Persona) bool { return p.Name == name })
+	if idx == -1 {
+		return Persona{}, false
+	}
+	return personas[idx], true
+}
+
+// casting is the configured assignment of the personas
+type casting struct {
+	flats  map[int]string
+	random bool
+}
+
+// parseCasting parses 'random' and 'flat=persona' items separated by commas, e.g. 'random, 3=night-owl'
+// Flats which aren't listed are regular unless the random assignment is requested
+func parseCasting(raw string) (casting, error) {
+	c := casting{flats: map[int]string{}}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if item == randomPersonas {
+			c.random = true
+			continue
+		}
+
+		rawFlat, name, ok := strings.Cut(item, "=")
+		if !ok {
+			return c, fmt.Errorf("'%s' is neither '%s' nor in the flat=persona form", item, randomPersonas)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(rawFlat))
+		if err != nil {
+			return c, fmt.Errorf("couldn't parse flat number of '%s': %w", item, err)
+		}
+		name = strings.TrimSpace(name)
+		if _, ok := personaByName(name); !ok {
+			return c, fmt.Errorf("unknown persona '%s', known are %s", name, strings.Join(PersonaNames(), ", "))
+		}
+		c.flats[n] = name
+	}
+
+	return c, nil
+}
+
+// assign returns the persona of every flat, random personas are drawn from the randomness of the flow
+func (c casting) assign(r *rand.Rand, flats []int) (map[int]Persona, error) {
+	for n := range c.flats {
+		if !slices.Contains(flats, n) {
+			return nil, fmt.Errorf("flat %d doesn't exist", n)
+		}
+	}
+
+	result := make(map[int]Persona, len(flats))
+	for _, n := range flats {
+		name, ok := c.flats[n]
+		switch {
+		case ok:
+			result[n], _ = personaByName(name)
+		case c.random:
+			result[n] = personas[r.Intn(len(personas))]
+		default:
+			result[n] = personas[0]
+		}
+	}
+
+	return result, nil
+}
+
+// activity is the weight of the flat to wake up at the moment
+func (p Persona) activity(t time.Time) float64 {
+	return p.hours[t.Hour()]
+}
+
+// excited returns the persona with the chances of the effects multiplied by the mood
+func (p Persona) excited(mood map[string]float64) Persona {
+	if len(mood) == 0 {
+		return p
+	}
+
+	likes := maps.Clone(mood)
+	for effect, like := range p.likes {
+		if m, ok := likes[effect]; ok {
+			like *= m
+		}
+		likes[effect] = like
+	}
+	p.likes = likes
+
+	return p
+}
+
+// ttl is the randomized stay of the flat
+func (p Persona) ttl(r *rand.Rand, ttl time.Duration) time.Duration {
+	return randomizeDuration(r, time.Duration(float64(ttl)*p.stay), 0.4)
+}
+
+// maxChanges is the number of the changes of every window during the stay
+func (p Persona) maxChanges(changes uint) uint {
+	return uint(math.Max(1, math.Round(float64(changes)*p.changes)))
+}
+
+// pick returns the random flat with the chance proportional to its weight
+// It reports false if all weights are zero
+func pick(r *rand.Rand, flats []int, weight func(flat int) float64) (int, bool) {
+	total := 0.0
+	for _, n := range flats {
+		total += weight(n)
+	}
+	if total <= 0 {
+		return 0, false
+	}
+
+	var (
+		x      = r.Float64() * total
+		picked int
+	)
+	for _, n := range flats {
+		w := weight(n)
+		if w <= 0 {
+			continue
+		}
+		picked = n
+		x -= w
+		if x < 0 {
+			break
+		}
+	}
+
+	return picked, true
+}
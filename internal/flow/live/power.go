@@ -0,0 +1,70 @@
+package live
+
+import (
+	"fmt"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+// power is the electricity of the building: lights follow the flats unless a building event holds them
+// The wanted state of the held lights is kept and returned when they are released
+// It is used only by the goroutine of the flow
+type power struct {
+	lights LightsController
+	wanted map[internal.LightAddress]bool
+	held   map[internal.LightAddress]bool
+}
+
+func newPower(l LightsController) *power {
+	return &power{
+		lights: l,
+		wanted: map[internal.LightAddress]bool{},
+		held:   map[internal.LightAddress]bool{},
+	}
+}
+
+func (p *power) Set(addr internal.LightAddress, isON bool) error {
+	p.wanted[addr] = isON
+	if _, isHeld := p.held[addr]; isHeld {
+		return nil
+	}
+
+	return p.lights.Set(addr, isON)
+}
+
+func (p *power) Reset() error {
+	p.forget()
+	return p.lights.Reset()
+}
+
+// forget drops the held lights without touching them, e.g. when the flow is started again
+func (p *power) forget() {
+	clear(p.wanted)
+	clear(p.held)
+}
+
+// hold switches the lights to the state regardless of the flats
+func (p *power) hold(addrs []internal.LightAddress, isON bool) error {
+	for _, addr := range addrs {
+		p.held[addr] = isON
+		err := p.lights.Set(addr, isON)
+		if err != nil {
+			return fmt.Errorf("couldn't switch light '%v': %w", addr, err)
+		}
+	}
+
+	return nil
+}
+
+// release returns the lights to the state wanted by the flats
+func (p *power) release(addrs []internal.LightAddress) error {
+	for _, addr := range addrs {
+		delete(p.held, addr)
+		err := p.lights.Set(addr, p.wanted[addr])
+		if err != nil {
+			return fmt.Errorf("couldn't switch light '%v': %w", addr, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package live
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// guestStay is the mean time the guest stays in the other flat
+const guestStay = 30 * time.Minute
+
+// session is the run of the live mode from the start to the stop
+// It is used only by the event loop, the status queries get the state published by the session
+type session struct {
+	l         *Live
+	lp        *loop
+	residents *residents
+	personas  map[int]Persona
+	walks     *stairs
+	bounds    *bounds
+	curves    curves
+	occupancy Occupancy
+
+	// building event in progress
+	event string
+	// boost is the extra fraction of the awake flats during the event
+	boost float64
+	// mood multiplies the chances of the window effects during the event
+	mood map[string]float64
+}
+
+func (l *Live) newSession(daily curves) (*session, error) {
+	s := &session{
+		l:         l,
+		lp:        newLoop(l.clock),
+		residents: newResidents(l.mapping),
+		curves:    daily,
+	}
+	s.walks = newStairs(l.lights, l.mapping, l.rand, l.opts)
+
+	var err error
+	s.bounds, err = newBounds(l.mapping, l.opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bounds: %w", err)
+	}
+
+	cast, err := parseCasting(l.opts.Personas)
+	if err != nil {
+		return nil, fmt.Errorf("invalid personas: %w", err)
+	}
+	s.personas, err = cast.assign(l.rand, s.residents.numbers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid personas: %w", err)
+	}
+
+	names := make(map[int]string, len(s.personas))
+	for n, p := range s.personas {
+		names[n] = p.Name
+	}
+	l.mu.Lock()
+	l.personas = names
+	l.mu.Unlock()
+
+	return s, nil
+}
+
+func (s *session) run(ctx context.Context, events []dated) error {
+	s.walks.settle(s.lp)
+	s.walks.failing(s.lp)
+
+	// Switch off windows left by the previous flow
+	s.l.planFadeOut(s.lp)
+
+	for _, e := range events {
+		s.planDated(e)
+	}
+
+	s.lp.after(0, s.selectFlat)
+
+	err := s.lp.run(ctx, s.l.done)
+	// stays in progress count for the fairness of the next run
+	now := s.lp.clock.Now()
+	for n, st := range s.residents.awake {
+		s.l.ledger.add(n, st.since, now)
+	}
+
+	return err
+}
+
+// publish shares the state of the loop with the status queries
+func (s *session) publish() {
+	status := Status{Occupancy: s.occupancy, Event: s.event}
+	status.Occupancy.Awake = 0
+	for _, n := range s.residents.numbers {
+		st, ok := s.residents.awake[n]
+		flat := FlatStatus{Number: n, Persona: s.personas[n].Name, Awake: ok}
+		if ok {
+			flat.Since, flat.Until = &st.since, &st.until
+			status.Occupancy.Awake++
+		}
+		status.Flats = append(status.Flats, flat)
+	}
+
+	s.l.mu.Lock()
+	s.l.status = status
+	s.l.mu.Unlock()
+}
+
+func (s *session) selectFlat() error {
+	l := s.l
+	nextFlatSelectionIn := randomizeDuration(l.rand, l.opts.MaxDelay, 0.4)
+	defer func() {
+		l.log.Debug("next flat selection in", slog.Duration("duration", nextFlatSelectionIn))
+		s.lp.after(nextFlatSelectionIn, s.selectFlat)
+	}()
+
+	var (
+		now   = s.lp.clock.Now()
+		awake = s.residents.flats(true)
+		flats = len(s.residents.numbers)
+	)
+	s.occupancy = s.curves.at(now, len(awake), flats)
+	if s.boost > 0 {
+		s.occupancy.Fraction = math.Min(1, s.occupancy.Fraction+s.boost)
+		s.occupancy.Target = int(math.Round(s.occupancy.Fraction * float64(flats)))
+	}
+	defer s.publish()
+
+	if l.opts.Guests > 0 && len(awake) > 1 && l.rand.Float64() < l.opts.Guests {
+		from := awake[l.rand.Intn(len(awake))]
+		to := awake[l.rand.Intn(len(awake))]
+		if from != to {
+			s.visit(from, to)
+		}
+	}
+
+	// expected number of flats is sampled, so the fractional targets are kept on average
+	// and the flats don't come and go on every selection when the target is reached
+	// The bounds of the lit windows win over the curve, the flats breaking the bounds aren't selected
+	var (
+		expected   = s.occupancy.Fraction*float64(flats) + l.rand.Float64() - 0.5
+		dir, faces = s.bounds.wants(awake)
+		shares     = l.ledger.shares(now, s.residents.numbers, s.residents.awake, l.opts.Fairness)
+		// fit is the multiplier of the chance of the flat, the flats fixing more broken faces are preferred
+		fit = func(n int, arriving bool) float64 {
+			switch {
+			case !s.bounds.allows(awake, n, arriving):
+				return 0
+			case len(faces) > 0:
+				return float64(s.bounds.touches(n, faces))
+			}
+			return 1
+		}
+	)
+	switch {
+	case dir > 0 || (dir == 0 && float64(len(awake)) < expected-0.5):
+		// flats wake up by the habits of their personas, everybody at home could join the event
+		// flats awake less than the others during the week are more likely to wake up
+		flat, ok := pick(l.rand, s.residents.flats(false), func(n int) float64 {
+			p := s.personas[n]
+			a := s.activity(n, now)
+			if s.event != "" && !p.away {
+				a += s.boost
+			}
+			if dir > 0 && !p.away {
+				a = max(a, 0.1) // the bounds could wake up the personas sleeping at the hour
+			}
+			return a * shares[n] * fit(n, true)
+		})
+		if !ok {
+			return nil
+		}
+		return s.arrive(flat)
+	case dir < 0 || (dir == 0 && float64(len(awake)) > expected+0.5):
+		// the less active the persona is at the hour the more likely it leaves, everybody could leave though
+		flat, ok := pick(l.rand, awake, func(n int) float64 {
+			return (1.1 - s.activity(n, now)) / shares[n] * fit(n, false)
+		})
+		if !ok {
+			return nil
+		}
+		return s.leave(flat)
+	}
+
+	return nil
+}
+
+// activity is the chance of the flat to be awake at the moment by its persona or by the learned model
+func (s *session) activity(flat int, now time.Time) float64 {
+	p := s.personas[flat]
+	if s.l.model != nil && !p.away {
+		return s.l.model.activity(flat, now.Hour())
+	}
+	return p.activity(now)
+}
+
+func (s *session) arrive(flat int) error {
+	l := s.l
+	persona := s.personas[flat].excited(s.mood)
+	ttl := persona.ttl(l.rand, l.opts.FlatTTL)
+	l.log.Info("selected flat", slog.Int("flat", flat), slog.String("persona", persona.Name), slog.Duration("ttl", ttl))
+
+	// windows are lit when the resident reaches the flat
+	reached := s.walks.up(s.lp, s.residents.floors[flat])
+	stay := s.residents.arrive(flat, s.lp.clock.Now(), reached.Add(ttl))
+	defer s.publish()
+
+	alive := func() bool { return s.residents.within(flat, stay) }
+	party, ok := l.flatCycle(s.lp, flat, reached, s.residents.windows[flat], ttl, persona, alive)
+	if ok && l.opts.PartyWake > 0 {
+		s.lp.at(party, func() error {
+			if !alive() {
+				return nil
+			}
+			return s.wakeNeighbours(flat)
+		})
+	}
+
+	s.lp.at(reached.Add(ttl), func() error {
+		if !alive() {
+			return nil // flat has left before
+		}
+		return s.leave(flat)
+	})
+	return nil
+}
+
+func (s *session) leave(flat int) error {
+	s.l.log.Info("flat leaves", slog.Int("flat", flat))
+	if st, ok := s.residents.awake[flat]; ok {
+		s.l.ledger.add(flat, st.since, s.lp.clock.Now())
+	}
+	s.residents.leave(flat)
+	defer s.publish()
+
+	for _, w := range s.residents.windows[flat] {
+		err := s.l.lights.Set(w.Addr, false)
+		if err != nil {
+			return fmt.Errorf("couldn't switch off light '%v': %w", w.Addr, err)
+		}
+	}
+	s.walks.down(s.lp, s.residents.floors[flat])
+	return nil
+}
+
+// wakeNeighbours wakes up the flats above and below the party unless they break the bounds of the lit windows
+func (s *session) wakeNeighbours(flat int) error {
+	for _, n := range s.residents.neighbours(flat) {
+		if _, ok := s.residents.awake[n]; ok || s.personas[n].away || s.l.rand.Float64() >= s.l.opts.PartyWake {
+			continue
+		}
+		if !s.bounds.allows(s.residents.flats(true), n, true) {
+			continue
+		}
+
+		s.l.log.Info("neighbour is woken up by the party", slog.Int("flat", n), slog.Int("party", flat))
+		err := s.arrive(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visit plays the guest walking from the flat to the other one and back after a while
+func (s *session) visit(from, to int) {
+	s.l.log.Info("guest visits flat", slog.Int("from", from), slog.Int("to", to))
+
+	var (
+		fromFloor = s.residents.floors[from]
+		toFloor   = s.residents.floors[to]
+		arrived   = s.walks.walk(s.lp, s.lp.clock.Now(), fromFloor, toFloor)
+	)
+	s.lp.at(arrived.Add(randomizeDuration(s.l.rand, guestStay, 0.5)), func() error {
+		s.walks.walk(s.lp, s.lp.clock.Now(), toFloor, fromFloor)
+		return nil
+	})
+}
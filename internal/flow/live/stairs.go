@@ -0,0 +1,165 @@
+package live
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+// doorFlicker is the flicker of the entrance light when the door opens, every step toggles the light
+var doorFlicker = []time.Duration{0, 150 * time.Millisecond, 300 * time.Millisecond, 450 * time.Millisecond}
+
+// bulbBlink is the blink of the failing entrance bulb
+var bulbBlink = []time.Duration{0, 80 * time.Millisecond, 140 * time.Millisecond, 260 * time.Millisecond}
+
+// stairs simulate the residents walking the stairwell
+// The landing is lit for the ttl after the last walker has passed it, so the walkers could overlap
+type stairs struct {
+	lights   LightsController
+	entrance internal.LightAddress
+	// landings by floor, the entrance is on the ground floor
+	landings map[int]internal.LightAddress
+	floors   int
+	litUntil map[internal.LightAddress]time.Time
+	rand     *rand.Rand
+	step     time.Duration
+	ttl      time.Duration
+	bulb     time.Duration
+}
+
+func newStairs(l LightsController, mapping [][]internal.Light, r *rand.Rand, opts Options) *stairs {
+	s := &stairs{
+		lights:   l,
+		landings: map[int]internal.LightAddress{},
+		floors:   len(mapping),
+		litUntil: map[internal.LightAddress]time.Time{},
+		rand:     r,
+		step:     opts.FloorTime,
+		ttl:      opts.ServiceTTL,
+		bulb:     opts.BulbInterval,
+	}
+	for floor, row := range mapping {
+		for _, light := range row {
+			switch light.Kind {
+			case internal.LightTypeServiceEntrance:
+				s.entrance = light.Addr
+			case internal.LightTypeServiceNoManLand:
+				s.landings[floor] = light.Addr
+			}
+		}
+	}
+
+	return s
+}
+
+// settle switches off the landings left by the previous flow after the ttl
+func (s *stairs) settle(lp *loop) {
+	at := lp.clock.Now().Add(s.ttl)
+	for floor := 0; floor < s.floors; floor++ {
+		if addr, ok := s.landings[floor]; ok {
+			lp.at(at, s.off(addr, at))
+		}
+	}
+}
+
+// up plays the walk from the entrance to the floor and returns the moment the resident reaches it
+func (s *stairs) up(lp *loop, floor int) time.Time {
+	return s.walk(lp, s.door(lp, lp.clock.Now()), 0, floor)
+}
+
+// down plays the walk from the floor to the entrance
+func (s *stairs) down(lp *loop, floor int) {
+	s.door(lp, s.walk(lp, lp.clock.Now(), floor, 0))
+}
+
+// walk lights the landings between the floors starting from the moment and returns the moment the floor is reached
+// The landing of the floor lights the flight of stairs below it
+func (s *stairs) walk(lp *loop, at time.Time, from, to int) time.Time {
+	for f := from; f < to; f++ {
+		at = at.Add(s.step)
+		if addr, ok := s.landings[f+1]; ok {
+			s.light(lp, addr, at)
+		}
+	}
+	for f := from; f > to; f-- {
+		if addr, ok := s.landings[f]; ok {
+			s.light(lp, addr, at)
+		}
+		at = at.Add(s.step)
+	}
+
+	return at
+}
+
+// door flickers the entrance light and returns the moment the door is passed
+func (s *stairs) door(lp *loop, at time.Time) time.Time {
+	return s.flicker(lp, at, doorFlicker)
+}
+
+// failing blinks the entrance light from time to time as the bulb is about to fail
+func (s *stairs) failing(lp *loop) {
+	if s.bulb <= 0 || s.entrance.Pin == "" {
+		return
+	}
+
+	lp.after(randomizeDuration(s.rand, s.bulb, 0.9), func() error {
+		s.flicker(lp, lp.clock.Now(), bulbBlink)
+		s.failing(lp)
+		return nil
+	})
+}
+
+// flicker toggles the entrance light by the pattern starting from off and returns the moment it is over
+func (s *stairs) flicker(lp *loop, at time.Time, pattern []time.Duration) time.Time {
+	if s.entrance.Pin == "" {
+		return at
+	}
+
+	for i, d := range pattern {
+		isOn := i%2 == 1
+		lp.at(at.Add(d), func() error {
+			err := s.lights.Set(s.entrance, isOn)
+			if err != nil {
+				return fmt.Errorf("couldn't switch entrance light '%v': %w", s.entrance, err)
+			}
+			return nil
+		})
+	}
+
+	return at.Add(pattern[len(pattern)-1])
+}
+
+// light switches the landing on at the moment and plans switching it off after the ttl
+func (s *stairs) light(lp *loop, addr internal.LightAddress, at time.Time) {
+	lp.at(at, func() error {
+		off := at.Add(s.ttl)
+		if off.After(s.litUntil[addr]) {
+			s.litUntil[addr] = off
+		}
+
+		err := s.lights.Set(addr, true)
+		if err != nil {
+			return fmt.Errorf("couldn't switch on light '%v': %w", addr, err)
+		}
+
+		lp.at(off, s.off(addr, off))
+		return nil
+	})
+}
+
+// off switches the landing off unless somebody has passed it after the moment was planned
+func (s *stairs) off(addr internal.LightAddress, at time.Time) func() error {
+	return func() error {
+		if at.Before(s.litUntil[addr]) {
+			return nil
+		}
+
+		err := s.lights.Set(addr, false)
+		if err != nil {
+			return fmt.Errorf("couldn't switch off light '%v': %w", addr, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,79 @@
+package live
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStairs_overlappingWalkers(t *testing.T) {
+	start := time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	timeline := []change{}
+	ctrlMoq := &LightsControllerMock{
+		SetFunc: func(addr internal.LightAddress, isON bool) error {
+			timeline = append(timeline, change{at: clk.Now().Sub(start), addr: addr, isOn: isON})
+			return nil
+		},
+	}
+
+	lp := newLoop(clk)
+	s := newStairs(ctrlMoq, internal.BuildingMap.Levels, rand.New(rand.NewSource(1)), Options{FloorTime: 4 * time.Second, ServiceTTL: 20 * time.Second})
+
+	reached := s.up(lp, 3)
+	require.Equal(t, 12450*time.Millisecond, reached.Sub(start))
+	lp.at(start.Add(10*time.Second), func() error {
+		s.up(lp, 1)
+		return nil
+	})
+	lp.at(start.Add(time.Minute), func() error {
+		s.down(lp, 3)
+		return nil
+	})
+	end := start.Add(time.Hour)
+	lp.at(end, func() error { return nil })
+
+	result := make(chan error, 1)
+	go func() { result <- lp.run(context.Background(), nil) }()
+	for clk.Now().Before(end) {
+		clk.BlockUntil(1)
+		clk.AdvanceToNext()
+	}
+	require.NoError(t, <-result)
+
+	history := func(addr internal.LightAddress) []change {
+		result := []change{}
+		for _, c := range timeline {
+			if c.addr == addr {
+				result = append(result, change{at: c.at, isOn: c.isOn})
+			}
+		}
+		return result
+	}
+
+	// the second walker keeps the first landing lit
+	require.Equal(t, []change{
+		{at: 4450 * time.Millisecond, isOn: true},
+		{at: 14450 * time.Millisecond, isOn: true},
+		{at: 34450 * time.Millisecond, isOn: false},
+		{at: 68 * time.Second, isOn: true},
+		{at: 88 * time.Second, isOn: false},
+	}, history(s.landings[1]))
+	// landings are lit one by one on the way up and in reverse on the way down
+	require.Equal(t, []change{
+		{at: 12450 * time.Millisecond, isOn: true},
+		{at: 32450 * time.Millisecond, isOn: false},
+		{at: 60 * time.Second, isOn: true},
+		{at: 80 * time.Second, isOn: false},
+	}, history(s.landings[3]))
+	// the door flickers and stays lit
+	entrance := history(s.entrance)
+	require.Len(t, entrance, 3*len(doorFlicker))
+	require.True(t, entrance[len(entrance)-1].isOn)
+	require.Equal(t, 72*time.Second, entrance[len(entrance)-len(doorFlicker)].at)
+}
@@ -7,6 +7,8 @@ import (
 	"sync"
 
 	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
 )
 
 type LightsController interface {
@@ -16,42 +18,99 @@ type LightsController interface {
 }
 
 const (
-	name = "manual"
+	// FlowName is the name of the flow in the registry
+	FlowName = "manual"
+	// ParamScene is the param of the scene applied on the start
+	ParamScene = "scene"
 )
 
+// Options are the params of the manual mode
+type Options struct {
+	Scene string `long:"scene" description:"scene applied on the start, it replaces the lights left by the transition"`
+}
+
 type Manual struct {
 	lights  LightsController
 	mapping [][]internal.Light
+	scenes  snapshot.Store
 	done    chan struct{}
 
 	mu       sync.Mutex
 	isActive bool
+	seeded   bool
+	opts     Options
 }
 
-func New(l LightsController, mapping [][]internal.Light) *Manual {
+// New creates the manual mode, the store of the scenes could be nil
+func New(l LightsController, mapping [][]internal.Light, scenes snapshot.Store) *Manual {
 	return &Manual{
 		lights:  l,
 		mapping: mapping,
+		scenes:  scenes,
 		done:    make(chan struct{}),
 	}
 }
 
 func (m *Manual) Name() string {
-	return name
+	return FlowName
+}
+
+// Seed keeps the lights as they are on the next start, the selected scene replaces them anyway
+func (m *Manual) Seed(_ flow.State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seeded = true
+}
+
+// Configure selects the scene, nil params start with the lights switched off
+func (m *Manual) Configure(p flow.Params) error {
+	opts := Options{}
+	err := flow.ApplyParams(&opts, p)
+	if err != nil {
+		return err
+	}
+	if opts.Scene != "" {
+		if m.scenes == nil {
+			return fmt.Errorf("scenes aren't available")
+		}
+		_, err = snapshot.LoadScene(m.scenes, opts.Scene)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.opts = opts
+
+	return nil
 }
 
 func (m *Manual) Start(ctx context.Context) error {
-	slog.Info("starting flow", "flow", name)
+	slog.Info("starting flow", "flow", FlowName)
 	m.done = make(chan struct{})
 	m.mu.Lock()
 	m.isActive = true
+	seeded, scene := m.seeded, m.opts.Scene
+	m.seeded = false
 	m.mu.Unlock()
 
-	// switch of all lights
-	slog.Info("swithching off all lights")
-	err := m.lights.Reset()
-	if err != nil {
-		return fmt.Errorf("couldn't reset lights: %w", err)
+	// the scene is selected explicitly, so it wins over the lights left by the transition
+	if !seeded || scene != "" {
+		// switch of all lights
+		slog.Info("swithching off all lights")
+		err := m.lights.Reset()
+		if err != nil {
+			return fmt.Errorf("couldn't reset lights: %w", err)
+		}
+	}
+
+	if scene != "" {
+		err := m.applyScene(scene)
+		if err != nil {
+			return err
+		}
 	}
 
 	select {
@@ -62,12 +121,38 @@ func (m *Manual) Start(ctx context.Context) error {
 	}
 }
 
+// applyScene sets the lights of the scene, the rest of the lights are already switched off
+func (m *Manual) applyScene(name string) error {
+	slog.Info("applying scene", "flow", FlowName, "scene", name)
+	frame, err := snapshot.LoadScene(m.scenes, name)
+	if err != nil {
+		return fmt.Errorf("couldn't load scene '%s': %w", name, err)
+	}
+
+	windows := snapshot.NewWindows(m.mapping)
+	for _, d := range frame {
+		if !d.IsOn {
+			continue
+		}
+		addr, err := windows.Address(d)
+		if err != nil {
+			return fmt.Errorf("couldn't apply scene '%s': %w", name, err)
+		}
+		err = m.lights.Set(addr, true)
+		if err != nil {
+			return fmt.Errorf("couldn't set light '%v': %w", addr, err)
+		}
+	}
+
+	return nil
+}
+
 func (m *Manual) Stop() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if m.isActive {
-		slog.Info("stopping flow", "flow", name)
+		slog.Info("stopping flow", "flow", FlowName)
 		m.isActive = false
 		close(m.done)
 	}
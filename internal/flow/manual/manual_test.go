@@ -0,0 +1,88 @@
+package manual
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+	"github.com/mbobakov/khrushchevka/internal/snapshot/file"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManual_scene(t *testing.T) {
+	var (
+		lit    = internal.LightAddress{Board: 0x24, Pin: "A0"}
+		before = internal.LightAddress{Board: 0x24, Pin: "A1"}
+		l      = lights.NewTestController([]uint8{0x22, 0x23, 0x24, 0x25})
+		scenes = file.New(file.Options{Dir: "scenes"}, afero.NewMemMapFs())
+	)
+	require.NoError(t, snapshot.SaveScene(scenes, "cosy", []snapshot.LightDTO{
+		{Window: "0-front-4-0", IsOn: true},
+		{Window: "0-front-4-1", IsOn: false},
+	}))
+	require.NoError(t, l.Set(before, true))
+
+	m := New(l, internal.BuildingMap.Levels, scenes)
+	require.ErrorIs(t, m.Configure(flow.Params{"scene": "party"}), snapshot.ErrNotFound)
+	require.NoError(t, m.Configure(flow.Params{"scene": "cosy"}))
+
+	done := make(chan error)
+	go func() { done <- m.Start(context.Background()) }()
+	require.Eventually(t, func() bool {
+		isOn, err := l.IsOn(lit)
+		return err == nil && isOn
+	}, time.Second, time.Millisecond)
+
+	// the lights which aren't lit by the scene are switched off
+	isOn, err := l.IsOn(before)
+	require.NoError(t, err)
+	require.False(t, isOn)
+
+	m.Stop()
+	require.NoError(t, <-done)
+}
+
+func TestManual_sceneOverTransition(t *testing.T) {
+	var (
+		lit    = internal.LightAddress{Board: 0x24, Pin: "A0"}
+		before = internal.LightAddress{Board: 0x24, Pin: "A1"}
+	)
+
+	for _, transition := range []string{"keep", "wipe", "dissolve", "none"} {
+		t.Run(transition, func(t *testing.T) {
+			l := lights.NewTestController([]uint8{0x22, 0x23, 0x24, 0x25})
+			scenes := file.New(file.Options{Dir: "scenes"}, afero.NewMemMapFs())
+			require.NoError(t, snapshot.SaveScene(scenes, "cosy", []snapshot.LightDTO{{Window: "0-front-4-0", IsOn: true}}))
+			require.NoError(t, l.Set(before, true))
+
+			m := New(l, internal.BuildingMap.Levels, scenes)
+			c, err := flow.NewController(clock.Real(), flow.NewArbiter(l, internal.BuildingMap.Levels), flow.Options{}, m)
+			require.NoError(t, err)
+			go func() {
+				for range c.SubscribeToErrors() {
+				}
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			params := flow.Params{ParamScene: "cosy", "transition": transition, "transition-duration": "0s"}
+			require.NoError(t, c.AssignFlow(ctx, FlowName, flow.GroupAll, params))
+			require.Eventually(t, func() bool {
+				isOn, err := l.IsOn(lit)
+				return err == nil && isOn
+			}, time.Second, time.Millisecond)
+
+			// the lights kept by the transition are replaced by the scene
+			isOn, err := l.IsOn(before)
+			require.NoError(t, err)
+			require.False(t, isOn)
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package overlay
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+)
+
+type LightsController interface {
+	Set(addr internal.LightAddress, isON bool) error
+}
+
+type Options struct {
+	Interval time.Duration `long:"interval" env:"INTERVAL" default:"300ms" description:"interval between the frames of the overlay"`
+}
+
+const (
+	// BlinkName is the name of the overlay which blinks all of its lights together
+	BlinkName = "blink"
+	// DoorbellName is the name of the overlay which runs the wave of light up and down the floors
+	DoorbellName = "doorbell"
+)
+
+// frame is the state of the lights at the step of the pattern
+type frame func(step, floor int) bool
+
+// Pattern is the overlay repeating frames until it is stopped
+// Lights which aren't taken by the overlay are skipped by the lights controller
+type Pattern struct {
+	name    string
+	lights  LightsController
+	mapping [][]internal.Light
+	clock   clock.Clock
+	frame   frame
+	base    Options
+	log     *slog.Logger
+	done    chan struct{}
+
+	mu       sync.Mutex
+	opts     Options
+	isActive bool
+}
+
+// NewBlink returns the overlay switching all of its lights on and off
+func NewBlink(clk clock.Clock, l LightsController, mapping [][]internal.Light, opts Options) *Pattern {
+	return newPattern(BlinkName, clk, l, mapping, opts, func(step, _ int) bool {
+		return step%2 == 0
+	})
+}
+
+// NewDoorbell returns the overlay running the lit floor from the bottom to the top and back
+func NewDoorbell(clk clock.Clock, l LightsController, mapping [][]internal.Light, opts Options) *Pattern {
+	floors := len(mapping)
+	return newPattern(DoorbellName, clk, l, mapping, opts, func(step, floor int) bool {
+		if floors < 2 {
+			return step%2 == 0
+		}
+		period := 2 * (floors - 1)
+		lit := step % period
+		if lit >= floors {
+			lit = period - lit
+		}
+		return floor == lit
+	})
+}
+
+func newPattern(name string, clk clock.Clock, l LightsController, mapping [][]internal.Light, opts Options, f frame) *Pattern {
+	return &Pattern{
+		name:    name,
+		lights:  l,
+		mapping: mapping,
+		clock:   clk,
+		frame:   f,
+		base:    opts,
+		opts:    opts,
+		log:     slog.With("overlay", name),
+		done:    make(chan struct{}),
+	}
+}
+
+func (p *Pattern) Name() string {
+	return p.name
+}
+
+// Configure applies params on top of the options the overlay was created with
+func (p *Pattern) Configure(params flow.Params) error {
+	opts := p.base
+	err := flow.ApplyParams(&opts, params)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opts = opts
+
+	return nil
+}
+
+func (p *Pattern) Start(ctx context.Context) error {
+	p.log.Info("starting overlay")
+	p.mu.Lock()
+	p.done = make(chan struct{})
+	p.isActive = true
+	done := p.done
+	interval := p.opts.Interval
+	p.mu.Unlock()
+
+	if interval <= 0 {
+		interval = p.base.Interval
+	}
+
+	for step := 0; ; step++ {
+		for floor, row := range p.mapping {
+			for _, l := range row {
+				if l.Addr.Pin == "" {
+					continue
+				}
+				err := p.lights.Set(l.Addr, p.frame(step, floor))
+				if err != nil {
+					p.log.Error("couldn't set light", slog.Any("addr", l.Addr), slog.Any("err", err))
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case <-p.clock.After(interval):
+		}
+	}
+}
+
+func (p *Pattern) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.isActive {
+		p.log.Info("stopping overlay")
+		p.isActive = false
+		close(p.done)
+	}
+}
@@ -0,0 +1,131 @@
+package flow
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Params are the flow specific options passed on the flow selection
+// Keys are the same as the `long` names of the flow options (e.g. "max-delay" for live)
+type Params map[string]string
+
+// Configurable is implemented by the flows which accept options on the selection
+type Configurable interface {
+	// Configure applies params on top of the options the flow was created with
+	// nil params return the flow to its initial options
+	Configure(p Params) error
+}
+
+// String returns params in the stable 'key=value, key=value' form
+func (p Params) String() string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+p[k])
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// ParseParams parses params from the 'key=value, key=value' form
+func ParseParams(raw string) (Params, error) {
+	p := Params{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("param '%s' is not in the key=value form", part)
+		}
+		p[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return p, nil
+}
+
+// ApplyParams sets fields of the options struct pointed by dst using their `long` tags as keys
+// Unknown keys are reported as an error, so typos in the configuration are not silently ignored
+func ApplyParams(dst any, p Params) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("options must be a pointer to struct, got %T", dst)
+	}
+	v = v.Elem()
+
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		long := v.Type().Field(i).Tag.Get("long")
+		if long == "" {
+			continue
+		}
+		fields[long] = v.Field(i)
+	}
+
+	for k, raw := range p {
+		f, ok := fields[k]
+		if !ok {
+			return fmt.Errorf("unknown option '%s'", k)
+		}
+		err := setValue(f, raw)
+		if err != nil {
+			return fmt.Errorf("couldn't set option '%s' to '%s': %w", k, raw, err)
+		}
+	}
+
+	return nil
+}
+
+func setValue(f reflect.Value, raw string) error {
+	if f.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		fl, err := strconv.ParseFloat(raw, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(fl)
+	default:
+		return fmt.Errorf("unsupported option type %s", f.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package playlist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/spf13/afero"
+)
+
+type Options struct {
+	Path string `long:"path" env:"PATH" default:"./playlists.json" description:"path to the playlists file"`
+}
+
+// Registry is the place where playlists are registered as flows and executes their steps
+type Registry interface {
+	Runner
+	Register(f flow.Flow) error
+	Unregister(name string)
+}
+
+type file struct {
+	Playlists []Definition `json:"playlists"`
+}
+
+// Manager keeps playlists in the file and registers them as flows
+type Manager struct {
+	fs       afero.Fs
+	clock    clock.Clock
+	path     string
+	registry Registry
+	log      *slog.Logger
+
+	mu        sync.RWMutex
+	playlists map[string]*Playlist
+}
+
+// NewManager loads playlists from the file if it exists and registers them
+func NewManager(clk clock.Clock, fs afero.Fs, registry Registry, opts Options) (*Manager, error) {
+	m := &Manager{
+		fs:        fs,
+		clock:     clk,
+		path:      opts.Path,
+		registry:  registry,
+		log:       slog.With("subsystem", "playlists"),
+		playlists: make(map[string]*Playlist),
+	}
+
+	buf, err := afero.ReadFile(fs, opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		m.log.Info("playlists file doesn't exist, starting with no playlists", slog.String("path", opts.Path))
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read playlists file '%s': %w", opts.Path, err)
+	}
+
+	f := file{}
+	err = json.Unmarshal(buf, &f)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal playlists file '%s': %w", opts.Path, err)
+	}
+
+	for _, def := range f.Playlists {
+		err = m.register(def)
+		if err != nil {
+			return nil, fmt.Errorf("invalid playlists file '%s': %w", opts.Path, err)
+		}
+	}
+
+	return m, nil
+}
+
+// List returns the definitions of the playlists sorted by name
+func (m *Manager) List() []Definition {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]Definition, 0, len(m.playlists))
+	for _, p := range m.playlists {
+		result = append(result, p.Definition())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result
+}
+
+// Save creates or replaces the playlist and writes all playlists to the file
+// Replaced playlist is stopped if it is in execution
+func (m *Manager) Save(def Definition) error {
+	p, err := New(m.clock, def, m.registry)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// names are the same, so the old playlist is unregistered first and registered back on the failure
+	old, replaced := m.playlists[def.Name]
+	if replaced {
+		m.registry.Unregister(old.Name())
+	}
+
+	err = m.registry.Register(p)
+	if err != nil {
+		if replaced {
+			rerr := m.registry.Register(old)
+			if rerr != nil {
+				m.log.Error("couldn't register back playlist", slog.String("playlist", def.Name), slog.Any("err", rerr))
+				delete(m.playlists, def.Name)
+			}
+		}
+		return fmt.Errorf("couldn't register playlist %s: %w", def.Name, err)
+	}
+	m.playlists[def.Name] = p
+
+	return m.write()
+}
+
+// Delete removes the playlist and writes all playlists to the file
+func (m *Manager) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.playlists[name]
+	if !ok {
+		return fmt.Errorf("playlist %s not found", name)
+	}
+
+	m.registry.Unregister(p.Name())
+	delete(m.playlists, name)
+
+	return m.write()
+}
+
+func (m *Manager) register(def Definition) error {
+	p, err := New(m.clock, def, m.registry)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := m.playlists[def.Name]; ok {
+		return fmt.Errorf("playlist %s is defined twice", def.Name)
+	}
+
+	err = m.registry.Register(p)
+	if err != nil {
+		return fmt.Errorf("couldn't register playlist %s: %w", def.Name, err)
+	}
+	m.playlists[def.Name] = p
+
+	return nil
+}
+
+// must be called under the lock
+func (m *Manager) write() error {
+	f := file{Playlists: make([]Definition, 0, len(m.playlists))}
+	for _, p := range m.playlists {
+		f.Playlists = append(f.Playlists, p.Definition())
+	}
+	sort.Slice(f.Playlists, func(i, j int) bool { return f.Playlists[i].Name < f.Playlists[j].Name })
+
+	buf, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal playlists: %w", err)
+	}
+
+	err = afero.WriteFile(m.fs, m.path, buf, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't write playlists file '%s': %w", m.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package playlist
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRegistry struct {
+	runnerFunc
+	flows map[string]flow.Flow
+	// failing is the definition whose registration fails
+	failing *Definition
+}
+
+func (r *fakeRegistry) Register(f flow.Flow) error {
+	if p, ok := f.(*Playlist); ok && r.failing != nil && len(p.def.Steps) == len(r.failing.Steps) {
+		return errors.New("registry is broken")
+	}
+	r.flows[f.Name()] = f
+	return nil
+}
+
+func (r *fakeRegistry) Unregister(name string) {
+	delete(r.flows, name)
+}
+
+func TestManager_Save(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	registry := &fakeRegistry{
+		runnerFunc: func(ctx context.Context, parent, name string, params flow.Params) error { return nil },
+		flows:      map[string]flow.Flow{},
+	}
+	m, err := NewManager(clock.Real(), fs, registry, Options{Path: "playlists.json"})
+	require.NoError(t, err)
+
+	require.NoError(t, m.Save(Definition{Name: "evening", Steps: []Step{{Flow: "live"}}}))
+	require.Contains(t, registry.flows, "playlist:evening")
+
+	// the failed replacement keeps the old playlist registered
+	replacement := Definition{Name: "evening", Steps: []Step{{Flow: "live"}, {Flow: "replay"}}}
+	registry.failing = &replacement
+	require.ErrorContains(t, m.Save(replacement), "registry is broken")
+	require.Contains(t, registry.flows, "playlist:evening")
+	require.Equal(t, []Definition{{Name: "evening", Steps: []Step{{Flow: "live"}}}}, m.List())
+
+	registry.failing = nil
+	require.NoError(t, m.Save(replacement))
+	require.Equal(t, replacement, registry.flows["playlist:evening"].(*Playlist).Definition())
+
+	reloaded, err := NewManager(clock.Real(), fs, &fakeRegistry{flows: map[string]flow.Flow{}}, Options{Path: "playlists.json"})
+	require.NoError(t, err)
+	require.Equal(t, []Definition{replacement}, reloaded.List())
+}
@@ -0,0 +1,226 @@
+package playlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+)
+
+// namePrefix distinguishes playlist flows from the other ones
+const namePrefix = "playlist:"
+
+// UntilFinished is the step duration which executes the flow until it is finished by itself
+const UntilFinished = "until-finished"
+
+// minCycle is the minimal duration of the whole playlist cycle
+const minCycle = time.Second
+
+// Step is the flow executed for the duration inside the playlist
+type Step struct {
+	Flow string `json:"flow"`
+	// Duration of the step, e.g. '10m'. Empty or 'until-finished' means the step lasts until the flow is finished
+	Duration string      `json:"duration,omitempty"`
+	Params   flow.Params `json:"params,omitempty"`
+}
+
+// Definition is the ordered list of the steps
+type Definition struct {
+	Name    string `json:"name"`
+	Steps   []Step `json:"steps"`
+	Shuffle bool   `json:"shuffle,omitempty"`
+}
+
+// Runner executes flows on the lights of the playlist
+type Runner interface {
+	RunNested(ctx context.Context, parent, name string, params flow.Params) error
+}
+
+type compiledStep struct {
+	Step
+	duration time.Duration
+}
+
+// Playlist is the flow which executes other flows one by one in the endless cycle
+type Playlist struct {
+	name   string
+	runner Runner
+	steps  []compiledStep
+	def    Definition
+	clock  clock.Clock
+	log    *slog.Logger
+	rand   *rand.Rand
+	done   chan struct{}
+
+	mu       sync.Mutex
+	isActive bool
+}
+
+// New validates the definition and creates the playlist flow
+func New(clk clock.Clock, def Definition, runner Runner) (*Playlist, error) {
+	if strings.TrimSpace(def.Name) == "" {
+		return nil, errors.New("playlist name is empty")
+	}
+	if len(def.Steps) == 0 {
+		return nil, fmt.Errorf("playlist %s has no steps", def.Name)
+	}
+
+	steps := make([]compiledStep, 0, len(def.Steps))
+	for i, s := range def.Steps {
+		if s.Flow == "" {
+			return nil, fmt.Errorf("step #%d of playlist %s has no mode", i+1, def.Name)
+		}
+		if s.Flow == namePrefix+def.Name {
+			return nil, fmt.Errorf("playlist %s couldn't contain itself", def.Name)
+		}
+
+		cs := compiledStep{Step: s}
+		if s.Duration != "" && s.Duration != UntilFinished {
+			d, err := time.ParseDuration(s.Duration)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse duration of step #%d of playlist %s: %w", i+1, def.Name, err)
+			}
+			if d <= 0 {
+				return nil, fmt.Errorf("duration of step #%d of playlist %s must be positive", i+1, def.Name)
+			}
+			cs.duration = d
+		}
+		steps = append(steps, cs)
+	}
+
+	return &Playlist{
+		name:   namePrefix + def.Name,
+		runner: runner,
+		steps:  steps,
+		def:    def,
+		clock:  clk,
+		log:    slog.With("flow", namePrefix+def.Name),
+		rand:   rand.New(rand.NewSource(clk.Now().UnixNano())), //no-lint: gosec
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (p *Playlist) Name() string {
+	return p.name
+}
+
+// Definition returns the definition the playlist was created from
+func (p *Playlist) Definition() Definition {
+	return p.def
+}
+
+func (p *Playlist) Start(ctx context.Context) error {
+	p.log.Info("starting flow")
+	p.mu.Lock()
+	p.done = make(chan struct{})
+	p.isActive = true
+	done := p.done
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		started := p.clock.Now()
+		order := p.order()
+
+		failed := 0
+		for _, s := range order {
+			if isDone(ctx, done) {
+				return nil
+			}
+
+			err := p.runStep(ctx, s)
+			if err != nil {
+				failed++
+				p.log.Error("step failed", slog.String("step", s.Flow), slog.Any("err", err))
+			}
+		}
+
+		if isDone(ctx, done) {
+			return nil
+		}
+
+		if failed == len(order) {
+			return fmt.Errorf("all steps of %s failed", p.name)
+		}
+
+		// steps which finish immediately shouldn't spin the cycle
+		if elapsed := p.clock.Now().Sub(started); elapsed < minCycle {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-p.clock.After(minCycle - elapsed):
+			}
+		}
+	}
+}
+
+// isDone reports whether the playlist is stopped. The stop is checked directly,
+// the cancellation of the context by the stop may not be propagated yet
+func isDone(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Playlist) runStep(ctx context.Context, s compiledStep) error {
+	p.log.Info("starting step", slog.String("step", s.Flow), slog.String("duration", s.Duration))
+
+	if s.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		// the step is finished by the clock
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-p.clock.After(s.duration):
+				cancel()
+			}
+		}()
+	}
+
+	return p.runner.RunNested(ctx, p.name, s.Flow, s.Params)
+}
+
+// order returns the steps of the next cycle
+func (p *Playlist) order() []compiledStep {
+	order := append([]compiledStep{}, p.steps...)
+	if p.def.Shuffle {
+		p.rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+	return order
+}
+
+func (p *Playlist) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.isActive {
+		p.log.Info("stopping flow")
+		p.isActive = false
+		close(p.done)
+	}
+}
@@ -0,0 +1,140 @@
+package playlist
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/stretchr/testify/require"
+)
+
+type runnerFunc func(ctx context.Context, parent, name string, params flow.Params) error
+
+func (f runnerFunc) RunNested(ctx context.Context, parent, name string, params flow.Params) error {
+	return f(ctx, parent, name, params)
+}
+
+func TestPlaylist_Start(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+
+	def := Definition{Name: "show", Steps: []Step{
+		{Flow: "live", Duration: "20m"},
+		{Flow: "replay", Duration: UntilFinished, Params: flow.Params{"passes": "1"}},
+	}}
+
+	start := time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+
+	var p *Playlist
+	p, err := New(clk, def, runnerFunc(func(ctx context.Context, parent, name string, params flow.Params) error {
+		require.Equal(t, "playlist:show", parent)
+
+		mu.Lock()
+		calls = append(calls, name)
+		n := len(calls)
+		mu.Unlock()
+
+		if name == "live" {
+			<-ctx.Done() // never finishes by itself
+			return nil
+		}
+
+		require.Equal(t, flow.Params{"passes": "1"}, params)
+		if n == 4 {
+			p.Stop()
+		}
+		return nil
+	}))
+	require.NoError(t, err)
+
+	result := make(chan error, 1)
+	go func() { result <- p.Start(context.Background()) }()
+
+wait:
+	for {
+		select {
+		case err = <-result:
+			break wait
+		case <-time.After(time.Millisecond):
+			clk.AdvanceToNext()
+		}
+	}
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"live", "replay", "live", "replay"}, calls)
+	require.Equal(t, 40*time.Minute, clk.Now().Sub(start))
+}
+
+func TestPlaylist_StartAllStepsFailed(t *testing.T) {
+	p, err := New(clock.Real(), Definition{Name: "broken", Steps: []Step{{Flow: "missing"}}},
+		runnerFunc(func(ctx context.Context, parent, name string, params flow.Params) error {
+			return errors.New("flow missing not found")
+		}))
+	require.NoError(t, err)
+
+	err = p.Start(context.Background())
+	require.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		def     Definition
+		wantErr bool
+	}{
+		{name: "valid", def: Definition{Name: "a", Steps: []Step{{Flow: "live", Duration: "1m"}, {Flow: "replay"}}}},
+		{name: "no name", def: Definition{Steps: []Step{{Flow: "live"}}}, wantErr: true},
+		{name: "no steps", def: Definition{Name: "a"}, wantErr: true},
+		{name: "bad duration", def: Definition{Name: "a", Steps: []Step{{Flow: "live", Duration: "soon"}}}, wantErr: true},
+		{name: "negative duration", def: Definition{Name: "a", Steps: []Step{{Flow: "live", Duration: "-1m"}}}, wantErr: true},
+		{name: "itself", def: Definition{Name: "a", Steps: []Step{{Flow: "playlist:a"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(clock.Real(), tt.def, nil)
+			require.Equal(t, tt.wantErr, err != nil, "New() error = %v, wantErr %v", err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []Step
+		wantErr bool
+	}{
+		{
+			name: "full",
+			raw:  "live 10m\n\nreplay until-finished passes=1\nscript:chaser 2m\nlive max-delay=1m, min-delay=1s",
+			want: []Step{
+				{Flow: "live", Duration: "10m"},
+				{Flow: "replay", Duration: UntilFinished, Params: flow.Params{"passes": "1"}},
+				{Flow: "script:chaser", Duration: "2m"},
+				{Flow: "live", Params: flow.Params{"max-delay": "1m", "min-delay": "1s"}},
+			},
+		},
+		{name: "bad param", raw: "live 10m passes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSteps(tt.raw)
+			require.Equal(t, tt.wantErr, err != nil, "ParseSteps() error = %v, wantErr %v", err, tt.wantErr)
+			if tt.wantErr {
+				return
+			}
+			require.Equal(t, tt.want, got)
+
+			again, err := ParseSteps(FormatSteps(got))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, again)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package playlist
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseSteps parses steps written one per line in the '<mode> [duration|until-finished] [key=value ...]' form
+// e.g. 'live 10m', 'replay until-finished passes=1' or 'script:chaser 2m'. Empty lines are skipped
+func ParseSteps(raw string) ([]Step, error) {
+	steps := []Step{}
+	for i, line := range strings.Split(raw, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		s := Step{Flow: fields[0]}
+		rest := fields[1:]
+		if len(rest) > 0 && !strings.Contains(rest[0], "=") {
+			s.Duration = rest[0]
+			rest = rest[1:]
+		}
+
+		for _, f := range rest {
+			f = strings.Trim(f, ",")
+			if f == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(f, "=")
+			if !ok || k == "" {
+				return nil, fmt.Errorf("line %d: param '%s' is not in the key=value form", i+1, f)
+			}
+			if s.Params == nil {
+				s.Params = map[string]string{}
+			}
+			s.Params[k] = v
+		}
+
+		steps = append(steps, s)
+	}
+
+	return steps, nil
+}
+
+// FormatSteps writes steps in the form accepted by ParseSteps
+func FormatSteps(steps []Step) string {
+	lines := make([]string, 0, len(steps))
+	for _, s := range steps {
+		parts := []string{s.Flow}
+		if s.Duration != "" {
+			parts = append(parts, s.Duration)
+		}
+		if len(s.Params) > 0 {
+			parts = append(parts, strings.ReplaceAll(s.Params.String(), ", ", " "))
+		}
+		lines = append(lines, strings.Join(parts, " "))
+	}
+
+	return strings.Join(lines, "\n")
+}
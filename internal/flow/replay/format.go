@@ -0,0 +1,410 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+)
+
+// Version is the version of the replay format written in the header
+// Files without the header are the JSON lines of the full states, they are loaded as the version 1
+const Version = 2
+
+// What happens with the lights of the frame when it ends
+const (
+	AfterHold  = "hold"
+	AfterClear = "clear"
+)
+
+// Loop markers repeat the frames between them
+const (
+	LoopStart = "start"
+	LoopEnd   = "end"
+)
+
+// Header is the first line of the replay file of the version 2
+type Header struct {
+	Version int    `json:"version"`
+	Name    string `json:"name,omitempty"`
+	Author  string `json:"author,omitempty"`
+	// Mapping is the hash of the layout of the windows the file is recorded for
+	Mapping string `json:"mapping,omitempty"`
+	// Step is the duration of the frames without their own duration
+	Step Duration `json:"step,omitempty"`
+	// After is the default of the frames: hold or clear
+	After string `json:"after,omitempty"`
+}
+
+// Frame is the line of the replay file: the state of the lights shown for the duration or the loop marker
+type Frame struct {
+	Duration Duration `json:"duration,omitempty"`
+	// Delta frames list only the changes, the lights of the full frame which aren't listed are switched off
+	Delta bool `json:"delta,omitempty"`
+	// After is hold to keep the lights till the next frame or clear to switch off the listed lights
+	After  string              `json:"after,omitempty"`
+	Lights []snapshot.LightDTO `json:"lights,omitempty"`
+
+	// Loop marks the start or the end of the repeated frames
+	Loop string `json:"loop,omitempty"`
+	// Times is the number of the passes of the loop, it is set on the end marker
+	Times int `json:"times,omitempty"`
+}
+
+// Duration is the time.Duration written as "1.5s" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var raw string
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return fmt.Errorf("duration must be a string like \"1.5s\", got %s", b)
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Show is the loaded replay file
+type Show struct {
+	Header Header
+	Frames []Frame
+	// Gap is the blank pause between the passes of the show
+	Gap time.Duration
+	// Skipped are the errors of the invalid frames dropped on the load
+	Skipped []error
+}
+
+// maxErrors limits the errors reported for the replay file
+const maxErrors = 10
+
+// LoadOptions are the defaults and the checks of the replay file
+type LoadOptions struct {
+	// Step is the duration of the frames of the files without the header
+	Step time.Duration
+	// Mapping is the wiring the lights of the frames are checked against, nil skips the check
+	Mapping [][]internal.Light
+	// SkipInvalid drops the invalid frames instead of failing, the broken header and loops still fail
+	SkipInvalid bool
+}
+
+// LineError is the error of the line of the replay file, the column is 0 when it is unknown
+type LineError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *LineError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Load reads and validates the replay file of any version
+// All invalid lines are reported up to maxErrors
+func Load(r io.Reader, opts LoadOptions) (*Show, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var windows *snapshot.Windows
+	if opts.Mapping != nil {
+		windows = snapshot.NewWindows(opts.Mapping)
+	}
+
+	var (
+		show  *Show
+		loops []int
+		errs  []error
+		line  int
+	)
+	for line = 1; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		raw := bytes.TrimSpace(text)
+		if len(raw) == 0 {
+			continue
+		}
+		lead := len(text) - len(bytes.TrimLeft(text, " \t"))
+
+		if show == nil {
+			var err error
+			show, err = newShow(raw, opts.Step)
+			if err != nil {
+				return nil, lineError(line, lead, err)
+			}
+			if show.Header.Version == Version {
+				continue
+			}
+		}
+
+		f, err := show.parse(raw)
+		if err == nil {
+			err = resolveLights(raw, f, windows)
+		}
+		if err != nil {
+			if opts.SkipInvalid && !(show.Header.Version == Version && isLoop(raw)) {
+				show.Skipped = append(show.Skipped, lineError(line, lead, err))
+				continue
+			}
+			errs = append(errs, lineError(line, lead, err))
+			continue
+		}
+
+		switch f.Loop {
+		case LoopStart:
+			loops = append(loops, line)
+		case LoopEnd:
+			if len(loops) == 0 {
+				errs = append(errs, &LineError{Line: line, Err: fmt.Errorf("loop end without start")})
+				continue
+			}
+			loops = loops[:len(loops)-1]
+		}
+		show.Frames = append(show.Frames, f)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &LineError{Line: line, Err: fmt.Errorf("couldn't read replay: %w", err)}
+	}
+
+	if show == nil {
+		return nil, fmt.Errorf("replay is empty")
+	}
+	for _, l := range loops {
+		errs = append(errs, &LineError{Line: l, Err: fmt.Errorf("loop isn't ended")})
+	}
+	if len(errs) > maxErrors {
+		errs = append(errs[:maxErrors], fmt.Errorf("%d more errors", len(errs)-maxErrors))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return show, nil
+}
+
+// isLoop reports whether the line is meant to be the loop marker, the frame of the failed line is empty
+// The broken JSON is checked for the key, so the broken marker isn't skipped as the frame
+func isLoop(raw []byte) bool {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return bytes.Contains(raw, []byte(`"loop"`))
+	}
+	_, ok := fields["loop"]
+	return ok
+}
+
+// lineError adds the position to the error, the column is known for the JSON errors
+func lineError(line, lead int, err error) error {
+	var le *LineError
+	if errors.As(err, &le) {
+		le.Line = line
+		if le.Column != 0 {
+			le.Column += lead
+		}
+		return le
+	}
+
+	column := 0
+	var (
+		syntax *json.SyntaxError
+		typed  *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &syntax):
+		column = int(syntax.Offset)
+	case errors.As(err, &typed):
+		column = int(typed.Offset)
+	}
+	if column != 0 {
+		column += lead
+	}
+
+	return &LineError{Line: line, Column: column, Err: err}
+}
+
+// resolveLights sets the wiring of the lights addressed by the window and checks that the lights are wired and listed once
+func resolveLights(raw []byte, f Frame, windows *snapshot.Windows) error {
+	seen := map[internal.LightAddress]bool{}
+	for i, d := range f.Lights {
+		addr := internal.LightAddress{Board: d.Board, Pin: d.Pin}
+		var err error
+		switch {
+		case windows == nil && d.Window != "":
+			err = fmt.Errorf("%s couldn't be resolved without the mapping", lightName(d))
+		case windows != nil:
+			addr, err = windows.Address(d)
+			if _, ok := windows.ID(addr); err == nil && !ok {
+				err = fmt.Errorf("%s isn't in the mapping", lightName(d))
+			}
+		}
+		if err == nil && seen[addr] {
+			err = fmt.Errorf("%s is listed twice", lightName(d))
+		}
+		if err != nil {
+			column := 0
+			if offsets := lightOffsets(raw); i < len(offsets) {
+				column = offsets[i] + 1
+			}
+			return &LineError{Column: column, Err: err}
+		}
+		seen[addr] = true
+		f.Lights[i].Board, f.Lights[i].Pin = addr.Board, addr.Pin
+	}
+	return nil
+}
+
+func lightName(d snapshot.LightDTO) string {
+	if d.Window != "" {
+		return fmt.Sprintf("window '%s'", d.Window)
+	}
+	return fmt.Sprintf("light of board 0x%x pin '%s'", d.Board, d.Pin)
+}
+
+// lightOffsets returns the offsets of the light objects of the frame line
+// The lights are the top level array of the version 1 or the lights field of the version 2
+func lightOffsets(raw []byte) []int {
+	var (
+		dec    = json.NewDecoder(bytes.NewReader(raw))
+		result = []int{}
+		// stack of the open containers, isKey tells the object expects a key
+		stack []json.Delim
+		isKey []bool
+		key   string
+	)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return result
+		}
+
+		d, isDelim := tok.(json.Delim)
+		switch {
+		case isDelim && (d == '{' || d == '['):
+			if d == '{' && (len(stack) == 1 && stack[0] == '[' || len(stack) == 2 && stack[1] == '[' && key == "lights") {
+				result = append(result, int(dec.InputOffset())-1)
+			}
+			stack, isKey = append(stack, d), append(isKey, d == '{')
+		case isDelim:
+			stack, isKey = stack[:len(stack)-1], isKey[:len(isKey)-1]
+			if n := len(stack); n > 0 && stack[n-1] == '{' {
+				isKey[n-1] = true
+			}
+		default:
+			n := len(stack)
+			if n == 0 || stack[n-1] != '{' {
+				continue
+			}
+			if isKey[n-1] && n == 1 {
+				key, _ = tok.(string)
+			}
+			isKey[n-1] = !isKey[n-1]
+		}
+	}
+}
+
+// newShow detects the version of the file by its first line
+func newShow(raw []byte, step time.Duration) (*Show, error) {
+	if raw[0] == '[' {
+		if step <= 0 {
+			return nil, fmt.Errorf("step must be positive, got %s", step)
+		}
+		return &Show{Header: Header{Version: 1, Step: Duration(step), After: AfterClear}, Gap: step}, nil
+	}
+
+	h := Header{}
+	err := json.Unmarshal(raw, &h)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal header: %w", err)
+	}
+	if h.Version != Version {
+		return nil, fmt.Errorf("unsupported version %d, known are 1 and %d", h.Version, Version)
+	}
+
+	// the step which is set must be valid, the missing one is taken from the options
+	fields := map[string]json.RawMessage{}
+	_ = json.Unmarshal(raw, &fields) // the header is already unmarshaled
+	if _, ok := fields["step"]; !ok {
+		h.Step = Duration(step)
+	}
+	if h.Step <= 0 {
+		return nil, fmt.Errorf("step must be positive, got %s", time.Duration(h.Step))
+	}
+	switch h.After {
+	case "":
+		h.After = AfterHold
+	case AfterHold, AfterClear:
+	default:
+		return nil, fmt.Errorf("unknown after '%s', known are %s and %s", h.After, AfterHold, AfterClear)
+	}
+
+	return &Show{Header: h}, nil
+}
+
+// parse reads the frame and fills the defaults of the header
+func (s *Show) parse(raw []byte) (Frame, error) {
+	f := Frame{}
+	if s.Header.Version == 1 {
+		err := json.Unmarshal(raw, &f.Lights)
+		if err != nil {
+			return Frame{}, fmt.Errorf("couldn't unmarshal frame: %w", err)
+		}
+		f.Duration, f.After = s.Header.Step, AfterClear
+		return f, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&f)
+	if err != nil {
+		return Frame{}, fmt.Errorf("couldn't unmarshal frame: %w", err)
+	}
+
+	switch f.Loop {
+	case "":
+	case LoopStart, LoopEnd:
+		if f.Lights != nil || f.Duration != 0 || f.Delta || f.After != "" {
+			return Frame{}, fmt.Errorf("loop marker couldn't have lights")
+		}
+		if f.Loop == LoopEnd && f.Times < 1 {
+			return Frame{}, fmt.Errorf("loop end must have positive times, got %d", f.Times)
+		}
+		return f, nil
+	default:
+		return Frame{}, fmt.Errorf("unknown loop '%s', known are %s and %s", f.Loop, LoopStart, LoopEnd)
+	}
+
+	if f.Duration < 0 {
+		return Frame{}, fmt.Errorf("duration must not be negative, got %s", time.Duration(f.Duration))
+	}
+	if f.Duration == 0 {
+		f.Duration = s.Header.Step
+	}
+	switch f.After {
+	case "":
+		f.After = s.Header.After
+	case AfterHold, AfterClear:
+	default:
+		return Frame{}, fmt.Errorf("unknown after '%s', known are %s and %s", f.After, AfterHold, AfterClear)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,146 @@
+package replay
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	mapping := [][]internal.Light{{
+		{Number: 1, Side: internal.SideFront, Addr: internal.LightAddress{Board: 32, Pin: "A0"}},
+		{Number: 1, Side: internal.SideFront, Addr: internal.LightAddress{Board: 32, Pin: "A1"}},
+		{Number: 2, Side: internal.SideFront, Addr: internal.LightAddress{Board: 32, Pin: "A2"}},
+	}}
+	tests := []struct {
+		name    string
+		raw     string
+		step    time.Duration
+		skip    bool
+		want    *Show
+		wantErr string
+	}{
+		{
+			name: "v1",
+			raw:  "[{\"board\":32,\"pin\":\"A0\",\"is_on\":true}]\n\n[{\"board\":32,\"pin\":\"A0\",\"is_on\":false}]\n",
+			want: &Show{
+				Header: Header{Version: 1, Step: Duration(time.Second), After: AfterClear},
+				Frames: []Frame{
+					{Duration: Duration(time.Second), After: AfterClear, Lights: []snapshot.LightDTO{{Board: 32, Pin: "A0", IsOn: true}}},
+					{Duration: Duration(time.Second), After: AfterClear, Lights: []snapshot.LightDTO{{Board: 32, Pin: "A0"}}},
+				},
+				Gap: time.Second,
+			},
+		},
+		{
+			name: "windows",
+			raw:  "[{\"window\":\"0-front-1-1\",\"is_on\":true},{\"window\":\"0-front-2-0\"}]",
+			want: &Show{
+				Header: Header{Version: 1, Step: Duration(time.Second), After: AfterClear},
+				Frames: []Frame{{Duration: Duration(time.Second), After: AfterClear, Lights: []snapshot.LightDTO{
+					{Window: "0-front-1-1", Board: 32, Pin: "A1", IsOn: true},
+					{Window: "0-front-2-0", Board: 32, Pin: "A2"},
+				}}},
+				Gap: time.Second,
+			},
+		},
+		{
+			name:    "unknown window",
+			raw:     "[{\"window\":\"0-front-1-0\"},{\"window\":\"3-back-9-0\"}]",
+			wantErr: "line 1, column 27: window '3-back-9-0' isn't in the mapping",
+		},
+		{
+			name:    "window listed with its pin",
+			raw:     "[{\"window\":\"0-front-1-0\"},{\"board\":32,\"pin\":\"A0\"}]",
+			wantErr: "line 1, column 27: light of board 0x20 pin 'A0' is listed twice",
+		},
+		{
+			name: "v2",
+			raw: `{"version":2,"name":"wave","author":"me","mapping":"abc","step":"200ms"}
+{"lights":[{"board":32,"pin":"A0","is_on":true}]}
+{"loop":"start"}
+{"duration":"1.5s","delta":true,"after":"clear","lights":[{"board":32,"pin":"A1","is_on":true}]}
+{"loop":"end","times":3}`,
+			want: &Show{
+				Header: Header{Version: 2, Name: "wave", Author: "me", Mapping: "abc", Step: Duration(200 * time.Millisecond), After: AfterHold},
+				Frames: []Frame{
+					{Duration: Duration(200 * time.Millisecond), After: AfterHold, Lights: []snapshot.LightDTO{{Board: 32, Pin: "A0", IsOn: true}}},
+					{Loop: LoopStart},
+					{Duration: Duration(1500 * time.Millisecond), Delta: true, After: AfterClear, Lights: []snapshot.LightDTO{{Board: 32, Pin: "A1", IsOn: true}}},
+					{Loop: LoopEnd, Times: 3},
+				},
+			},
+		},
+		{name: "empty", raw: "\n", wantErr: "replay is empty"},
+		{name: "negative showtime", raw: "[]", step: -time.Second, wantErr: "line 1: step must be positive, got -1s"},
+		{name: "negative showtime of v2", raw: `{"version":2}`, step: -time.Second, wantErr: "line 1: step must be positive, got -1s"},
+		{name: "zero step", raw: `{"version":2,"step":"0s"}`, wantErr: "line 1: step must be positive, got 0s"},
+		{name: "negative step", raw: `{"version":2,"step":"-1s"}`, wantErr: "line 1: step must be positive, got -1s"},
+		{name: "unknown version", raw: `{"version":3}`, wantErr: "line 1: unsupported version 3"},
+		{name: "broken frame", raw: "{\"version\":2}\n{\"lights\":", wantErr: "line 2: couldn't unmarshal frame"},
+		{name: "unknown field", raw: "{\"version\":2}\n{\"light\":[]}", wantErr: "line 2: couldn't unmarshal frame"},
+		{name: "bad duration", raw: "{\"version\":2}\n{\"duration\":5}", wantErr: "line 2: couldn't unmarshal frame"},
+		{name: "bad after", raw: "{\"version\":2}\n{\"after\":\"keep\"}", wantErr: "line 2: unknown after 'keep'"},
+		{name: "loop without times", raw: "{\"version\":2}\n{\"loop\":\"start\"}\n{\"loop\":\"end\"}", wantErr: "line 3: loop end must have positive times"},
+		{name: "loop without start", raw: "{\"version\":2}\n{\"loop\":\"end\",\"times\":2}", wantErr: "line 2: loop end without start"},
+		{name: "loop without end", raw: "{\"version\":2}\n{\"loop\":\"start\"}", wantErr: "line 2: loop isn't ended"},
+		{name: "syntax error", raw: "  [{\"board\":32,\"pin\":\"A0\",\"is_on\":tru}]", wantErr: "line 1, column 38: couldn't unmarshal frame"},
+		{
+			name:    "unknown light",
+			raw:     "{\"version\":2}\n{\"lights\":[{\"board\":32,\"pin\":\"A0\",\"is_on\":true},{\"board\":32,\"pin\":\"Z9\"}]}",
+			wantErr: "line 2, column 49: light of board 0x20 pin 'Z9' isn't in the mapping",
+		},
+		{
+			name:    "light listed twice",
+			raw:     "[{\"board\":32,\"pin\":\"A2\"},{\"board\":32,\"pin\":\"A2\"}]",
+			wantErr: "line 1, column 26: light of board 0x20 pin 'A2' is listed twice",
+		},
+		{
+			name:    "all errors",
+			raw:     "{\"version\":2}\n{\"after\":\"keep\"}\n{\"lights\":[{\"board\":33,\"pin\":\"A0\"}]}",
+			wantErr: "line 2: unknown after 'keep', known are hold and clear\nline 3, column 12: light of board 0x21 pin 'A0' isn't in the mapping",
+		},
+		{
+			name: "invalid frames skipped",
+			raw:  "{\"version\":2}\n{\"after\":\"keep\"}\n{\"lights\":[{\"board\":32,\"pin\":\"A2\",\"is_on\":true}]}",
+			skip: true,
+			want: &Show{
+				Header:  Header{Version: 2, Step: Duration(time.Second), After: AfterHold},
+				Frames:  []Frame{{Duration: Duration(time.Second), After: AfterHold, Lights: []snapshot.LightDTO{{Board: 32, Pin: "A2", IsOn: true}}}},
+				Skipped: []error{&LineError{Line: 2, Err: fmt.Errorf("unknown after 'keep', known are hold and clear")}},
+			},
+		},
+		{
+			name:    "unknown loop isn't skipped",
+			raw:     "{\"version\":2}\n{\"loop\":\"strat\"}\n{\"loop\":\"end\",\"times\":2}",
+			skip:    true,
+			wantErr: "line 2: unknown loop 'strat'",
+		},
+		{
+			name:    "broken loop isn't skipped",
+			raw:     "{\"version\":2}\n{\"loop\":\"start\"}\n{\"loop\":\"end\",\"times\":2",
+			skip:    true,
+			wantErr: "line 3: couldn't unmarshal frame: unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := time.Second
+			if tt.step != 0 {
+				step = tt.step
+			}
+			got, err := Load(strings.NewReader(tt.raw), LoadOptions{Step: step, Mapping: mapping, SkipInvalid: tt.skip})
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+)
+
+// Migrate rewrites the replay file of any version with the lights addressed by the window IDs of the mapping
+// The lights which aren't in the mapping are dropped and returned as the warnings, the empty placeholders are dropped silently
+// The mapping hash of the header is removed, the older files have the hash of the wiring instead of the layout
+func Migrate(r io.Reader, w io.Writer, mapping [][]internal.Light) ([]error, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	windows := snapshot.NewWindows(mapping)
+
+	var (
+		dropped []error
+		version int
+		line    int
+	)
+	convert := func(lights []snapshot.LightDTO) []snapshot.LightDTO {
+		if lights == nil {
+			return nil
+		}
+		result := []snapshot.LightDTO{}
+		for _, d := range lights {
+			if d.Window == "" && d.Pin == "" {
+				// the placeholders of the walls written by the old snapshots
+				continue
+			}
+			addr, err := windows.Address(d)
+			if err != nil {
+				dropped = append(dropped, &LineError{Line: line, Err: err})
+				continue
+			}
+			id, ok := windows.ID(addr)
+			if !ok {
+				dropped = append(dropped, &LineError{Line: line, Err: fmt.Errorf("%s isn't in the mapping", lightName(d))})
+				continue
+			}
+			result = append(result, snapshot.LightDTO{Window: id, IsOn: d.IsOn})
+		}
+		return result
+	}
+
+	for line = 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var out any
+		switch {
+		case version == 0 && raw[0] == '[':
+			version = 1
+			fallthrough
+		case version == 1:
+			lights := []snapshot.LightDTO{}
+			err := json.Unmarshal(raw, &lights)
+			if err != nil {
+				return nil, lineError(line, 0, fmt.Errorf("couldn't unmarshal frame: %w", err))
+			}
+			out = convert(lights)
+		case version == 0:
+			h := Header{}
+			err := json.Unmarshal(raw, &h)
+			if err != nil {
+				return nil, lineError(line, 0, fmt.Errorf("couldn't unmarshal header: %w", err))
+			}
+			if h.Version != Version {
+				return nil, &LineError{Line: line, Err: fmt.Errorf("unsupported version %d, known are 1 and %d", h.Version, Version)}
+			}
+			version, h.Mapping = h.Version, ""
+			out = h
+		default:
+			f := Frame{}
+			err := json.Unmarshal(raw, &f)
+			if err != nil {
+				return nil, lineError(line, 0, fmt.Errorf("couldn't unmarshal frame: %w", err))
+			}
+			f.Lights = convert(f.Lights)
+			out = f
+		}
+
+		buf, err := json.Marshal(out)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't marshal line %d: %w", line, err)
+		}
+		_, err = w.Write(append(buf, '\n'))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't write line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &LineError{Line: line, Err: fmt.Errorf("couldn't read replay: %w", err)}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("replay is empty")
+	}
+
+	return dropped, nil
+}
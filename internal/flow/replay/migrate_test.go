@@ -0,0 +1,70 @@
+package replay
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	mapping := [][]internal.Light{
+		{
+			{Number: 1, Side: internal.SideFront, Addr: internal.LightAddress{Board: 32, Pin: "A0"}},
+			{Side: internal.SideFront, Kind: internal.LightTypeWallStub},
+			{Number: 1, Side: internal.SideFront, Addr: internal.LightAddress{Board: 32, Pin: "A1"}},
+		},
+		{
+			{Number: 2, Side: internal.SideBack, Addr: internal.LightAddress{Board: 33, Pin: "B7"}},
+		},
+	}
+	tests := []struct {
+		name        string
+		raw         string
+		want        string
+		wantDropped []string
+		wantErr     string
+	}{
+		{
+			name:        "v1",
+			raw:         "[{\"board\":32,\"pin\":\"A1\",\"is_on\":true},{\"board\":0,\"pin\":\"\",\"is_on\":false},{\"board\":33,\"pin\":\"B7\",\"is_on\":false}]\n[{\"board\":32,\"pin\":\"A8\",\"is_on\":true}]\n",
+			want:        "[{\"window\":\"0-front-1-1\",\"is_on\":true},{\"window\":\"1-back-2-0\",\"is_on\":false}]\n[]\n",
+			wantDropped: []string{"line 2: light of board 0x20 pin 'A8' isn't in the mapping"},
+		},
+		{
+			name: "v2",
+			raw: "{\"version\":2,\"name\":\"wave\",\"mapping\":\"b94c68726e959eb5\",\"step\":\"200ms\"}\n" +
+				"{\"loop\":\"start\"}\n" +
+				"{\"duration\":\"1.5s\",\"delta\":true,\"lights\":[{\"board\":32,\"pin\":\"A0\",\"is_on\":true},{\"window\":\"1-back-2-0\",\"is_on\":true}]}\n" +
+				"{\"loop\":\"end\",\"times\":3}\n",
+			want: "{\"version\":2,\"name\":\"wave\",\"step\":\"200ms\"}\n" +
+				"{\"loop\":\"start\"}\n" +
+				"{\"duration\":\"1.5s\",\"delta\":true,\"lights\":[{\"window\":\"0-front-1-0\",\"is_on\":true},{\"window\":\"1-back-2-0\",\"is_on\":true}]}\n" +
+				"{\"loop\":\"end\",\"times\":3}\n",
+		},
+		{name: "broken", raw: "[{\"board\":32,", wantErr: "line 1, column 13: couldn't unmarshal frame"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &strings.Builder{}
+			dropped, err := Migrate(strings.NewReader(tt.raw), out, mapping)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, out.String())
+
+			got := []string{}
+			for _, err := range dropped {
+				got = append(got, err.Error())
+			}
+			require.Equal(t, append([]string{}, tt.wantDropped...), got)
+
+			_, err = Load(strings.NewReader(out.String()), LoadOptions{Step: time.Second, Mapping: mapping})
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Directions of the playback
+const (
+	ModeForward  = "forward"
+	ModeReverse  = "reverse"
+	ModePingPong = "ping-pong"
+)
+
+// MaxSpeed limits the speed multiplier of the playback
+const MaxSpeed = 100.0
+
+// ErrNotRunning is returned by the transport controls when the replay isn't running
+var ErrNotRunning = errors.New("replay isn't running")
+
+// Position is the state of the playback
+type Position struct {
+	Name string `json:"name,omitempty"`
+	// Frame is the number of the shown frame starting from 1, the loops of the file are unrolled
+	Frame int `json:"frame"`
+	Total int `json:"total"`
+	// Pass is the number of the pass starting from 1, Passes is 0 for the endless replay
+	Pass   int     `json:"pass"`
+	Passes int     `json:"passes"`
+	Speed  float64 `json:"speed"`
+	Mode   string  `json:"mode"`
+	Paused bool    `json:"paused"`
+}
+
+// player shows the timeline, it is owned by the goroutine of the flow
+// Transport controls are executed by the same goroutine via the inbox
+type player struct {
+	r    *Replay
+	tl   *timeline
+	name string
+
+	// lit is the state of the lights set by the player, nil until the first step is shown
+	lit    bits
+	step   int
+	dir    int
+	pass   int
+	left   time.Duration
+	paused bool
+	speed  float64
+	mode   string
+	passes int
+}
+
+func validateMode(mode string) error {
+	switch mode {
+	case ModeForward, ModeReverse, ModePingPong:
+		return nil
+	}
+	return fmt.Errorf("unknown mode '%s', known are %s, %s and %s", mode, ModeForward, ModeReverse, ModePingPong)
+}
+
+func validateSpeed(speed float64) error {
+	if speed <= 0 || speed > MaxSpeed {
+		return fmt.Errorf("speed must be in (0, %v], got %v", MaxSpeed, speed)
+	}
+	return nil
+}
+
+func newPlayer(r *Replay, tl *timeline, name string, opts Options) *player {
+	p := &player{r: r, tl: tl, name: name, dir: 1, speed: opts.Speed, mode: opts.Mode, passes: opts.Passes}
+	if p.mode == ModeReverse {
+		p.step, p.dir = tl.len()-1, -1
+	}
+	return p
+}
+
+// run plays the timeline until the passes are over or the flow is stopped
+func (p *player) run(ctx context.Context, done <-chan struct{}, inbox <-chan func(p *player)) error {
+	err := p.moveTo(p.step)
+	if err != nil {
+		return err
+	}
+
+	for {
+		p.r.publish(p.position())
+
+		var (
+			timer   <-chan time.Time
+			started = p.r.clock.Now()
+		)
+		if !p.paused {
+			timer = p.r.clock.After(time.Duration(float64(p.left) / p.speed))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case fn := <-inbox:
+			if !p.paused {
+				p.left -= time.Duration(float64(p.r.clock.Now().Sub(started)) * p.speed)
+				p.left = max(p.left, 0)
+			}
+			fn(p)
+		case <-timer:
+			finished, err := p.next()
+			if err != nil {
+				return err
+			}
+			if finished {
+				return p.apply(p.tl.cleared[p.step])
+			}
+		}
+	}
+}
+
+// next moves to the next step of the mode, true means the passes are over
+func (p *player) next() (bool, error) {
+	k := p.step + p.dir
+	if k >= 0 && k < p.tl.len() {
+		return false, p.moveTo(k)
+	}
+
+	p.pass++
+	if p.passes > 0 && p.pass >= p.passes {
+		return true, nil
+	}
+
+	switch p.mode {
+	case ModeForward:
+		k = 0
+	case ModeReverse:
+		k = p.tl.len() - 1
+	case ModePingPong:
+		// the last step isn't shown twice
+		p.dir = -p.dir
+		k = min(max(p.step+p.dir, 0), p.tl.len()-1)
+	}
+	return false, p.moveTo(k)
+}
+
+// moveTo shows the step from its beginning
+func (p *player) moveTo(k int) error {
+	p.step, p.left = k, p.tl.durations[k]
+	return p.apply(p.tl.shown[k])
+}
+
+// apply sets the lights which differ from the state, all lights are set on the first call
+func (p *player) apply(state bits) error {
+	first := p.lit == nil
+	if first {
+		p.lit = make(bits, len(state))
+	}
+
+	for i, addr := range p.tl.addrs {
+		isOn := state.get(i)
+		if !first && p.lit.get(i) == isOn {
+			continue
+		}
+		err := p.r.lights.Set(addr, isOn)
+		if err != nil {
+			return fmt.Errorf("couldn't set light '%v': %w", addr, err)
+		}
+		p.lit.set(i, isOn)
+	}
+
+	return nil
+}
+
+func (p *player) position() Position {
+	return Position{
+		Name:   p.name,
+		Frame:  p.step + 1,
+		Total:  p.tl.len(),
+		Pass:   p.pass + 1,
+		Passes: p.passes,
+		Speed:  p.speed,
+		Mode:   p.mode,
+		Paused: p.paused,
+	}
+}
+
+// Pause stops the playback on the current frame
+func (r *Replay) Pause() error {
+	return r.control(func(p *player) error {
+		p.paused = true
+		return nil
+	})
+}
+
+// Resume continues the playback from the paused frame
+func (r *Replay) Resume() error {
+	return r.control(func(p *player) error {
+		p.paused = false
+		return nil
+	})
+}
+
+// Step pauses the playback and moves by the number of the frames, negative steps go back
+func (r *Replay) Step(by int) error {
+	return r.control(func(p *player) error {
+		p.paused = true
+		return p.moveTo(min(max(p.step+by, 0), p.tl.len()-1))
+	})
+}
+
+// Seek jumps to the frame, the frames are numbered from 1
+func (r *Replay) Seek(frame int) error {
+	return r.control(func(p *player) error {
+		if frame < 1 || frame > p.tl.len() {
+			return fmt.Errorf("frame must be in [1, %d], got %d", p.tl.len(), frame)
+		}
+		return p.moveTo(frame - 1)
+	})
+}
+
+// SetSpeed changes the speed multiplier of the playback
+func (r *Replay) SetSpeed(speed float64) error {
+	err := validateSpeed(speed)
+	if err != nil {
+		return err
+	}
+	return r.control(func(p *player) error {
+		p.speed = speed
+		return nil
+	})
+}
+
+// SetPasses changes the number of the passes, 0 means endless
+// The replay is finished at the end of the current pass if it is over the new number
+func (r *Replay) SetPasses(passes int) error {
+	if passes < 0 {
+		return fmt.Errorf("passes must not be negative, got %d", passes)
+	}
+	return r.control(func(p *player) error {
+		p.passes = passes
+		return nil
+	})
+}
+
+// SetMode changes the direction of the playback: forward, reverse or ping-pong
+func (r *Replay) SetMode(mode string) error {
+	err := validateMode(mode)
+	if err != nil {
+		return err
+	}
+	return r.control(func(p *player) error {
+		p.mode = mode
+		switch mode {
+		case ModeForward:
+			p.dir = 1
+		case ModeReverse:
+			p.dir = -1
+		}
+		return nil
+	})
+}
+
+// control executes the function on the goroutine of the player
+func (r *Replay) control(fn func(p *player) error) error {
+	r.mu.Lock()
+	inbox, exited := r.inbox, r.exited
+	r.mu.Unlock()
+	if inbox == nil {
+		return ErrNotRunning
+	}
+
+	result := make(chan error, 1)
+	select {
+	case inbox <- func(p *player) { result <- fn(p) }:
+	case <-exited:
+		return ErrNotRunning
+	}
+
+	return <-result
+}
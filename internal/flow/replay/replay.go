@@ -1,7 +1,7 @@
 package replay
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,47 +10,86 @@ import (
 	"time"
 
 	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
 	"github.com/mbobakov/khrushchevka/internal/lights"
 	"github.com/mbobakov/khrushchevka/internal/snapshot"
 	"github.com/spf13/afero"
 )
 
 type Options struct {
-	Showtime   time.Duration `long:"showtime" env:"SHOWTIME" default:"1s" description:"step showtime"`
-	ReplayFile string        `long:"replay-file" env:"REPLAY_FILE" default:"./snapshot.json" description:"replay file"`
+	Showtime    time.Duration `long:"showtime" env:"SHOWTIME" default:"1s" description:"step showtime, replay files of the version 2 set it in the header"`
+	ReplayFile  string        `long:"replay-file" env:"REPLAY_FILE" description:"replay file, the default snapshot sequence is played without it"`
+	Passes      int           `long:"passes" env:"PASSES" default:"0" description:"number of passes over the replay file, 0 means endless"`
+	Speed       float64       `long:"speed" env:"SPEED" default:"1" description:"speed multiplier of the playback"`
+	Mode        string        `long:"mode" env:"MODE" default:"forward" description:"direction of the playback: forward, reverse or ping-pong"`
+	SkipInvalid bool          `long:"skip-invalid" env:"SKIP_INVALID" description:"skip the invalid frames of the replay file instead of failing"`
+	Sequence    string        `long:"sequence" env:"SEQUENCE" description:"snapshot sequence to play instead of the replay file"`
 }
 
 const (
-	name = "replay"
+	// FlowName is the name of the flow in the registry
+	FlowName = "replay"
 )
 
 type Replay struct {
-	lights lights.ControllerI
-	fs     afero.Fs
-	opts   Options
-	done   chan struct{}
-
-	mu       sync.Mutex
-	isActive bool
+	lights  lights.ControllerI
+	mapping [][]internal.Light
+	fs      afero.Fs
+	store   snapshot.Store
+	clock   clock.Clock
+	base    Options
+	opts    Options
+	done    chan struct{}
+
+	mu          sync.Mutex
+	isActive    bool
+	inbox       chan func(p *player)
+	exited      chan struct{}
+	position    Position
+	subscribers []chan Position
 }
 
-func New(fs afero.Fs, lights lights.ControllerI, opts Options) *Replay {
+// New creates the replay, the store of the snapshot sequences could be nil
+func New(clk clock.Clock, fs afero.Fs, store snapshot.Store, lights lights.ControllerI, mapping [][]internal.Light, opts Options) *Replay {
 	return &Replay{
-		fs:     fs,
-		lights: lights,
-		opts:   opts,
-		done:   make(chan struct{}),
+		mapping: mapping,
+		fs:      fs,
+		store:   store,
+		clock:   clk,
+		lights:  lights,
+		base:    opts,
+		opts:    opts,
+		done:    make(chan struct{}),
 	}
 }
 
 func (r *Replay) Name() string {
-	return name
+	return FlowName
+}
+
+// Configure applies params on top of the options the flow was created with
+func (r *Replay) Configure(p flow.Params) error {
+	opts := r.base
+	err := flow.ApplyParams(&opts, p)
+	if err != nil {
+		return err
+	}
+	if opts.Showtime <= 0 {
+		return fmt.Errorf("showtime must be positive, got %s", opts.Showtime)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.opts = opts
+
+	return nil
 }
 
 func (r *Replay) Start(ctx context.Context) error {
-	slog.Info("starting flow", "flow", name)
-	r.done = make(chan struct{})
+	slog.Info("starting flow", "flow", FlowName)
 	r.mu.Lock()
+	r.done = make(chan struct{})
 	r.isActive = true
 	r.mu.Unlock()
 
@@ -62,53 +101,143 @@ func (m *Replay) Stop() {
 	defer m.mu.Unlock()
 
 	if m.isActive {
-		slog.Info("stopping flow", "flow", name)
+		slog.Info("stopping flow", "flow", FlowName)
 		m.isActive = false
 		close(m.done)
 	}
 }
 
 func (r *Replay) mainCycle(ctx context.Context) error {
-	// Open the file
-	file, err := r.fs.Open(r.opts.ReplayFile)
+	r.mu.Lock()
+	opts, done := r.opts, r.done
+	r.mu.Unlock()
+
+	err := validateSpeed(opts.Speed)
 	if err != nil {
-		return fmt.Errorf("couldn't open file '%s': %w", r.opts.ReplayFile, err)
-	}
-	defer file.Close()
-
-	for {
-		// Create a scanner to read the file line by line
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			if ctx.Err() != nil || r.IsActive() == false {
-				return nil
-			}
-
-			data := []snapshot.LightDTO{}
-			err := json.Unmarshal(scanner.Bytes(), &data)
-			if err != nil {
-				return fmt.Errorf("couldn't unmarshal data: %w", err)
-			}
-
-			for _, d := range data {
-				err := r.lights.Set(internal.LightAddress{Board: d.Board, Pin: d.Pin}, d.IsOn)
-				if err != nil {
-					return fmt.Errorf("couldn't set light '%v': %w", d, err)
-				}
-			}
-
-			time.Sleep(r.opts.Showtime)
-
-			for _, d := range data {
-				err := r.lights.Set(internal.LightAddress{Board: d.Board, Pin: d.Pin}, false)
-				if err != nil {
-					return fmt.Errorf("couldn't set light '%v': %w", d, err)
-				}
-			}
+		return err
+	}
+	err = validateMode(opts.Mode)
+	if err != nil {
+		return err
+	}
 
+	// the snapshots are played when nothing else is chosen
+	if opts.ReplayFile == "" && opts.Sequence == "" {
+		opts.Sequence = snapshot.DefaultSequence
+	}
+	source := opts.ReplayFile
+	if opts.Sequence != "" {
+		source = "sequence:" + opts.Sequence
+	}
+	show, err := r.load(opts)
+	if err != nil {
+		return fmt.Errorf("couldn't load replay '%s': %w", source, err)
+	}
+	tl, err := compile(show)
+	if err != nil {
+		return fmt.Errorf("couldn't load replay '%s': %w", source, err)
+	}
+
+	slog.Info("replay is loaded", "flow", FlowName, "source", source, "version", show.Header.Version,
+		"name", show.Header.Name, "author", show.Header.Author, "frames", tl.len())
+	for _, err := range show.Skipped {
+		slog.Warn("invalid frame is skipped", "flow", FlowName, "source", source, "err", err)
+	}
+	if m := snapshot.MappingHash(r.mapping); show.Header.Mapping != "" && show.Header.Mapping != m {
+		slog.Warn("replay is recorded for another layout", "flow", FlowName, "source", source,
+			"mapping", show.Header.Mapping, "expected", m)
+	}
+
+	inbox, exited := make(chan func(p *player)), make(chan struct{})
+	r.mu.Lock()
+	r.inbox, r.exited = inbox, exited
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.inbox = nil
+		r.mu.Unlock()
+		close(exited)
+		r.publish(Position{})
+	}()
+
+	return newPlayer(r, tl, show.Header.Name, opts).run(ctx, done, inbox)
+}
+
+// load reads the snapshot sequence of the store or the replay file
+func (r *Replay) load(opts Options) (*Show, error) {
+	lopts := LoadOptions{Step: opts.Showtime, Mapping: r.mapping, SkipInvalid: opts.SkipInvalid}
+	if opts.Sequence == "" {
+		file, err := r.fs.Open(opts.ReplayFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open file: %w", err)
+		}
+		defer file.Close()
+
+		return Load(file, lopts)
+	}
+
+	if r.store == nil {
+		return nil, fmt.Errorf("snapshot sequences aren't available")
+	}
+	seq, err := r.store.Get(opts.Sequence)
+	if err != nil {
+		return nil, err
+	}
+
+	// the frames are the lines of the version 1, so they are validated as the file
+	buf := &bytes.Buffer{}
+	for _, frame := range seq.Frames {
+		line, err := json.Marshal(frame)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't marshal frame: %w", err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	show, err := Load(buf, lopts)
+	if err != nil {
+		return nil, err
+	}
+	show.Header.Name = seq.Name
+
+	return show, nil
+}
+
+// Position returns the state of the playback, it reports false if the replay isn't running
+func (r *Replay) Position() (Position, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.position, r.isActive && r.position.Total > 0
+}
+
+// Subscribe sends the state of the playback to the channel on every change
+// The zero position is sent when the replay ends. Slow subscriber gets only the latest position
+func (r *Replay) Subscribe(ch chan<- Position) {
+	latest := make(chan Position, 1)
+	go func() {
+		for p := range latest {
+			ch <- p
 		}
+	}()
 
-		time.Sleep(r.opts.Showtime)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.subscribers = append(r.subscribers, latest)
+}
+
+// publish replaces the position which isn't delivered yet, so the playback doesn't wait for the subscribers
+func (r *Replay) publish(p Position) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.position = p
+	for _, latest := range r.subscribers {
+		select {
+		case <-latest:
+		default:
+		}
+		latest <- p
 	}
 }
 
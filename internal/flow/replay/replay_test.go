@@ -0,0 +1,224 @@
+package replay
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+	"github.com/mbobakov/khrushchevka/internal/snapshot/file"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay_play(t *testing.T) {
+	var (
+		start = time.Date(2024, 1, 5, 21, 0, 0, 0, time.UTC)
+		clk   = clock.NewFake(start)
+		fs    = afero.NewMemMapFs()
+		l     = lights.NewTestController([]uint8{0x20})
+		ch    = make(chan internal.PinState, 100)
+	)
+	l.Subscribe(ch)
+	require.NoError(t, afero.WriteFile(fs, "show.json", []byte(strings.Join([]string{
+		`{"version":2,"step":"1s"}`,
+		`{"lights":[{"board":32,"pin":"A0","is_on":true}]}`,
+		`{"loop":"start"}`,
+		`{"duration":"500ms","delta":true,"after":"clear","lights":[{"board":32,"pin":"A1","is_on":true}]}`,
+		`{"loop":"end","times":2}`,
+		`{"lights":[{"board":32,"pin":"A2","is_on":true}]}`,
+	}, "\n")), 0644))
+
+	r := New(clk, fs, nil, l, internal.BuildingMap.Levels, Options{ReplayFile: "show.json", Showtime: time.Second, Passes: 1, Speed: 1, Mode: ModeForward})
+	done := make(chan error)
+	go func() { done <- r.Start(context.Background()) }()
+
+	type change struct {
+		at   time.Duration
+		pin  string
+		isOn bool
+	}
+	got := []change{}
+	collect := func() {
+		for {
+			select {
+			case s := <-ch:
+				got = append(got, change{at: clk.Now().Sub(start), pin: s.Addr.Pin, isOn: s.IsOn})
+			default:
+				return
+			}
+		}
+	}
+	for i := 0; i < 4; i++ {
+		clk.BlockUntil(1)
+		collect()
+		clk.AdvanceToNext()
+	}
+	require.NoError(t, <-done)
+	collect()
+
+	require.Equal(t, []change{
+		// the first frame sets all lights of the show
+		{at: 0, pin: "A0", isOn: true},
+		{at: 0, pin: "A1", isOn: false},
+		{at: 0, pin: "A2", isOn: false},
+		{at: time.Second, pin: "A1", isOn: true},
+		// the cleared light is lit again by the next pass of the loop
+		{at: 2 * time.Second, pin: "A0", isOn: false},
+		{at: 2 * time.Second, pin: "A1", isOn: false},
+		{at: 2 * time.Second, pin: "A2", isOn: true},
+	}, got)
+}
+
+func TestReplay_loadSequence(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := file.New(file.Options{Dir: "snapshots"}, fs)
+	require.NoError(t, store.Create("evening"))
+	require.NoError(t, store.Append("evening", []snapshot.LightDTO{{Window: "0-front-4-0", IsOn: true}}))
+
+	r := New(clock.Real(), fs, store, nil, internal.BuildingMap.Levels, Options{})
+	show, err := r.load(Options{Sequence: "evening", Showtime: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, "evening", show.Header.Name)
+	require.Equal(t, []snapshot.LightDTO{{Window: "0-front-4-0", Board: 0x24, Pin: "A0", IsOn: true}}, show.Frames[0].Lights)
+
+	_, err = r.load(Options{Sequence: "morning"})
+	require.ErrorIs(t, err, snapshot.ErrNotFound)
+}
+
+func TestReplay_defaultSequence(t *testing.T) {
+	var (
+		clk   = clock.NewFake(time.Date(2024, 1, 5, 21, 0, 0, 0, time.UTC))
+		fs    = afero.NewMemMapFs()
+		store = file.New(file.Options{Dir: "snapshots"}, fs)
+		l     = lights.NewTestController([]uint8{0x20, 0x21, 0x22, 0x23, 0x24, 0x25})
+		addr  = internal.LightAddress{Board: 0x24, Pin: "A0"}
+	)
+	// the button snapshots to the default sequence
+	require.NoError(t, l.Set(addr, true))
+	require.NoError(t, snapshot.Snapshot(store, snapshot.DefaultSequence, l, internal.BuildingMap.Levels))
+	require.NoError(t, l.Reset())
+
+	r := New(clk, fs, store, l, internal.BuildingMap.Levels, Options{Showtime: time.Second, Passes: 1, Speed: 1, Mode: ModeForward})
+	done := make(chan error)
+	go func() { done <- r.Start(context.Background()) }()
+
+	clk.BlockUntil(1)
+	isOn, err := l.IsOn(addr)
+	require.NoError(t, err)
+	require.True(t, isOn)
+
+	for {
+		select {
+		case err = <-done:
+			require.NoError(t, err)
+			return
+		case <-time.After(time.Millisecond):
+			clk.AdvanceToNext()
+		}
+	}
+}
+
+func TestReplay_Configure(t *testing.T) {
+	r := New(clock.Real(), afero.NewMemMapFs(), nil, nil, internal.BuildingMap.Levels, Options{Showtime: time.Second})
+	require.NoError(t, r.Configure(flow.Params{"showtime": "2s"}))
+	require.ErrorContains(t, r.Configure(flow.Params{"showtime": "0s"}), "showtime must be positive, got 0s")
+	require.Equal(t, 2*time.Second, r.opts.Showtime)
+}
+
+func TestReplay_transport(t *testing.T) {
+	var (
+		start = time.Date(2024, 1, 5, 21, 0, 0, 0, time.UTC)
+		clk   = clock.NewFake(start)
+		fs    = afero.NewMemMapFs()
+		l     = lights.NewTestController([]uint8{0x20})
+		ch    = make(chan Position)
+	)
+	// a light runs over three pins
+	require.NoError(t, afero.WriteFile(fs, "show.json", []byte(strings.Join([]string{
+		`{"version":2,"name":"run","step":"1s"}`,
+		`{"lights":[{"board":32,"pin":"A0","is_on":true}]}`,
+		`{"lights":[{"board":32,"pin":"A1","is_on":true}]}`,
+		`{"lights":[{"board":32,"pin":"A2","is_on":true}]}`,
+	}, "\n")), 0644))
+
+	r := New(clk, fs, nil, l, internal.BuildingMap.Levels, Options{ReplayFile: "show.json", Showtime: time.Second, Passes: 3, Speed: 1, Mode: ModePingPong})
+	r.Subscribe(ch)
+	r.Subscribe(make(chan Position)) // the stuck subscriber doesn't hold the playback
+	require.ErrorIs(t, r.Pause(), ErrNotRunning)
+
+	done := make(chan error)
+	go func() { done <- r.Start(context.Background()) }()
+
+	lit := func() string {
+		result := ""
+		for _, pin := range []string{"A0", "A1", "A2"} {
+			if on, _ := l.IsOn(internal.LightAddress{Board: 0x20, Pin: pin}); on {
+				result += pin
+			}
+		}
+		return result
+	}
+	// control runs the transport command, the position is published by the player meanwhile
+	control := func(fn func() error) Position {
+		errCh := make(chan error)
+		go func() { errCh <- fn() }()
+		p := <-ch
+		require.NoError(t, <-errCh)
+		return p
+	}
+	advance := func() Position {
+		clk.BlockUntil(1)
+		clk.AdvanceToNext()
+		return <-ch
+	}
+
+	p := <-ch
+	require.Equal(t, Position{Name: "run", Frame: 1, Total: 3, Pass: 1, Passes: 3, Speed: 1, Mode: ModePingPong}, p)
+	require.Equal(t, "A0", lit())
+
+	require.Equal(t, 2, advance().Frame)
+	require.Equal(t, 3, advance().Frame)
+	p = advance()
+	require.Equal(t, 2, p.Frame, "ping-pong goes back without repeating the last frame")
+	require.Equal(t, 2, p.Pass)
+	require.Equal(t, "A1", lit())
+
+	p = control(r.Pause)
+	require.True(t, p.Paused)
+	p = control(func() error { return r.Step(-1) })
+	require.Equal(t, 1, p.Frame)
+	require.Equal(t, "A0", lit())
+	p = control(func() error { return r.Seek(3) })
+	require.Equal(t, 3, p.Frame)
+	require.Equal(t, "A2", lit())
+	require.Error(t, r.Seek(4))
+	require.Equal(t, 3, (<-ch).Frame)
+	require.Error(t, r.SetSpeed(0))
+	require.Error(t, r.SetMode("shuffle"))
+
+	control(func() error { return r.SetSpeed(4) })
+	control(func() error { return r.SetMode(ModeForward) })
+	p = control(r.Resume)
+	require.Equal(t, Position{Name: "run", Frame: 3, Total: 3, Pass: 2, Passes: 3, Speed: 4, Mode: ModeForward}, p)
+
+	before := clk.Now()
+	p = advance()
+	require.Equal(t, 250*time.Millisecond, clk.Now().Sub(before), "the frame is shown 4 times faster")
+	require.Equal(t, 1, p.Frame)
+	require.Equal(t, 3, p.Pass)
+
+	control(func() error { return r.SetPasses(1) })
+	require.Equal(t, 2, advance().Frame, "the current pass is finished")
+	require.Equal(t, 3, advance().Frame)
+	require.Equal(t, Position{}, advance(), "passes are over")
+	require.NoError(t, <-done)
+
+	_, ok := r.Position()
+	require.False(t, ok)
+}
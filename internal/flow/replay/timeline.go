@@ -0,0 +1,116 @@
+package replay
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+// maxSteps limits the frames of the show with the loops unrolled
+const maxSteps = 100000
+
+// timeline is the show with the unrolled loops and the state of the lights at every step
+// The states are precomputed, so the show could be played backwards and from any step
+type timeline struct {
+	addrs     []internal.LightAddress
+	durations []time.Duration
+	// shown are the states of the lights during the steps, cleared are the states after them
+	shown   []bits
+	cleared []bits
+}
+
+// bits is the state of the lights of the timeline by index
+type bits []uint64
+
+func (b bits) get(i int) bool { return b[i/64]&(1<<(i%64)) != 0 }
+
+func (b bits) set(i int, v bool) {
+	if v {
+		b[i/64] |= 1 << (i % 64)
+	} else {
+		b[i/64] &^= 1 << (i % 64)
+	}
+}
+
+func compile(show *Show) (*timeline, error) {
+	t := &timeline{}
+	index := map[internal.LightAddress]int{}
+	for _, f := range show.Frames {
+		for _, d := range f.Lights {
+			addr := internal.LightAddress{Board: d.Board, Pin: d.Pin}
+			if _, ok := index[addr]; !ok {
+				index[addr] = len(t.addrs)
+				t.addrs = append(t.addrs, addr)
+			}
+		}
+	}
+	words := (len(t.addrs) + 63) / 64
+
+	type loop struct {
+		start  int
+		passes int
+	}
+	var (
+		loops = []loop{}
+		state = make(bits, words)
+	)
+	for i := 0; i < len(show.Frames); i++ {
+		f := show.Frames[i]
+		switch f.Loop {
+		case LoopStart:
+			loops = append(loops, loop{start: i})
+			continue
+		case LoopEnd:
+			top := &loops[len(loops)-1]
+			top.passes++
+			if top.passes < f.Times {
+				i = top.start
+			} else {
+				loops = loops[:len(loops)-1]
+			}
+			continue
+		}
+
+		if len(t.durations) >= maxSteps {
+			return nil, fmt.Errorf("replay is longer than %d frames with the loops", maxSteps)
+		}
+
+		shown := make(bits, words)
+		if f.Delta {
+			copy(shown, state)
+		}
+		for _, d := range f.Lights {
+			shown.set(index[internal.LightAddress{Board: d.Board, Pin: d.Pin}], d.IsOn)
+		}
+
+		state = shown
+		if f.After == AfterClear {
+			state = make(bits, words)
+			copy(state, shown)
+			for _, d := range f.Lights {
+				state.set(index[internal.LightAddress{Board: d.Board, Pin: d.Pin}], false)
+			}
+		}
+
+		t.durations = append(t.durations, time.Duration(f.Duration))
+		t.shown = append(t.shown, shown)
+		t.cleared = append(t.cleared, state)
+	}
+
+	if len(t.durations) == 0 {
+		return nil, fmt.Errorf("replay has no frames")
+	}
+	if show.Gap > 0 {
+		// the blank pause between the passes of the snapshots
+		t.durations = append(t.durations, show.Gap)
+		t.shown = append(t.shown, make(bits, words))
+		t.cleared = append(t.cleared, make(bits, words))
+	}
+
+	return t, nil
+}
+
+func (t *timeline) len() int {
+	return len(t.durations)
+}
@@ -0,0 +1,422 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// sides and kinds are the names of the light properties available for the scripts
+var (
+	sides = map[internal.Side]string{
+		internal.SideFront: "front",
+		internal.SideRight: "right",
+		internal.SideBack:  "back",
+		internal.SideLeft:  "left",
+	}
+	kinds = map[internal.LightType]string{
+		internal.LightTypeServiceEntrance:  "entrance",
+		internal.LightTypeServiceNoManLand: "landing",
+		internal.LightTypeShortWindow:      "short",
+		internal.LightTypeLongWindow:       "long",
+	}
+)
+
+type timer struct {
+	at     time.Time
+	every  time.Duration
+	fn     starlark.Callable
+	seqNum int
+}
+
+// runtime is the state of the single script execution
+type runtime struct {
+	ctx    context.Context
+	script *Script
+	timers []*timer
+	seq    int
+}
+
+// predeclared returns the sandboxed API of the script
+//
+//	lights(flat=None, floor=None, side=None, kind=None) -> list of lights
+//	set(lights, on), toggle(lights), is_on(light) -> bool, reset()
+//	sleep(seconds), after(seconds, fn), every(seconds, fn)
+//	random() -> float, randint(a, b) -> int, choice(seq)
+//	now() -> struct(year, month, day, weekday, hour, minute, second, unix)
+func (r *runtime) predeclared() starlark.StringDict {
+	return starlark.StringDict{
+		"lights":  starlark.NewBuiltin("lights", r.lights),
+		"set":     starlark.NewBuiltin("set", r.set),
+		"toggle":  starlark.NewBuiltin("toggle", r.toggle),
+		"is_on":   starlark.NewBuiltin("is_on", r.isOn),
+		"reset":   starlark.NewBuiltin("reset", r.reset),
+		"sleep":   starlark.NewBuiltin("sleep", r.sleep),
+		"after":   starlark.NewBuiltin("after", r.after),
+		"every":   starlark.NewBuiltin("every", r.every),
+		"random":  starlark.NewBuiltin("random", r.random),
+		"randint": starlark.NewBuiltin("randint", r.randint),
+		"choice":  starlark.NewBuiltin("choice", r.choice),
+		"now":     starlark.NewBuiltin("now", r.now),
+	}
+}
+
+func lightValue(floor int, l internal.Light) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("light"), starlark.StringDict{
+		"flat":  starlark.MakeInt(l.Number),
+		"floor": starlark.MakeInt(floor),
+		"side":  starlark.String(sides[l.Side]),
+		"kind":  starlark.String(kinds[l.Kind]),
+		"board": starlark.MakeInt(int(l.Addr.Board)),
+		"pin":   starlark.String(l.Addr.Pin),
+	})
+}
+
+func (r *runtime) lights(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		flat, floor = -1, -1
+		side, kind  string
+	)
+
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "flat?", &flat, "floor?", &floor, "side?", &side, "kind?", &kind)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []starlark.Value{}
+	for lvl, row := range r.script.mapping {
+		for _, l := range row {
+			switch {
+			case l.Addr.Pin == "",
+				flat != -1 && l.Number != flat,
+				floor != -1 && lvl != floor,
+				side != "" && sides[l.Side] != side,
+				kind != "" && kinds[l.Kind] != kind:
+				continue
+			}
+			result = append(result, lightValue(lvl, l))
+		}
+	}
+
+	return starlark.NewList(result), nil
+}
+
+// addresses converts the light or the iterable of the lights to addresses
+func addresses(v starlark.Value) ([]internal.LightAddress, error) {
+	if s, ok := v.(*starlarkstruct.Struct); ok {
+		addr, err := address(s)
+		if err != nil {
+			return nil, err
+		}
+		return []internal.LightAddress{addr}, nil
+	}
+
+	iterable, ok := v.(starlark.Iterable)
+	if !ok {
+		return nil, fmt.Errorf("got %s, want light or list of lights", v.Type())
+	}
+
+	result := []internal.LightAddress{}
+	iter := iterable.Iterate()
+	defer iter.Done()
+
+	var x starlark.Value
+	for iter.Next(&x) {
+		s, ok := x.(*starlarkstruct.Struct)
+		if !ok {
+			return nil, fmt.Errorf("got %s in the list, want light", x.Type())
+		}
+		addr, err := address(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, addr)
+	}
+
+	return result, nil
+}
+
+func address(s *starlarkstruct.Struct) (internal.LightAddress, error) {
+	board, err := s.Attr("board")
+	if err != nil {
+		return internal.LightAddress{}, fmt.Errorf("light has no board: %w", err)
+	}
+	pin, err := s.Attr("pin")
+	if err != nil {
+		return internal.LightAddress{}, fmt.Errorf("light has no pin: %w", err)
+	}
+
+	b, err := starlark.AsInt32(board)
+	if err != nil {
+		return internal.LightAddress{}, fmt.Errorf("board must be int: %w", err)
+	}
+	p, ok := starlark.AsString(pin)
+	if !ok {
+		return internal.LightAddress{}, fmt.Errorf("pin must be string")
+	}
+
+	return internal.LightAddress{Board: uint8(b), Pin: p}, nil
+}
+
+func (r *runtime) set(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		target starlark.Value
+		on     bool
+	)
+
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "lights", &target, "on", &on)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := addresses(target)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	for _, a := range addrs {
+		err := r.script.lights.Set(a, on)
+		if err != nil {
+			return nil, fmt.Errorf("%s: couldn't set light '%v': %w", fn.Name(), a, err)
+		}
+	}
+
+	return starlark.None, nil
+}
+
+func (r *runtime) toggle(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var target starlark.Value
+
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "lights", &target)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := addresses(target)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	for _, a := range addrs {
+		isOn, err := r.script.lights.IsOn(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s: couldn't get light '%v': %w", fn.Name(), a, err)
+		}
+		err = r.script.lights.Set(a, !isOn)
+		if err != nil {
+			return nil, fmt.Errorf("%s: couldn't set light '%v': %w", fn.Name(), a, err)
+		}
+	}
+
+	return starlark.None, nil
+}
+
+func (r *runtime) isOn(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var target *starlarkstruct.Struct
+
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "light", &target)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := address(target)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	isOn, err := r.script.lights.IsOn(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: couldn't get light '%v': %w", fn.Name(), addr, err)
+	}
+
+	return starlark.Bool(isOn), nil
+}
+
+func (r *runtime) reset(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.script.lights.Reset()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	return starlark.None, nil
+}
+
+func seconds(v starlark.Value) (time.Duration, error) {
+	f, ok := starlark.AsFloat(v)
+	if !ok {
+		return 0, fmt.Errorf("got %s, want number of seconds", v.Type())
+	}
+	if f < 0 {
+		return 0, fmt.Errorf("seconds must be positive, got %f", f)
+	}
+
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+// wait blocks for the duration or until the script is stopped
+// The steps budget of the thread is renewed after the wait
+func (r *runtime) wait(thread *starlark.Thread, d time.Duration) error {
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-r.script.clock.After(d):
+		thread.SetMaxExecutionSteps(thread.ExecutionSteps() + maxSteps)
+		return nil
+	}
+}
+
+func (r *runtime) sleep(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var raw starlark.Value
+
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "seconds", &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := seconds(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	err = r.wait(thread, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return starlark.None, nil
+}
+
+func (r *runtime) addTimer(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, repeat bool) (starlark.Value, error) {
+	var (
+		raw      starlark.Value
+		callback starlark.Callable
+	)
+
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "seconds", &raw, "fn", &callback)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := seconds(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	t := &timer{at: r.script.clock.Now().Add(d), fn: callback, seqNum: r.seq}
+	if repeat {
+		if d == 0 {
+			return nil, fmt.Errorf("%s: interval must be positive", fn.Name())
+		}
+		t.every = d
+	}
+	r.seq++
+	r.timers = append(r.timers, t)
+
+	return starlark.None, nil
+}
+
+func (r *runtime) after(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	return r.addTimer(fn, args, kwargs, false)
+}
+
+func (r *runtime) every(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	return r.addTimer(fn, args, kwargs, true)
+}
+
+// runTimers executes the timers registered by the script until there are no timers left
+func (r *runtime) runTimers(thread *starlark.Thread) error {
+	for len(r.timers) > 0 {
+		sort.SliceStable(r.timers, func(i, j int) bool {
+			if r.timers[i].at.Equal(r.timers[j].at) {
+				return r.timers[i].seqNum < r.timers[j].seqNum
+			}
+			return r.timers[i].at.Before(r.timers[j].at)
+		})
+
+		t := r.timers[0]
+		r.timers = r.timers[1:]
+
+		err := r.wait(thread, t.at.Sub(r.script.clock.Now()))
+		if err != nil {
+			return err
+		}
+
+		if t.every > 0 {
+			t.at = t.at.Add(t.every)
+			r.timers = append(r.timers, t)
+		}
+
+		_, err = starlark.Call(thread, t.fn, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *runtime) random(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	return starlark.Float(r.script.rand.Float64()), nil
+}
+
+func (r *runtime) randint(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var a, b int
+
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "a", &a, "b", &b)
+	if err != nil {
+		return nil, err
+	}
+	if a > b {
+		return nil, fmt.Errorf("%s: empty range [%d, %d]", fn.Name(), a, b)
+	}
+
+	return starlark.MakeInt(a + r.script.rand.Intn(b-a+1)), nil
+}
+
+func (r *runtime) choice(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seq starlark.Indexable
+
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "seq", &seq)
+	if err != nil {
+		return nil, err
+	}
+	if seq.Len() == 0 {
+		return nil, fmt.Errorf("%s: empty sequence", fn.Name())
+	}
+
+	return seq.Index(r.script.rand.Intn(seq.Len())), nil
+}
+
+func (r *runtime) now(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.script.clock.Now()
+	return starlarkstruct.FromStringDict(starlark.String("time"), starlark.StringDict{
+		"year":    starlark.MakeInt(t.Year()),
+		"month":   starlark.MakeInt(int(t.Month())),
+		"day":     starlark.MakeInt(t.Day()),
+		"weekday": starlark.MakeInt(int(t.Weekday())),
+		"hour":    starlark.MakeInt(t.Hour()),
+		"minute":  starlark.MakeInt(t.Minute()),
+		"second":  starlark.MakeInt(t.Second()),
+		"unix":    starlark.MakeInt64(t.Unix()),
+	}), nil
+}
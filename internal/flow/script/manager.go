@@ -0,0 +1,176 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/spf13/afero"
+)
+
+type Options struct {
+	Dir            string        `long:"dir" env:"DIR" default:"./scripts" description:"directory with *.star scripts"`
+	ReloadInterval time.Duration `long:"reload-interval" env:"RELOAD_INTERVAL" default:"2s" description:"interval of the scripts directory check"`
+}
+
+const extension = ".star"
+
+// Registry is the place where scripts are registered as flows
+type Registry interface {
+	Register(f flow.Flow) error
+	Unregister(name string)
+}
+
+// LightsFactory returns the lights controller for the flow
+type LightsFactory func(flow string) LightsController
+
+// Status is the state of the loaded script
+type Status struct {
+	Name     string
+	Path     string
+	LoadedAt time.Time
+	Err      string
+}
+
+type loaded struct {
+	script   *Script
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+}
+
+// Manager loads scripts from the directory, registers them as flows and reloads them on change
+type Manager struct {
+	fs       afero.Fs
+	clock    clock.Clock
+	opts     Options
+	registry Registry
+	lights   LightsFactory
+	mapping  [][]internal.Light
+	log      *slog.Logger
+
+	mu      sync.RWMutex
+	scripts map[string]*loaded
+}
+
+func NewManager(clk clock.Clock, fs afero.Fs, registry Registry, lights LightsFactory, mapping [][]internal.Light, opts Options) *Manager {
+	return &Manager{
+		fs:       fs,
+		clock:    clk,
+		opts:     opts,
+		registry: registry,
+		lights:   lights,
+		mapping:  mapping,
+		log:      slog.With("subsystem", "scripts"),
+		scripts:  make(map[string]*loaded),
+	}
+}
+
+// Load synchronizes registered scripts with the directory
+func (m *Manager) Load() error {
+	infos, err := afero.ReadDir(m.fs, m.opts.Dir)
+	if os.IsNotExist(err) {
+		infos = nil
+	} else if err != nil {
+		return fmt.Errorf("couldn't read scripts directory '%s': %w", m.opts.Dir, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := map[string]bool{}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), extension) {
+			continue
+		}
+
+		name := strings.TrimSuffix(info.Name(), extension)
+		seen[name] = true
+
+		l, ok := m.scripts[name]
+		if ok && l.modTime.Equal(info.ModTime()) && l.size == info.Size() {
+			continue
+		}
+
+		path := filepath.Join(m.opts.Dir, info.Name())
+		src, err := afero.ReadFile(m.fs, path)
+		if err != nil {
+			m.log.Error("couldn't read script", slog.String("path", path), slog.Any("err", err))
+			continue
+		}
+
+		if ok {
+			m.log.Info("reloading script", slog.String("path", path))
+			l.script.Reload(src)
+			l.modTime, l.size, l.loadedAt = info.ModTime(), info.Size(), m.clock.Now()
+			continue
+		}
+
+		m.log.Info("loading script", slog.String("path", path))
+		s := newScript(m.clock, name, path, src, m.lights(namePrefix+name), m.mapping)
+		err = m.registry.Register(s)
+		if err != nil {
+			m.log.Error("couldn't register script", slog.String("path", path), slog.Any("err", err))
+			continue
+		}
+		m.scripts[name] = &loaded{script: s, modTime: info.ModTime(), size: info.Size(), loadedAt: m.clock.Now()}
+	}
+
+	for name, l := range m.scripts {
+		if seen[name] {
+			continue
+		}
+		m.log.Info("unloading script", slog.String("path", l.script.path))
+		m.registry.Unregister(l.script.Name())
+		delete(m.scripts, name)
+	}
+
+	return nil
+}
+
+// Run reloads scripts until the context is done
+func (m *Manager) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-m.clock.After(m.opts.ReloadInterval):
+			err := m.Load()
+			if err != nil {
+				m.log.Error("couldn't load scripts", slog.Any("err", err))
+			}
+		}
+	}
+}
+
+// Status returns the state of the loaded scripts sorted by name
+func (m *Manager) Status() []Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]Status, 0, len(m.scripts))
+	for _, l := range m.scripts {
+		st := Status{
+			Name:     l.script.Name(),
+			Path:     l.script.path,
+			LoadedAt: l.loadedAt,
+		}
+		if err := l.script.Err(); err != nil {
+			st.Err = err.Error()
+		}
+		result = append(result, st)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result
+}
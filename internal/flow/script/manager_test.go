@@ -0,0 +1,93 @@
+package script
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRegistry struct {
+	mu    sync.Mutex
+	flows map[string]flow.Flow
+}
+
+func (r *fakeRegistry) Register(f flow.Flow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.flows[f.Name()] = f
+	return nil
+}
+
+func (r *fakeRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.flows, name)
+}
+
+func (r *fakeRegistry) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := []string{}
+	for name := range r.flows {
+		result = append(result, name)
+	}
+	return result
+}
+
+func TestManager_Run(t *testing.T) {
+	var (
+		start    = time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC)
+		clk      = clock.NewFake(start)
+		fs       = afero.NewMemMapFs()
+		registry = &fakeRegistry{flows: map[string]flow.Flow{}}
+		l        = lights.NewTestController([]uint8{0x22, 0x23})
+		factory  = func(string) LightsController { return l }
+	)
+	require.NoError(t, afero.WriteFile(fs, "scripts/broken.star", []byte("set("), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "scripts/notes.txt", []byte("not a script"), 0o644))
+
+	m := NewManager(clk, fs, registry, factory, testMapping, Options{Dir: "scripts", ReloadInterval: 2 * time.Second})
+	require.NoError(t, m.Load())
+	require.Equal(t, []string{"script:broken"}, registry.names())
+
+	status := m.Status()
+	require.Len(t, status, 1)
+	require.Equal(t, "scripts/broken.star", status[0].Path)
+	require.Equal(t, start, status[0].LoadedAt)
+	require.Contains(t, status[0].Err, "scripts/broken.star:1:5")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+
+	// reload waits for the next check
+	require.NoError(t, afero.WriteFile(fs, "scripts/broken.star", []byte("reset()"), 0o644))
+	clk.BlockUntil(1)
+	clk.AdvanceToNext()
+	clk.BlockUntil(1)
+
+	status = m.Status()
+	require.Len(t, status, 1)
+	require.Equal(t, start.Add(2*time.Second), status[0].LoadedAt)
+	require.Empty(t, status[0].Err)
+
+	// new scripts are registered, removed ones are unregistered
+	require.NoError(t, afero.WriteFile(fs, "scripts/chaser.star", []byte("reset()"), 0o644))
+	require.NoError(t, fs.Remove("scripts/broken.star"))
+	clk.AdvanceToNext()
+	clk.BlockUntil(1)
+	require.Equal(t, []string{"script:chaser"}, registry.names())
+
+	cancel()
+	require.NoError(t, <-done)
+}
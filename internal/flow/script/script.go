@@ -0,0 +1,245 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+type LightsController interface {
+	Set(addr internal.LightAddress, isON bool) error
+	IsOn(addr internal.LightAddress) (bool, error)
+	Reset() error
+}
+
+// namePrefix distinguishes script flows from the built-in ones
+const namePrefix = "script:"
+
+// maxSteps is the budget of the starlark steps between two waits of the script
+// Script spending it without sleep() or timers is considered stuck and is cancelled
+const maxSteps = 10_000_000
+
+var fileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+	Recursion:       true,
+}
+
+// Script is the flow backed by the starlark script
+// Script is executed from the top to the bottom, after that timers registered with after()/every() are executed.
+// Flow is finished when the script and all of its timers are done.
+// Runtime errors are kept until the script is reloaded
+type Script struct {
+	name    string
+	path    string
+	lights  LightsController
+	mapping [][]internal.Light
+	clock   clock.Clock
+	log     *slog.Logger
+	rand    *rand.Rand
+	done    chan struct{}
+	reload  chan struct{}
+
+	mu       sync.Mutex
+	src      []byte
+	isActive bool
+	seeded   bool
+	lastErr  error
+}
+
+func newScript(clk clock.Clock, name, path string, src []byte, l LightsController, mapping [][]internal.Light) *Script {
+	s := &Script{
+		name:    namePrefix + name,
+		path:    path,
+		lights:  l,
+		mapping: mapping,
+		clock:   clk,
+		log:     slog.With("flow", namePrefix+name),
+		rand:    rand.New(rand.NewSource(clk.Now().UnixNano())), //no-lint: gosec
+		done:    make(chan struct{}),
+		reload:  make(chan struct{}, 1),
+	}
+
+	s.setSource(src)
+
+	return s
+}
+
+func (s *Script) Name() string {
+	return s.name
+}
+
+// Err returns the last compilation or runtime error of the script
+func (s *Script) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastErr
+}
+
+func (s *Script) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastErr = err
+}
+
+// setSource compiles the source to report syntax errors early and replaces the script
+func (s *Script) setSource(src []byte) {
+	_, err := syntax.Parse(s.path, src, 0)
+
+	s.mu.Lock()
+	s.src = src
+	s.lastErr = err
+	s.mu.Unlock()
+
+	if err != nil {
+		s.log.Error("couldn't parse script", slog.String("path", s.path), slog.Any("err", err))
+	}
+}
+
+// Reload replaces the source of the script. Script in execution is restarted
+func (s *Script) Reload(src []byte) {
+	s.setSource(src)
+
+	select {
+	case s.reload <- struct{}{}:
+	default: // reload is already requested
+	}
+}
+
+// Seed keeps the lights as they are on the next start, the script continues from the current state
+func (s *Script) Seed(_ flow.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seeded = true
+}
+
+func (s *Script) Start(ctx context.Context) error {
+	s.log.Info("starting flow")
+	s.mu.Lock()
+	s.done = make(chan struct{})
+	s.isActive = true
+	done := s.done
+	seeded := s.seeded
+	s.seeded = false
+	s.mu.Unlock()
+
+	// drop reload requested before the start
+	select {
+	case <-s.reload:
+	default:
+	}
+
+	for {
+		if !seeded {
+			err := s.lights.Reset()
+			if err != nil {
+				return fmt.Errorf("couldn't reset lights: %w", err)
+			}
+		}
+		// restarts on reload begin from scratch
+		seeded = false
+
+		s.mu.Lock()
+		src := s.src
+		s.lastErr = nil
+		s.mu.Unlock()
+
+		runCtx, cancel := context.WithCancel(ctx)
+		result := make(chan error, 1)
+		go func() { result <- s.run(runCtx, src) }()
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			<-result
+			return nil
+		case <-done:
+			cancel()
+			<-result
+			return nil
+		case <-s.reload:
+			cancel()
+			<-result
+			s.log.Info("script is reloaded, restarting")
+			continue
+		case err := <-result:
+			cancel()
+			if err == nil {
+				s.log.Info("script is finished")
+				return nil
+			}
+
+			s.log.Error("script failed, waiting for reload", slog.Any("err", err))
+			s.setErr(err)
+		}
+
+		// wait for the fixed script
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case <-s.reload:
+			continue
+		}
+	}
+}
+
+func (s *Script) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isActive {
+		s.log.Info("stopping flow")
+		s.isActive = false
+		close(s.done)
+	}
+}
+
+// run executes the script and its timers
+func (s *Script) run(ctx context.Context, src []byte) error {
+	thread := &starlark.Thread{
+		Name: s.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			s.log.Info("script output", slog.String("msg", msg))
+		},
+	}
+
+	thread.SetMaxExecutionSteps(maxSteps)
+
+	stop := context.AfterFunc(ctx, func() { thread.Cancel("flow is stopped") })
+	defer stop()
+
+	rt := &runtime{ctx: ctx, script: s}
+
+	_, err := starlark.ExecFileOptions(fileOptions, thread, s.path, src, rt.predeclared())
+	if err == nil {
+		err = rt.runTimers(thread)
+	}
+
+	if ctx.Err() != nil {
+		// errors caused by the stop are expected
+		return nil
+	}
+
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+
+	return err
+}
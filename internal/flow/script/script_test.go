@@ -0,0 +1,250 @@
+package script
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	frontShort = internal.LightAddress{Board: 0x22, Pin: "A0"}
+	backLong   = internal.LightAddress{Board: 0x22, Pin: "A1"}
+	upperShort = internal.LightAddress{Board: 0x22, Pin: "B0"}
+	landing    = internal.LightAddress{Board: 0x23, Pin: "A0"}
+
+	testMapping = [][]internal.Light{
+		{
+			{Number: 1, Side: internal.SideFront, Kind: internal.LightTypeShortWindow, Addr: frontShort},
+			{Number: 1, Side: internal.SideBack, Kind: internal.LightTypeLongWindow, Addr: backLong},
+		},
+		{
+			{Number: 2, Side: internal.SideFront, Kind: internal.LightTypeShortWindow, Addr: upperShort},
+			{Kind: internal.LightTypeServiceNoManLand, Addr: landing},
+			{}, // placeholder without the pin
+		},
+	}
+)
+
+// litLights returns the lit lights of the test mapping
+func litLights(t *testing.T, l *lights.TestController) []internal.LightAddress {
+	result := []internal.LightAddress{}
+	for _, row := range testMapping {
+		for _, light := range row {
+			if light.Addr.Pin == "" {
+				continue
+			}
+			isOn, err := l.IsOn(light.Addr)
+			require.NoError(t, err)
+			if isOn {
+				result = append(result, light.Addr)
+			}
+		}
+	}
+	return result
+}
+
+func TestScript_run(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		lit     []internal.LightAddress
+		elapsed time.Duration
+		err     string
+	}{
+		{
+			name: "lights are selected by the properties",
+			src: `
+set(lights(floor=0), True)
+set(lights(flat=2, side="front", kind="short"), True)
+if len(lights()) != 4:
+    fail("got %d lights" % len(lights()))
+`,
+			lit: []internal.LightAddress{frontShort, backLong, upperShort},
+		},
+		{
+			name: "toggle and is_on",
+			src: `
+toggle(lights(kind="short"))
+toggle(lights(flat=2)[0])
+if is_on(lights(flat=2)[0]) or not is_on(lights(flat=1, side="front")[0]):
+    fail("toggle is broken")
+`,
+			lit: []internal.LightAddress{frontShort},
+		},
+		{
+			name: "reset switches off everything",
+			src: `
+set(lights(), True)
+reset()
+set(lights(kind="landing"), True)
+`,
+			lit: []internal.LightAddress{landing},
+		},
+		{
+			name: "sleep waits on the clock",
+			src: `
+start = now()
+sleep(90)
+sleep(0.5)
+if (start.hour, start.minute) != (18, 0) or now().unix - start.unix != 90:
+    fail("unexpected time")
+set(lights(kind="long"), True)
+`,
+			lit:     []internal.LightAddress{backLong},
+			elapsed: 90500 * time.Millisecond,
+		},
+		{
+			name: "timers are fired in the order of the deadlines",
+			src: `
+def first():
+    set(lights(flat=1), True)
+
+def second():
+    if not is_on(lights(flat=1)[0]):
+        fail("second timer is fired first")
+    set(lights(flat=1, kind="long"), False)
+
+after(2, second)
+after(1, first)
+`,
+			lit:     []internal.LightAddress{frontShort},
+			elapsed: 2 * time.Second,
+		},
+		{
+			name: "repeated timer",
+			src: `
+start = now().unix
+
+def tick():
+    toggle(lights(kind="landing"))
+    if now().unix - start == 3:
+        fail("stopped after 3 ticks")
+
+every(1, tick)
+`,
+			lit:     []internal.LightAddress{landing},
+			elapsed: 3 * time.Second,
+			err:     "stopped after 3 ticks",
+		},
+		{
+			name: "random values",
+			src: `
+r = random()
+if r < 0 or r >= 1 or randint(3, 3) != 3:
+    fail("out of range")
+set(choice([lights(flat=2)]), True)
+`,
+			lit: []internal.LightAddress{upperShort},
+		},
+		{
+			name: "runtime error stops the script",
+			src: `
+set(lights(flat=2), True)
+set(1, True)
+set(lights(flat=1), True)
+`,
+			lit: []internal.LightAddress{upperShort},
+			err: "set: got int, want light or list of lights",
+		},
+		{
+			name: "empty range",
+			src:  `randint(2, 1)`,
+			lit:  []internal.LightAddress{},
+			err:  "randint: empty range [2, 1]",
+		},
+		{
+			name: "empty choice",
+			src:  `choice([])`,
+			lit:  []internal.LightAddress{},
+			err:  "choice: empty sequence",
+		},
+		{
+			name: "negative interval",
+			src:  `every(-1, reset)`,
+			lit:  []internal.LightAddress{},
+			err:  "every: seconds must be positive",
+		},
+		{
+			name: "endless loop is cancelled",
+			src: `
+def spin():
+    while True:
+        pass
+
+spin()
+`,
+			lit: []internal.LightAddress{},
+			err: "too many steps",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC)
+			clk := clock.NewFake(start)
+			l := lights.NewTestController([]uint8{0x22, 0x23})
+			s := newScript(clk, "test", "test.star", []byte(tt.src), l, testMapping)
+			require.NoError(t, s.Err())
+
+			result := make(chan error, 1)
+			go func() { result <- s.run(context.Background(), []byte(tt.src)) }()
+
+			var err error
+		wait:
+			for {
+				select {
+				case err = <-result:
+					break wait
+				case <-time.After(time.Millisecond):
+					clk.AdvanceToNext()
+				}
+			}
+
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+			require.Equal(t, tt.lit, litLights(t, l))
+			require.Equal(t, tt.elapsed, clk.Now().Sub(start))
+		})
+	}
+}
+
+func TestScript_reload(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC))
+	l := lights.NewTestController([]uint8{0x22, 0x23})
+
+	s := newScript(clk, "test", "test.star", []byte("set(lights(flat=1) True)"), l, testMapping)
+	require.Equal(t, "script:test", s.Name())
+	require.ErrorContains(t, s.Err(), "test.star:1:24: got identifier, want ','")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Start(ctx) }()
+	require.Eventually(t, func() bool { return s.Err() != nil }, time.Second, time.Millisecond)
+
+	// the fixed script is started again
+	s.Reload([]byte("set(lights(flat=1), True)\nsleep(60)\nreset()"))
+	require.Eventually(t, func() bool {
+		return len(litLights(t, l)) == 2
+	}, time.Second, time.Millisecond)
+	require.NoError(t, s.Err())
+
+	// the script in execution is restarted from scratch
+	s.Reload([]byte("set(lights(kind=\"landing\"), True)\nsleep(60)"))
+	require.Eventually(t, func() bool {
+		lit := litLights(t, l)
+		return len(lit) == 1 && lit[0] == landing
+	}, time.Second, time.Millisecond)
+
+	s.Stop()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,189 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+// Transition is the way lights change from one flow to another
+type Transition string
+
+const (
+	// TransitionNone skips the seeding, so the next flow resets the lights on its start
+	TransitionNone Transition = "none"
+	// TransitionKeep keeps the lights as they are, the next flow continues from the current state. It is the default
+	TransitionKeep Transition = "keep"
+	// TransitionWipe switches off the lights floor by floor from the top
+	TransitionWipe Transition = "wipe"
+	// TransitionDissolve switches off the lights one by one in the random order
+	TransitionDissolve Transition = "dissolve"
+	// TransitionCrossfade is the alias of dissolve, lights can't be dimmed to fade them out
+	TransitionCrossfade Transition = "crossfade"
+)
+
+const (
+	// MaxTransitionDuration limits the time of the transition, flows are switched under the lock
+	MaxTransitionDuration = 10 * time.Second
+
+	paramTransition         = "transition"
+	paramTransitionDuration = "transition-duration"
+)
+
+// Options are the defaults of the transitions between flows
+// Both could be overridden on the selection by the 'transition' and 'transition-duration' params
+type Options struct {
+	Transition         string        `long:"transition" env:"TRANSITION" default:"keep" description:"transition between flows: none, keep, wipe, dissolve, crossfade (the same as dissolve)"`
+	TransitionDuration time.Duration `long:"transition-duration" env:"TRANSITION_DURATION" default:"1s" description:"duration of the transition between flows"`
+}
+
+// State is the state of the lights
+type State map[internal.LightAddress]bool
+
+// Seeded is implemented by the flows which could continue from the current state of the lights
+// State is passed before the start on every transition except none. Such flows don't switch off their lights on the start
+type Seeded interface {
+	Seed(state State)
+}
+
+// ParseTransition validates the name of the transition
+func ParseTransition(raw string) (Transition, error) {
+	t := Transition(strings.TrimSpace(raw))
+	switch t {
+	case TransitionNone, TransitionKeep, TransitionWipe, TransitionDissolve, TransitionCrossfade:
+		return t, nil
+	case "":
+		return TransitionKeep, nil
+	}
+
+	return "", fmt.Errorf("unknown transition '%s'", raw)
+}
+
+// transitionFrom takes transition params out of the flow params. Empty values mean the defaults
+func (c *Controller) transitionFrom(params Params) (Transition, time.Duration, Params, error) {
+	t, d := c.transition, c.transitionDuration
+
+	rest := Params{}
+	for k, v := range params {
+		switch k {
+		case paramTransition:
+			if v == "" {
+				continue
+			}
+			var err error
+			t, err = ParseTransition(v)
+			if err != nil {
+				return "", 0, nil, err
+			}
+		case paramTransitionDuration:
+			if v == "" {
+				continue
+			}
+			var err error
+			d, err = time.ParseDuration(v)
+			if err != nil {
+				return "", 0, nil, fmt.Errorf("couldn't parse transition duration: %w", err)
+			}
+		default:
+			rest[k] = v
+		}
+	}
+
+	if d < 0 || d > MaxTransitionDuration {
+		return "", 0, nil, fmt.Errorf("transition duration must be in [0, %s], got %s", MaxTransitionDuration, d)
+	}
+
+	return t, d, rest, nil
+}
+
+// withoutTransition returns the flow params without the transition ones
+func withoutTransition(params Params) Params {
+	if params == nil {
+		return nil
+	}
+
+	result := Params{}
+	for k, v := range params {
+		if k != paramTransition && k != paramTransitionDuration {
+			result[k] = v
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// applyTransition changes the lights of the flow before its start and passes the resulting state to the flow
+func (c *Controller) applyTransition(ctx context.Context, f Flow, t Transition, d time.Duration) {
+	lights := c.arbiter.For(f.Name())
+	addrs := c.arbiter.Owned(f.Name())
+
+	// lights are switched off by batches, only the lights which are on are taken into account
+	// so the transition doesn't pause on the dark parts
+	isOn := func(addr internal.LightAddress) bool {
+		on, err := lights.IsOn(addr)
+		return err == nil && on
+	}
+
+	batches := [][]internal.LightAddress{}
+	switch t {
+	case TransitionNone:
+		return
+	case TransitionWipe:
+		for _, floor := range c.arbiter.floorsTopDown(addrs) {
+			floor = slices.DeleteFunc(floor, func(addr internal.LightAddress) bool { return !isOn(addr) })
+			if len(floor) > 0 {
+				batches = append(batches, floor)
+			}
+		}
+	case TransitionDissolve, TransitionCrossfade:
+		order := slices.Clone(addrs)
+		// the source is shared by the nested flows which are switched without the switching lock
+		c.mu.Lock()
+		c.rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		c.mu.Unlock()
+		for _, addr := range order {
+			if isOn(addr) {
+				batches = append(batches, []internal.LightAddress{addr})
+			}
+		}
+	}
+
+	var step time.Duration
+	if len(batches) > 0 {
+		step = d / time.Duration(len(batches))
+	}
+
+	for _, batch := range batches {
+		for _, addr := range batch {
+			err := lights.Set(addr, false)
+			if err != nil {
+				slog.Error("couldn't switch off light on the transition", slog.Any("addr", addr), slog.Any("err", err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(step):
+		}
+	}
+
+	seeded, ok := f.(Seeded)
+	if !ok {
+		return
+	}
+
+	state := State{}
+	for _, addr := range addrs {
+		state[addr] = isOn(addr)
+	}
+	seeded.Seed(state)
+}
@@ -0,0 +1,172 @@
+package flow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/stretchr/testify/require"
+)
+
+type seededFlow struct {
+	name    string
+	seeds   chan State
+	started chan struct{}
+}
+
+func (f *seededFlow) Start(ctx context.Context) error {
+	f.started <- struct{}{}
+	<-ctx.Done()
+	return nil
+}
+
+func (f *seededFlow) Stop()            {}
+func (f *seededFlow) Name() string     { return f.name }
+func (f *seededFlow) Seed(state State) { f.seeds <- state }
+
+func TestController_AssignFlowTransition(t *testing.T) {
+	var (
+		a1 = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		a2 = internal.LightAddress{Board: 0x20, Pin: "A1"}
+		b1 = internal.LightAddress{Board: 0x21, Pin: "A0"}
+	)
+	mapping := [][]internal.Light{
+		{{Number: 1, Addr: a1}, {Number: 1, Addr: a2}},
+		{{Number: 2, Addr: b1}},
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		params  Params
+		want    State
+		seeded  bool
+		elapsed time.Duration
+	}{
+		{name: "default", want: State{a1: true, a2: false, b1: true}, seeded: true},
+		{name: "empty param", opts: Options{Transition: "wipe"}, params: Params{"transition": "", "transition-duration": ""}, want: State{a1: false, a2: false, b1: false}, seeded: true},
+		{name: "none", opts: Options{Transition: "keep"}, params: Params{"transition": "none"}, want: State{a1: true, a2: false, b1: true}},
+		{name: "keep", opts: Options{Transition: "none"}, params: Params{"transition": "keep"}, want: State{a1: true, a2: false, b1: true}, seeded: true},
+		{name: "wipe", params: Params{"transition": "wipe", "transition-duration": "2s"}, want: State{a1: false, a2: false, b1: false}, seeded: true, elapsed: 2 * time.Second},
+		{name: "dissolve", params: Params{"transition": "dissolve", "transition-duration": "2s"}, want: State{a1: false, a2: false, b1: false}, seeded: true, elapsed: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hw := lights.NewTestController([]uint8{0x20, 0x21})
+			require.NoError(t, hw.Set(a1, true))
+			require.NoError(t, hw.Set(b1, true))
+
+			start := time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC)
+			clk := clock.NewFake(start)
+			f := &seededFlow{name: "next", seeds: make(chan State, 1), started: make(chan struct{}, 1)}
+			c, err := NewController(clk, NewArbiter(hw, mapping), tt.opts, f)
+			require.NoError(t, err)
+			go func() {
+				for range c.SubscribeToErrors() {
+				}
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			result := make(chan error, 1)
+			go func() { result <- c.AssignFlow(ctx, "next", GroupAll, tt.params) }()
+
+			// steps of the transition wait on the clock
+		wait:
+			for {
+				select {
+				case err = <-result:
+					break wait
+				case <-time.After(time.Millisecond):
+					clk.AdvanceToNext()
+				}
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.elapsed, clk.Now().Sub(start))
+
+			<-f.started
+			if tt.seeded {
+				require.Equal(t, tt.want, <-f.seeds)
+			} else {
+				require.Empty(t, f.seeds)
+			}
+			requireState(t, hw, tt.want)
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		c, err := NewController(clock.Real(), NewArbiter(lights.NewTestController(nil), mapping), Options{}, &seededFlow{name: "next"})
+		require.NoError(t, err)
+		err = c.AssignFlow(context.Background(), "next", GroupAll, Params{"transition": "fade"})
+		require.ErrorContains(t, err, "unknown transition")
+	})
+
+	t.Run("invalid default", func(t *testing.T) {
+		_, err := NewController(clock.Real(), NewArbiter(lights.NewTestController(nil), mapping), Options{Transition: "fade"})
+		require.Error(t, err)
+	})
+}
+
+func TestArbiter_floorsTopDown(t *testing.T) {
+	var (
+		a1 = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		b1 = internal.LightAddress{Board: 0x21, Pin: "A0"}
+		b2 = internal.LightAddress{Board: 0x21, Pin: "A1"}
+	)
+	arb := NewArbiter(lights.NewTestController(nil), [][]internal.Light{
+		{{Number: 1, Addr: a1}},
+		{{Number: 2, Addr: b1}, {Number: 2, Addr: b2}},
+	})
+
+	require.Equal(t, [][]internal.LightAddress{{b1}, {a1}}, arb.floorsTopDown([]internal.LightAddress{a1, b1}))
+}
+
+func TestController_dissolveOrder(t *testing.T) {
+	addrs := []internal.LightAddress{}
+	row := []internal.Light{}
+	for _, pin := range []string{"A0", "A1", "A2", "A3", "A4", "A5"} {
+		addr := internal.LightAddress{Board: 0x20, Pin: pin}
+		addrs = append(addrs, addr)
+		row = append(row, internal.Light{Number: 1, Addr: addr})
+	}
+	mapping := [][]internal.Light{row}
+
+	// dissolve returns the order the lights are switched off in
+	dissolve := func() []internal.LightAddress {
+		hw := lights.NewTestController([]uint8{0x20})
+		for _, addr := range addrs {
+			require.NoError(t, hw.Set(addr, true))
+		}
+		changes := make(chan internal.PinState, len(addrs))
+		hw.Subscribe(changes)
+
+		f := &seededFlow{name: "next", seeds: make(chan State, 1), started: make(chan struct{}, 1)}
+		clk := clock.NewFake(time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC))
+		c, err := NewController(clk, NewArbiter(hw, mapping), Options{Transition: "dissolve"}, f)
+		require.NoError(t, err)
+		go func() {
+			for range c.SubscribeToErrors() {
+			}
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, c.AssignFlow(ctx, "next", GroupAll, nil))
+		<-f.started
+
+		result := []internal.LightAddress{}
+		for range addrs {
+			result = append(result, (<-changes).Addr)
+		}
+		return result
+	}
+
+	// the order depends only on the clock
+	order := dissolve()
+	require.ElementsMatch(t, addrs, order)
+	require.Equal(t, order, dissolve())
+}
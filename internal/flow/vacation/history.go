@@ -0,0 +1,134 @@
+package vacation
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/journal"
+)
+
+// history is the recorded timeline of the lights with the jittered switches
+type history struct {
+	lights map[internal.LightAddress][]journal.Period
+}
+
+// newHistory joins the observed periods of the same state and shifts every switch by up to the jitter
+// The start and the end of the observation aren't shifted, so the gaps stay where they were
+func newHistory(r *rand.Rand, periods map[internal.LightAddress][]journal.Period, jitter time.Duration) *history {
+	h := &history{lights: map[internal.LightAddress][]journal.Period{}}
+
+	addrs := make([]internal.LightAddress, 0, len(periods))
+	for addr := range periods {
+		addrs = append(addrs, addr)
+	}
+	// the same seed gives the same jitter
+	slices.SortFunc(addrs, func(a, b internal.LightAddress) int {
+		if a.Board != b.Board {
+			return int(a.Board) - int(b.Board)
+		}
+		return strings.Compare(a.Pin, b.Pin)
+	})
+
+	for _, addr := range addrs {
+		joined := []journal.Period{}
+		for _, p := range periods[addr] {
+			if !p.From.Before(p.To) {
+				continue
+			}
+			if n := len(joined); n > 0 && joined[n-1].To.Equal(p.From) && joined[n-1].IsOn == p.IsOn {
+				joined[n-1].To = p.To
+				continue
+			}
+			joined = append(joined, p)
+		}
+
+		for i := 1; i < len(joined) && jitter > 0; i++ {
+			prev, cur := &joined[i-1], &joined[i]
+			if !prev.To.Equal(cur.From) {
+				continue
+			}
+			at := cur.From.Add(time.Duration(r.Int63n(int64(2*jitter))) - jitter)
+			if at.Before(prev.From) {
+				at = prev.From
+			}
+			if at.After(cur.To) {
+				at = cur.To
+			}
+			prev.To, cur.From = at, at
+		}
+
+		if len(joined) > 0 {
+			h.lights[addr] = joined
+		}
+	}
+
+	return h
+}
+
+// covered reports whether any light was observed at the moment
+func (h *history) covered(at time.Time) bool {
+	for _, periods := range h.lights {
+		if _, ok := find(periods, at); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// state returns the state of the light at the moment, the lights which weren't observed are off
+func (h *history) state(addr internal.LightAddress, at time.Time) bool {
+	p, ok := find(h.lights[addr], at)
+	return ok && p.IsOn
+}
+
+// next returns the first moment after the given one something changes in the history, false if nothing does
+func (h *history) next(at time.Time) (time.Time, bool) {
+	var (
+		result time.Time
+		found  bool
+	)
+	for _, periods := range h.lights {
+		i, _ := slices.BinarySearchFunc(periods, at, func(p journal.Period, t time.Time) int { return p.To.Compare(t) })
+		for ; i < len(periods); i++ {
+			b := periods[i].To
+			if periods[i].From.After(at) {
+				b = periods[i].From
+			}
+			if !b.After(at) {
+				continue
+			}
+			if !found || b.Before(result) {
+				result, found = b, true
+			}
+			break
+		}
+	}
+	return result, found
+}
+
+// find returns the period containing the moment, the periods are sorted and don't overlap
+func find(periods []journal.Period, at time.Time) (journal.Period, bool) {
+	i, _ := slices.BinarySearchFunc(periods, at, func(p journal.Period, t time.Time) int { return p.To.Compare(t) })
+	for ; i < len(periods); i++ {
+		p := periods[i]
+		if p.From.After(at) {
+			return journal.Period{}, false
+		}
+		if p.To.After(at) {
+			return p, true
+		}
+	}
+	return journal.Period{}, false
+}
+
+// addrs returns the lights of the history
+func (h *history) addrs() []internal.LightAddress {
+	result := make([]internal.LightAddress, 0, len(h.lights))
+	for addr := range h.lights {
+		result = append(result, addr)
+	}
+	return result
+}
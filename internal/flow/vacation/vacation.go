@@ -0,0 +1,229 @@
+package vacation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/journal"
+	"github.com/spf13/afero"
+)
+
+type Options struct {
+	Journal string        `long:"journal" env:"JOURNAL" default:"./journal.jsonl" description:"journal of the light changes to replay"`
+	Flows   []string      `long:"flows" env:"FLOWS" env-delim:"," default:"manual" description:"modes whose recorded lights are replayed"`
+	Weeks   int           `long:"weeks" env:"WEEKS" default:"4" description:"weeks to look back for the recorded moment, the live mode runs when none of them is recorded"`
+	Jitter  time.Duration `long:"jitter" env:"JITTER" default:"10m" description:"random shift of every recorded switch"`
+}
+
+const (
+	// FlowName is the name of the flow in the registry
+	FlowName = "vacation"
+)
+
+type LightsController interface {
+	Set(addr internal.LightAddress, isON bool) error
+	Reset() error
+}
+
+// Vacation repeats what the lights did at the same weekday and time a week ago
+// The moments missing in the history are played by the fallback flow
+type Vacation struct {
+	lights   LightsController
+	fs       afero.Fs
+	clock    clock.Clock
+	fallback flow.Flow
+	base     Options
+	opts     Options
+	done     chan struct{}
+	log      *slog.Logger
+
+	mu       sync.Mutex
+	isActive bool
+}
+
+func New(clk clock.Clock, fs afero.Fs, l LightsController, fallback flow.Flow, opts Options) *Vacation {
+	return &Vacation{
+		lights:   l,
+		fs:       fs,
+		clock:    clk,
+		fallback: fallback,
+		base:     opts,
+		opts:     opts,
+		done:     make(chan struct{}),
+		log:      slog.With("flow", FlowName),
+	}
+}
+
+func (v *Vacation) Name() string {
+	return FlowName
+}
+
+// Configure applies params on top of the options the flow was created with
+func (v *Vacation) Configure(p flow.Params) error {
+	opts := v.base
+	err := flow.ApplyParams(&opts, p)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.opts = opts
+
+	return nil
+}
+
+func (v *Vacation) Start(ctx context.Context) error {
+	v.mu.Lock()
+	v.done = make(chan struct{})
+	v.isActive = true
+	opts := v.opts
+	done := v.done
+	v.mu.Unlock()
+
+	if opts.Weeks < 1 {
+		return fmt.Errorf("weeks must be positive, got %d", opts.Weeks)
+	}
+	if opts.Jitter < 0 {
+		return fmt.Errorf("jitter must not be negative, got %s", opts.Jitter)
+	}
+	v.log.Info("starting flow", slog.Any("opts", opts))
+
+	// the state of the light is set by its last entry, it could be written long before the replayed weeks
+	entries, err := journal.Read(v.fs, opts.Journal, time.Time{})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err != nil {
+		v.log.Warn("there is no journal, the live mode is played", slog.String("path", opts.Journal))
+	}
+
+	r := rand.New(rand.NewSource(v.clock.Now().UnixNano())) //no-lint: gosec
+	h := newHistory(r, journal.Periods(entries, opts.Flows), opts.Jitter)
+
+	return v.run(ctx, done, h, opts.Weeks)
+}
+
+func (v *Vacation) Stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.isActive {
+		v.log.Info("stopping flow")
+		v.isActive = false
+		close(v.done)
+	}
+}
+
+// run plays the most recent recorded week of the moment or the fallback when none is recorded
+func (v *Vacation) run(ctx context.Context, done <-chan struct{}, h *history, weeks int) error {
+	var (
+		played   = -1 // the week being played, 0 is the fallback
+		lit      = map[internal.LightAddress]bool{}
+		fbCancel context.CancelFunc
+		fbErr    chan error
+	)
+	stopFallback := func() error {
+		if fbCancel == nil {
+			return nil
+		}
+		fbCancel()
+		v.fallback.Stop()
+		err := <-fbErr
+		fbCancel, fbErr = nil, nil
+		return err
+	}
+	defer func() { _ = stopFallback() }()
+
+	for {
+		now := v.clock.Now()
+		week := 0
+		for w := 1; w <= weeks; w++ {
+			if h.covered(now.AddDate(0, 0, -7*w)) {
+				week = w
+				break
+			}
+		}
+
+		switch {
+		case week == 0 && played != 0:
+			v.log.Info("the moment isn't recorded, playing the live mode", slog.Int("weeks", weeks))
+			fbCtx, cancel := context.WithCancel(ctx)
+			fbCancel, fbErr = cancel, make(chan error, 1)
+			go func() { fbErr <- v.fallback.Start(fbCtx) }()
+		case week != 0 && played != week:
+			v.log.Info("replaying the recorded week", slog.Int("weeks_ago", week))
+			err := stopFallback()
+			if err != nil {
+				return fmt.Errorf("fallback failed: %w", err)
+			}
+			// the lights of the fallback are switched off and the recorded ones are set again
+			err = v.lights.Reset()
+			if err != nil {
+				return fmt.Errorf("couldn't switch off lights: %w", err)
+			}
+			lit = map[internal.LightAddress]bool{}
+		}
+		played = week
+
+		if week != 0 {
+			at := now.AddDate(0, 0, -7*week)
+			for _, addr := range h.addrs() {
+				isOn := h.state(addr, at)
+				if lit[addr] == isOn {
+					continue
+				}
+				err := v.lights.Set(addr, isOn)
+				if err != nil {
+					return fmt.Errorf("couldn't set light '%v': %w", addr, err)
+				}
+				lit[addr] = isOn
+			}
+		}
+
+		// every recorded week could start or end being played at its next change
+		var wake <-chan time.Time
+		next, found := time.Time{}, false
+		for w := 1; w <= weeks; w++ {
+			at, ok := h.next(now.AddDate(0, 0, -7*w))
+			if !ok {
+				continue
+			}
+			at = at.AddDate(0, 0, 7*w)
+			if !found || at.Before(next) {
+				next, found = at, true
+			}
+		}
+		if found {
+			d := next.Sub(now)
+			if d <= 0 {
+				// the shifted moment could be missing on the change of the daylight saving time
+				d = time.Second
+			}
+			wake = v.clock.After(d)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case err := <-fbErr:
+			fbCancel, fbErr = nil, nil
+			if err != nil {
+				return fmt.Errorf("fallback failed: %w", err)
+			}
+			return nil
+		case <-wake:
+		}
+	}
+}
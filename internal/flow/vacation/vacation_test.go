@@ -0,0 +1,133 @@
+package vacation
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/clock"
+	"github.com/mbobakov/khrushchevka/internal/journal"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFallback struct {
+	started chan struct{}
+	stopped chan struct{}
+}
+
+func (f *fakeFallback) Start(ctx context.Context) error {
+	f.started <- struct{}{}
+	<-ctx.Done()
+	f.stopped <- struct{}{}
+	return nil
+}
+func (f *fakeFallback) Stop()        {}
+func (f *fakeFallback) Name() string { return "fallback" }
+
+func TestVacation(t *testing.T) {
+	var (
+		a1  = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		a2  = internal.LightAddress{Board: 0x20, Pin: "A1"}
+		fs  = afero.NewMemMapFs()
+		clk = clock.NewFake(time.Date(2024, 1, 12, 20, 0, 0, 0, time.UTC))
+		l   = lights.NewTestController([]uint8{0x20})
+		fb  = &fakeFallback{started: make(chan struct{}, 1), stopped: make(chan struct{}, 1)}
+	)
+	// a week ago: a1 is switched on for 20 minutes, the server is down from 21:00 till 21:30
+	require.NoError(t, afero.WriteFile(fs, "journal.jsonl", []byte(strings.Join([]string{
+		`{"at":"2024-01-05T19:00:00Z","board":32,"pin":"A0","flow":"manual","start":true}`,
+		`{"at":"2024-01-05T19:00:00Z","board":32,"pin":"A1","on":true,"flow":"manual","start":true}`,
+		`{"at":"2024-01-05T20:10:00Z","board":32,"pin":"A0","on":true,"flow":"manual"}`,
+		`{"at":"2024-01-05T20:30:00Z","board":32,"pin":"A0","flow":"manual"}`,
+		`{"at":"2024-01-05T21:00:00Z","stop":true}`,
+		`{"at":"2024-01-05T21:30:00Z","board":32,"pin":"A0","on":true,"flow":"manual","start":true}`,
+		`{"at":"2024-01-05T22:00:00Z","stop":true}`,
+	}, "\n")), 0644))
+
+	v := New(clk, fs, l, fb, Options{Journal: "journal.jsonl", Flows: []string{"manual"}, Weeks: 1})
+	done := make(chan error)
+	go func() { done <- v.Start(context.Background()) }()
+
+	isOn := func(addr internal.LightAddress) bool {
+		on, err := l.IsOn(addr)
+		require.NoError(t, err)
+		return on
+	}
+	step := func() {
+		require.True(t, clk.AdvanceToNext())
+		clk.BlockUntil(1)
+	}
+
+	clk.BlockUntil(1)
+	require.False(t, isOn(a1))
+	require.True(t, isOn(a2))
+
+	step()
+	require.Equal(t, time.Date(2024, 1, 12, 20, 10, 0, 0, time.UTC), clk.Now())
+	require.True(t, isOn(a1))
+
+	step()
+	require.False(t, isOn(a1))
+
+	step()
+	require.Equal(t, time.Date(2024, 1, 12, 21, 0, 0, 0, time.UTC), clk.Now())
+	<-fb.started
+
+	step()
+	<-fb.stopped
+	require.True(t, isOn(a1))
+	require.False(t, isOn(a2), "lights of the fallback are switched off")
+
+	v.Stop()
+	require.NoError(t, <-done)
+}
+
+func Test_newHistory(t *testing.T) {
+	var (
+		addr = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		at   = func(m int) time.Time { return time.Date(2024, 1, 5, 20, m, 0, 0, time.UTC) }
+	)
+	periods := map[internal.LightAddress][]journal.Period{addr: {
+		{From: at(0), To: at(10), IsOn: false},
+		{From: at(10), To: at(12), IsOn: true},
+		{From: at(12), To: at(30), IsOn: true},
+		{From: at(30), To: at(50), IsOn: false},
+		{From: at(55), To: at(59), IsOn: true},
+	}}
+
+	h := newHistory(rand.New(rand.NewSource(1)), periods, 0)
+	require.Equal(t, []journal.Period{
+		{From: at(0), To: at(10), IsOn: false},
+		{From: at(10), To: at(30), IsOn: true},
+		{From: at(30), To: at(50), IsOn: false},
+		{From: at(55), To: at(59), IsOn: true},
+	}, h.lights[addr])
+	require.True(t, h.covered(at(52).Add(-3*time.Minute)))
+	require.False(t, h.covered(at(52)))
+	next, ok := h.next(at(50))
+	require.True(t, ok)
+	require.Equal(t, at(55), next)
+	_, ok = h.next(at(59))
+	require.False(t, ok)
+
+	for seed := int64(0); seed < 20; seed++ {
+		h := newHistory(rand.New(rand.NewSource(seed)), periods, 5*time.Minute)
+		got := h.lights[addr]
+		require.Len(t, got, 4)
+		require.Equal(t, at(0), got[0].From, "the observation start isn't shifted")
+		require.Equal(t, at(50), got[2].To, "the observation end isn't shifted")
+		require.Equal(t, at(55), got[3].From)
+		for i, p := range got {
+			require.False(t, p.To.Before(p.From))
+			if i > 0 && i < 3 {
+				require.Equal(t, got[i-1].To, p.From)
+				require.LessOrEqual(t, (p.From.Sub(periods[addr][i*2-1].From)).Abs(), 5*time.Minute)
+			}
+		}
+	}
+}
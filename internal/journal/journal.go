@@ -0,0 +1,271 @@
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/spf13/afero"
+)
+
+type Options struct {
+	Path  string   `long:"path" env:"PATH" default:"./journal.jsonl" description:"path to the journal of the light changes, empty disables it"`
+	Flows []string `long:"flows" env:"FLOWS" env-delim:"," default:"manual" description:"modes whose light changes are written to the journal"`
+	// Buffer is the capacity of the subscription. Controller drops the changes when it is full, the lights are resynced after that
+	Buffer int `long:"buffer" env:"BUFFER" default:"256" description:"number of the light changes waiting for the write"`
+}
+
+// Entry is the line of the journal: the change of the light, its state on the start or the stop of the journal
+type Entry struct {
+	At    time.Time `json:"at"`
+	Board uint8     `json:"board,omitempty"`
+	Pin   string    `json:"pin,omitempty"`
+	IsOn  bool      `json:"on,omitempty"`
+	// Flow is the owner of the light, the light is observed while it is owned by the journaled flows
+	Flow string `json:"flow,omitempty"`
+	// Start marks the states of the lights written on the start, the lights weren't observed before it
+	Start bool `json:"start,omitempty"`
+	// Stop marks the end of the journal, nothing is observed till the next start
+	Stop bool `json:"stop,omitempty"`
+}
+
+// Addr returns the address of the light of the entry
+func (e Entry) Addr() internal.LightAddress {
+	return internal.LightAddress{Board: e.Board, Pin: e.Pin}
+}
+
+type Flows interface {
+	Owner(addr internal.LightAddress) string
+}
+
+// Journal appends the changes of the lights owned by the selected flows to the file
+// The history is used to learn the behaviour of the residents
+type Journal struct {
+	fs      afero.Fs
+	opts    Options
+	flows   Flows
+	lights  lights.ControllerI
+	mapping [][]internal.Light
+	log     *slog.Logger
+
+	// observed are the lights whose last entry is written for the journaled flow
+	observed map[internal.LightAddress]bool
+	// states are the last known states of the lights
+	states map[internal.LightAddress]bool
+}
+
+func New(fs afero.Fs, flows Flows, l lights.ControllerI, mapping [][]internal.Light, opts Options) *Journal {
+	return &Journal{
+		fs:       fs,
+		opts:     opts,
+		flows:    flows,
+		lights:   l,
+		mapping:  mapping,
+		log:      slog.With("subsystem", "journal"),
+		observed: map[internal.LightAddress]bool{},
+		states:   map[internal.LightAddress]bool{},
+	}
+}
+
+// Run writes the changes of the lights until the context is done
+func (j *Journal) Run(ctx context.Context) error {
+	if j.opts.Path == "" {
+		return nil
+	}
+
+	changes := make(chan internal.PinState, j.opts.Buffer)
+	j.lights.Subscribe(changes)
+
+	f, err := j.fs.OpenFile(j.opts.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't open journal '%s': %w", j.opts.Path, err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	write := func(e Entry) {
+		err := enc.Encode(e)
+		if err != nil {
+			j.log.Error("couldn't write journal entry", slog.Any("entry", e), slog.Any("err", err))
+		}
+	}
+
+	now := time.Now()
+	for _, addr := range j.addrs() {
+		isOn, err := j.lights.IsOn(addr)
+		if err != nil {
+			return fmt.Errorf("couldn't get state of the light '%v': %w", addr, err)
+		}
+		e := j.entry(now, addr, isOn)
+		e.Start = true
+		write(e)
+	}
+
+	missed := false
+	for {
+		select {
+		case <-ctx.Done():
+			// changes made before the stop are written
+			for len(changes) > 0 {
+				pin := <-changes
+				j.change(write, time.Now(), pin.Addr, pin.IsOn)
+			}
+			write(Entry{At: time.Now(), Stop: true})
+			return nil
+		case pin := <-changes:
+			// the buffer was full, so the next changes could be dropped
+			missed = missed || (cap(changes) > 0 && len(changes) == cap(changes)-1)
+			j.change(write, time.Now(), pin.Addr, pin.IsOn)
+			if missed && len(changes) == 0 {
+				missed = false
+				j.log.Warn("changes of the lights could be missed, resyncing")
+				err := j.resync(write)
+				if err != nil {
+					j.log.Error("couldn't resync lights", slog.Any("err", err))
+				}
+			}
+		}
+	}
+}
+
+// addrs returns the addresses of the lights of the flats
+func (j *Journal) addrs() []internal.LightAddress {
+	result := []internal.LightAddress{}
+	for _, row := range j.mapping {
+		for _, light := range row {
+			if light.Number == 0 || light.Addr.Pin == "" {
+				continue
+			}
+			result = append(result, light.Addr)
+		}
+	}
+	return result
+}
+
+// change writes the change of the light if it is observed
+func (j *Journal) change(write func(Entry), at time.Time, addr internal.LightAddress, isOn bool) {
+	j.states[addr] = isOn
+
+	owner := j.flows.Owner(addr)
+	if !slices.Contains(j.opts.Flows, owner) && !j.observed[addr] {
+		return // changes of the other flows are written only once to end the observation
+	}
+	write(j.entry(at, addr, isOn))
+}
+
+// resync writes the lights whose state differs from the last known one
+func (j *Journal) resync(write func(Entry)) error {
+	now := time.Now()
+	for _, addr := range j.addrs() {
+		isOn, err := j.lights.IsOn(addr)
+		if err != nil {
+			return fmt.Errorf("couldn't get state of the light '%v': %w", addr, err)
+		}
+		if known, ok := j.states[addr]; ok && known == isOn {
+			continue
+		}
+		j.change(write, now, addr, isOn)
+	}
+
+	return nil
+}
+
+func (j *Journal) entry(at time.Time, addr internal.LightAddress, isOn bool) Entry {
+	owner := j.flows.Owner(addr)
+	j.observed[addr] = slices.Contains(j.opts.Flows, owner)
+	j.states[addr] = isOn
+
+	return Entry{At: at, Board: addr.Board, Pin: addr.Pin, IsOn: isOn, Flow: owner}
+}
+
+// Read returns the entries of the journal written since the moment, broken lines are skipped
+func Read(fs afero.Fs, path string, since time.Time) ([]Entry, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open journal '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	result := []Entry{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		e := Entry{}
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			// the last line could be cut by the power loss
+			slog.Warn("broken journal line is skipped", slog.String("path", path), slog.Int("line", line), slog.Any("err", err))
+			continue
+		}
+		if e.At.Before(since) {
+			continue
+		}
+		result = append(result, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read journal '%s': %w", path, err)
+	}
+
+	return result, nil
+}
+
+// Period is the time the light was observed in the same state
+type Period struct {
+	From time.Time
+	To   time.Time
+	IsOn bool
+}
+
+// Periods returns the periods the lights were owned by the flows, the entries are sorted by time in place
+// The observation ends on the stop of the journal, on the restart and on the change by another flow
+func Periods(entries []Entry, flows []string) map[internal.LightAddress][]Period {
+	var (
+		result   = map[internal.LightAddress][]Period{}
+		observed = map[internal.LightAddress]Period{}
+		last     time.Time
+		started  time.Time
+	)
+	finish := func(addr internal.LightAddress, at time.Time) {
+		p, ok := observed[addr]
+		if !ok {
+			return
+		}
+		delete(observed, addr)
+		p.To = at
+		result[addr] = append(result[addr], p)
+	}
+	finishAll := func(at time.Time) {
+		for addr := range observed {
+			finish(addr, at)
+		}
+	}
+
+	slices.SortStableFunc(entries, func(a, b Entry) int { return a.At.Compare(b.At) })
+	for _, e := range entries {
+		switch {
+		case e.Stop:
+			finishAll(e.At)
+			continue
+		case e.Start && !e.At.Equal(started):
+			// the journal wasn't stopped, the server was down since the last entry
+			finishAll(last)
+			started = e.At
+		}
+		last = e.At
+
+		addr := e.Addr()
+		finish(addr, e.At)
+		if slices.Contains(flows, e.Flow) {
+			observed[addr] = Period{From: e.At, IsOn: e.IsOn}
+		}
+	}
+	finishAll(last)
+
+	return result
+}
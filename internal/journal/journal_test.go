@@ -0,0 +1,141 @@
+package journal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFlows struct {
+	mu    sync.Mutex
+	owner map[internal.LightAddress]string
+}
+
+func (f *fakeFlows) Owner(addr internal.LightAddress) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.owner[addr]
+}
+
+func (f *fakeFlows) set(addr internal.LightAddress, owner string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.owner[addr] = owner
+}
+
+func TestJournal(t *testing.T) {
+	var (
+		a1      = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		a2      = internal.LightAddress{Board: 0x20, Pin: "A1"}
+		mapping = [][]internal.Light{{{Number: 1, Addr: a1}, {Number: 2, Addr: a2}, {Addr: internal.LightAddress{Board: 0x20}}}}
+		flows   = &fakeFlows{owner: map[internal.LightAddress]string{a1: "manual", a2: "live"}}
+		fs      = afero.NewMemMapFs()
+		ctrl    = lights.NewTestController([]uint8{0x20})
+	)
+	require.NoError(t, afero.WriteFile(fs, "journal.jsonl", []byte("{\"at\":\"2024-01-05T20:00:00Z\",\"stop\":true}\n{broken\n"), 0644))
+
+	j := New(fs, flows, ctrl, mapping, Options{Path: "journal.jsonl", Flows: []string{"manual"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- j.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		entries, err := Read(fs, "journal.jsonl", time.Time{})
+		return err == nil && len(entries) == 3
+	}, time.Second, time.Millisecond, "start entries are written")
+
+	require.NoError(t, ctrl.Set(a1, true)) // manual
+	require.NoError(t, ctrl.Set(a2, true)) // live isn't journaled
+	flows.set(a2, "manual")
+	require.NoError(t, ctrl.Set(a2, false)) // manual takes over
+	flows.set(a1, "live")
+	require.NoError(t, ctrl.Set(a1, false)) // written once to end the observation
+	require.NoError(t, ctrl.Set(a1, true))
+	cancel()
+	require.NoError(t, <-done)
+
+	entries, err := Read(fs, "journal.jsonl", time.Time{})
+	require.NoError(t, err)
+	for i := range entries {
+		entries[i].At = time.Time{}
+	}
+	require.Equal(t, []Entry{
+		{Stop: true},
+		{Board: 0x20, Pin: "A0", Flow: "manual", Start: true},
+		{Board: 0x20, Pin: "A1", Flow: "live", Start: true},
+		{Board: 0x20, Pin: "A0", IsOn: true, Flow: "manual"},
+		{Board: 0x20, Pin: "A1", Flow: "manual"},
+		{Board: 0x20, Pin: "A0", Flow: "live"},
+		{Stop: true},
+	}, entries)
+
+	entries, err = Read(fs, "journal.jsonl", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.Len(t, entries, 6, "old entries are skipped")
+
+	_, err = Read(fs, "missing.jsonl", time.Time{})
+	require.Error(t, err)
+}
+
+// droppingController drops the notifications when the subscriber is behind, like the real controller
+type droppingController struct {
+	*lights.TestController
+	subscribers []chan<- internal.PinState
+}
+
+func (c *droppingController) Subscribe(ch chan<- internal.PinState) {
+	c.subscribers = append(c.subscribers, ch)
+}
+
+func (c *droppingController) Set(addr internal.LightAddress, isOn bool) error {
+	err := c.TestController.Set(addr, isOn)
+	for _, ch := range c.subscribers {
+		select {
+		case ch <- internal.PinState{Addr: addr, IsOn: isOn}:
+		default:
+		}
+	}
+	return err
+}
+
+func TestJournal_missedChanges(t *testing.T) {
+	var (
+		a1      = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		mapping = [][]internal.Light{{{Number: 1, Addr: a1}}}
+		flows   = &fakeFlows{owner: map[internal.LightAddress]string{a1: "manual"}}
+		fs      = afero.NewMemMapFs()
+		ctrl    = &droppingController{TestController: lights.NewTestController([]uint8{0x20})}
+	)
+
+	j := New(fs, flows, ctrl, mapping, Options{Path: "journal.jsonl", Flows: []string{"manual"}, Buffer: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- j.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		entries, err := Read(fs, "journal.jsonl", time.Time{})
+		return err == nil && len(entries) == 1
+	}, time.Second, time.Millisecond, "start entries are written")
+
+	// the journal is blocked, so the last change doesn't fit into the buffer
+	flows.mu.Lock()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, ctrl.Set(a1, true))
+	}
+	require.NoError(t, ctrl.Set(a1, false))
+	flows.mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		entries, err := Read(fs, "journal.jsonl", time.Time{})
+		return err == nil && len(entries) > 1 && !entries[len(entries)-1].IsOn
+	}, time.Second, time.Millisecond, "the missed change is resynced")
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,193 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed classic 5-fields cron expression: 'minute hour day-of-month month day-of-week'
+// Every field supports '*', lists 'a,b', ranges 'a-b' and steps '*/n', 'a-b/n'
+// Months and days of week could be set by the 3 letters english names ('jan', 'mon')
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// restricted fields are used for the classic day-of-month OR day-of-week matching
+	domRestricted, dowRestricted bool
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = cronField{min: 0, max: 59}
+	hourField   = cronField{min: 0, max: 23}
+	domField    = cronField{min: 1, max: 31}
+	monthField  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is an alias for sunday
+	dowField = cronField{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// maxCronLookahead limits the search of the next activation for the expressions which never fire (e.g. '0 0 31 2 *')
+const maxCronLookahead = 5 * 366 * 24 * time.Hour
+
+// ParseCron parses cron expression
+func ParseCron(expr string) (*Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' must have 5 fields, got %d", expr, len(fields))
+	}
+
+	var (
+		c   = &Cron{}
+		err error
+	)
+
+	c.minute, err = minuteField.parse(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse minute: %w", err)
+	}
+	c.hour, err = hourField.parse(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse hour: %w", err)
+	}
+	c.dom, err = domField.parse(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse day of month: %w", err)
+	}
+	c.month, err = monthField.parse(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse month: %w", err)
+	}
+	c.dow, err = dowField.parse(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse day of week: %w", err)
+	}
+
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	// fields are restricted unless they match every day, e.g. '*/1' is the same as '*'
+	c.domRestricted = c.dom != domField.all()
+	c.dowRestricted = c.dow|1<<7 != dowField.all()
+
+	return c, nil
+}
+
+// all returns the bits of every value of the field
+func (f cronField) all() uint64 {
+	var bits uint64
+	for v := f.min; v <= f.max; v++ {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func (f cronField) parse(raw string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(raw, ",") {
+		rng, stepRaw, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepRaw)
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", stepRaw)
+			}
+			step = s
+		}
+
+		from, to := f.min, f.max
+		if rng != "*" {
+			fromRaw, toRaw, isRange := strings.Cut(rng, "-")
+			v, err := f.value(fromRaw)
+			if err != nil {
+				return 0, err
+			}
+			from, to = v, v
+			if isRange {
+				to, err = f.value(toRaw)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				to = f.max
+			}
+		}
+
+		if from > to {
+			return 0, fmt.Errorf("invalid range '%s'", rng)
+		}
+
+		for i := from; i <= to; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+func (f cronField) value(raw string) (int, error) {
+	if v, ok := f.names[strings.ToLower(raw)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", raw)
+	}
+
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d is out of range [%d,%d]", v, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// Next returns the first activation strictly after t in the location of t
+// Zero time is returned when the expression never fires
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxCronLookahead)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+
+	return dom && dow
+}
@@ -0,0 +1,44 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCron_Next(t *testing.T) {
+	// 2024-01-05 is Friday
+	from := time.Date(2024, 1, 5, 16, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		expr    string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "every day", expr: "0 17 * * *", want: time.Date(2024, 1, 5, 17, 0, 0, 0, time.UTC)},
+		{name: "weekdays after midnight", expr: "0 1 * * 1-5", want: time.Date(2024, 1, 8, 1, 0, 0, 0, time.UTC)},
+		{name: "weekend by names", expr: "30 9 * * sat,sun", want: time.Date(2024, 1, 6, 9, 30, 0, 0, time.UTC)},
+		{name: "sunday as 7", expr: "0 0 * * 7", want: time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{name: "step", expr: "*/20 * * * *", want: time.Date(2024, 1, 5, 16, 40, 0, 0, time.UTC)},
+		{name: "day of month or day of week", expr: "0 12 10 * mon", want: time.Date(2024, 1, 8, 12, 0, 0, 0, time.UTC)},
+		{name: "every day of month by step", expr: "0 12 */1 * mon", want: time.Date(2024, 1, 8, 12, 0, 0, 0, time.UTC)},
+		{name: "every day of week by range", expr: "0 12 10 * 0-6", want: time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)},
+		{name: "new year", expr: "0 0 1 jan *", want: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "never", expr: "0 0 31 2 *", want: time.Time{}},
+		{name: "wrong fields count", expr: "0 17 * *", wantErr: true},
+		{name: "out of range", expr: "60 17 * * *", wantErr: true},
+		{name: "inverted range", expr: "0 17 * * 5-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			require.Equal(t, tt.wantErr, err != nil, "ParseCron() error = %v, wantErr %v", err, tt.wantErr)
+			if err != nil {
+				return
+			}
+
+			require.Equal(t, tt.want, c.Next(from))
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/spf13/afero"
+)
+
+type Options struct {
+	Path string `long:"path" env:"PATH" default:"./schedule.json" description:"path to the schedule rules file"`
+}
+
+// HolidayPolicy defines how the rule behaves on the holidays
+type HolidayPolicy string
+
+const (
+	// HolidayAny rule fires regardless of the holidays
+	HolidayAny HolidayPolicy = ""
+	// HolidaySkip rule doesn't fire on the holidays
+	HolidaySkip HolidayPolicy = "skip"
+	// HolidayOnly rule fires only on the holidays
+	HolidayOnly HolidayPolicy = "only"
+)
+
+const holidayLayout = "2006-01-02"
+
+// Rule selects the flow with params when the cron expression or the solar event fires
+type Rule struct {
+	Name string `json:"name"`
+	// Cron is the classic cron expression, e.g. '0 17 * * *'
+	Cron string `json:"cron,omitempty"`
+	// At is the solar event with an optional offset and days of week, e.g. 'civil_dusk' or 'sunset-30m mon-fri'
+	At       string        `json:"at,omitempty"`
+	Flow     string        `json:"flow"`
+	Params   flow.Params   `json:"params,omitempty"`
+	Holidays HolidayPolicy `json:"holidays,omitempty"`
+}
+
+// Config is the content of the schedule file
+type Config struct {
+	// Timezone is the IANA name of the timezone for the rules. Empty means local time
+	Timezone string `json:"timezone,omitempty"`
+	// Latitude and Longitude are used to calculate the solar events locally
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	// Holidays in the YYYY-MM-DD form
+	Holidays []string `json:"holidays,omitempty"`
+	Rules    []Rule   `json:"rules"`
+}
+
+// Transition is the planned selection of the flow
+type Transition struct {
+	At   time.Time
+	Rule Rule
+}
+
+type FlowSelector interface {
+	SelectFlowWith(ctx context.Context, name string, params flow.Params) error
+}
+
+// trigger returns the next activation strictly after the moment
+type trigger interface {
+	Next(t time.Time) time.Time
+}
+
+type compiled struct {
+	rule    Rule
+	trigger trigger
+}
+
+// Scheduler switches flows according to the rules
+type Scheduler struct {
+	flows FlowSelector
+	fs    afero.Fs
+	path  string
+	log   *slog.Logger
+
+	reload chan struct{}
+
+	mu             sync.RWMutex
+	cfg            Config
+	loc            *time.Location
+	holidays       map[string]bool
+	rules          []compiled
+	suspendedUntil time.Time
+	restoredAt     time.Time
+	// applied is the rule whose flow is in effect, nil after the manual selection
+	applied *Rule
+}
+
+// New creates the scheduler and loads the rules from the file if it exists
+func New(fs afero.Fs, flows FlowSelector, opts Options) (*Scheduler, error) {
+	s := &Scheduler{
+		flows:  flows,
+		fs:     fs,
+		path:   opts.Path,
+		log:    slog.With("subsystem", "schedule"),
+		reload: make(chan struct{}, 1),
+		loc:    time.Local,
+	}
+
+	buf, err := afero.ReadFile(fs, opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.log.Info("schedule file doesn't exist, starting with no rules", slog.String("path", opts.Path))
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read schedule file '%s': %w", opts.Path, err)
+	}
+
+	cfg := Config{}
+	err = json.Unmarshal(buf, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal schedule file '%s': %w", opts.Path, err)
+	}
+
+	err = s.apply(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule file '%s': %w", opts.Path, err)
+	}
+
+	return s, nil
+}
+
+// Config returns the current rules
+func (s *Scheduler) Config() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cfg
+}
+
+// Update validates, saves and activates the new rules
+func (s *Scheduler) Update(cfg Config) error {
+	err := s.apply(cfg)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal schedule: %w", err)
+	}
+
+	err = afero.WriteFile(s.fs, s.path, buf, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't write schedule file '%s': %w", s.path, err)
+	}
+
+	select {
+	case s.reload <- struct{}{}:
+	default: // reload is already requested
+	}
+
+	return nil
+}
+
+func (s *Scheduler) apply(cfg Config) error {
+	loc := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return fmt.Errorf("couldn't load timezone '%s': %w", cfg.Timezone, err)
+		}
+	}
+
+	if cfg.Latitude < -90 || cfg.Latitude > 90 || cfg.Longitude < -180 || cfg.Longitude > 180 {
+		return fmt.Errorf("coordinates %f,%f are out of range", cfg.Latitude, cfg.Longitude)
+	}
+
+	holidays := map[string]bool{}
+	for _, h := range cfg.Holidays {
+		_, err := time.Parse(holidayLayout, h)
+		if err != nil {
+			return fmt.Errorf("holiday '%s' is not in the YYYY-MM-DD form", h)
+		}
+		holidays[h] = true
+	}
+
+	rules := make([]compiled, 0, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		var (
+			t   trigger
+			err error
+		)
+
+		switch {
+		case r.Cron != "" && r.At != "":
+			return fmt.Errorf("rule #%d '%s': only one of cron or solar event could be set", i+1, r.Name)
+		case r.At != "":
+			if cfg.Latitude == 0 && cfg.Longitude == 0 {
+				return fmt.Errorf("rule #%d '%s': latitude and longitude must be set for the solar events", i+1, r.Name)
+			}
+			t, err = ParseSolar(r.At, cfg.Latitude, cfg.Longitude)
+		default:
+			t, err = ParseCron(r.Cron)
+		}
+		if err != nil {
+			return fmt.Errorf("rule #%d '%s': %w", i+1, r.Name, err)
+		}
+		if r.Flow == "" {
+			return fmt.Errorf("rule #%d '%s': flow is empty", i+1, r.Name)
+		}
+		switch r.Holidays {
+		case HolidayAny, HolidaySkip, HolidayOnly:
+		default:
+			return fmt.Errorf("rule #%d '%s': unknown holidays policy '%s'", i+1, r.Name, r.Holidays)
+		}
+		rules = append(rules, compiled{rule: r, trigger: t})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cfg = cfg
+	s.loc = loc
+	s.holidays = holidays
+	s.rules = rules
+
+	return nil
+}
+
+// Suspend stops the schedule until the next transition
+// It is used when the flow is selected manually
+func (s *Scheduler) Suspend() {
+	next := s.Upcoming(time.Now(), 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(next) == 0 {
+		s.suspendedUntil = time.Time{}
+		return
+	}
+
+	s.suspendedUntil = next[0].At
+	s.applied = nil
+	s.log.Info("schedule is suspended", slog.Time("until", s.suspendedUntil))
+}
+
+// Restore tells the scheduler that the flows saved at the moment are restored after the restart
+// Transitions before the moment are already in effect and aren't applied on the start
+func (s *Scheduler) Restore(savedAt, suspendedUntil time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.restoredAt = savedAt
+	if suspendedUntil.After(time.Now()) {
+		s.suspendedUntil = suspendedUntil
+		s.log.Info("schedule is suspended", slog.Time("until", s.suspendedUntil))
+	}
+}
+
+// SuspendedUntil returns the time when the manual selection is overridden by the schedule
+// Zero time means the schedule is not suspended
+func (s *Scheduler) SuspendedUntil() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if time.Now().After(s.suspendedUntil) {
+		return time.Time{}
+	}
+
+	return s.suspendedUntil
+}
+
+// Upcoming returns n next transitions after the moment
+func (s *Scheduler) Upcoming(after time.Time, n int) []Transition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	after = after.In(s.loc)
+	cursors := make([]time.Time, len(s.rules))
+	for i := range s.rules {
+		cursors[i] = s.next(i, after)
+	}
+
+	result := []Transition{}
+	for len(result) < n {
+		idx := -1
+		for i, c := range cursors {
+			if c.IsZero() {
+				continue
+			}
+			if idx == -1 || c.Before(cursors[idx]) {
+				idx = i
+			}
+		}
+		if idx == -1 {
+			break
+		}
+
+		result = append(result, Transition{At: cursors[idx], Rule: s.rules[idx].rule})
+		cursors[idx] = s.next(idx, cursors[idx])
+	}
+
+	return result
+}
+
+// current returns the transition which is in effect at the moment
+// Only the last week is taken into account
+func (s *Scheduler) current(now time.Time) (Transition, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var (
+		found  bool
+		result Transition
+		from   = now.In(s.loc).Add(-7 * 24 * time.Hour)
+	)
+
+	for i, r := range s.rules {
+		for t := s.next(i, from); !t.IsZero() && !t.After(now); t = s.next(i, t) {
+			if !found || !t.Before(result.At) {
+				result = Transition{At: t, Rule: r.rule}
+				found = true
+			}
+		}
+	}
+
+	return result, found
+}
+
+// next returns the next activation of the rule which satisfies the holidays policy
+// must be called under the lock
+func (s *Scheduler) next(idx int, after time.Time) time.Time {
+	r := s.rules[idx]
+	t := r.trigger.Next(after)
+	for !t.IsZero() {
+		isHoliday := s.holidays[t.Format(holidayLayout)]
+		switch {
+		case r.rule.Holidays == HolidaySkip && isHoliday,
+			r.rule.Holidays == HolidayOnly && !isHoliday:
+			// jump to the end of the day
+			t = r.trigger.Next(time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 0, 0, t.Location()))
+			continue
+		}
+
+		return t
+	}
+
+	return t
+}
+
+// Run executes the schedule until the context is done
+// On the start and after every update the rule in effect is applied unless the schedule is suspended
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.log.Info("starting scheduler")
+
+	s.mu.RLock()
+	restoredAt := s.restoredAt
+	s.mu.RUnlock()
+
+	s.applyCurrent(ctx, restoredAt)
+
+	for {
+		var (
+			timer *time.Timer
+			wait  <-chan time.Time
+		)
+
+		next := s.Upcoming(time.Now(), 1)
+		if len(next) > 0 {
+			s.log.Info("next transition", slog.String("rule", next[0].Rule.Name), slog.Time("at", next[0].At))
+			timer = time.NewTimer(time.Until(next[0].At))
+			wait = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		case <-s.reload:
+			s.applyCurrent(ctx, time.Time{})
+		case <-wait:
+			s.mu.Lock()
+			s.suspendedUntil = time.Time{}
+			s.mu.Unlock()
+
+			s.selectFlow(ctx, next[0])
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// applyCurrent selects the flow of the rule in effect unless it is applied before the moment
+func (s *Scheduler) applyCurrent(ctx context.Context, appliedBefore time.Time) {
+	if !s.SuspendedUntil().IsZero() {
+		return
+	}
+
+	cur, ok := s.current(time.Now())
+	if !ok {
+		return
+	}
+	if cur.At.Before(appliedBefore) {
+		// the flow is restored already
+		s.mu.Lock()
+		s.applied = &cur.Rule
+		s.mu.Unlock()
+		return
+	}
+
+	// updates which don't change the flow in effect don't restart it
+	s.mu.RLock()
+	applied := s.applied
+	s.mu.RUnlock()
+	if applied != nil && applied.Flow == cur.Rule.Flow && maps.Equal(applied.Params, cur.Rule.Params) {
+		return
+	}
+
+	s.selectFlow(ctx, cur)
+}
+
+func (s *Scheduler) selectFlow(ctx context.Context, t Transition) {
+	s.log.Info("selecting flow", slog.String("rule", t.Rule.Name), slog.String("flow", t.Rule.Flow), slog.String("params", t.Rule.Params.String()))
+
+	err := s.flows.SelectFlowWith(ctx, t.Rule.Flow, t.Rule.Params)
+	if err != nil {
+		s.log.Error("couldn't select flow", slog.String("rule", t.Rule.Name), slog.Any("err", err))
+		return
+	}
+
+	s.mu.Lock()
+	s.applied = &t.Rule
+	s.mu.Unlock()
+}
@@ -0,0 +1,75 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSelector struct {
+	mu       sync.Mutex
+	selected []string
+}
+
+func (f *fakeSelector) SelectFlowWith(_ context.Context, name string, params flow.Params) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.selected = append(f.selected, name+" "+params.String())
+	return nil
+}
+
+func (f *fakeSelector) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.selected...)
+}
+
+func TestScheduler_Update(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	sel := &fakeSelector{}
+	s, err := New(fs, sel, Options{Path: "schedule.json"})
+	require.NoError(t, err)
+
+	cfg := Config{Rules: []Rule{{Name: "evening", Cron: "0 0 * * *", Flow: "live"}}}
+	require.NoError(t, s.Update(cfg))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx) //nolint: errcheck
+
+	waitCalls := func(want ...string) {
+		t.Helper()
+		require.Eventually(t, func() bool { return len(sel.calls()) >= len(want) }, time.Second, time.Millisecond)
+		// nothing else is selected
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, want, sel.calls())
+	}
+	waitCalls("live ")
+
+	// updates which keep the flow in effect don't restart it
+	cfg.Holidays = []string{"2099-01-01"}
+	cfg.Rules[0].Name = "night"
+	require.NoError(t, s.Update(cfg))
+	cfg.Timezone = "UTC"
+	require.NoError(t, s.Update(cfg))
+	waitCalls("live ")
+
+	cfg.Rules[0].Params = flow.Params{"max-delay": "1m"}
+	require.NoError(t, s.Update(cfg))
+	waitCalls("live ", "live max-delay=1m")
+
+	// the manual selection is overridden by the update
+	s.Suspend()
+	s.mu.Lock()
+	s.suspendedUntil = time.Time{}
+	s.mu.Unlock()
+	require.NoError(t, s.Update(cfg))
+	waitCalls("live ", "live max-delay=1m", "live max-delay=1m")
+}
@@ -0,0 +1,182 @@
+package schedule
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// SolarEvent is the moment of the day defined by the sun position
+type SolarEvent string
+
+const (
+	Sunrise          SolarEvent = "sunrise"
+	Sunset           SolarEvent = "sunset"
+	CivilDawn        SolarEvent = "civil_dawn"
+	CivilDusk        SolarEvent = "civil_dusk"
+	NauticalDawn     SolarEvent = "nautical_dawn"
+	NauticalDusk     SolarEvent = "nautical_dusk"
+	AstronomicalDawn SolarEvent = "astronomical_dawn"
+	AstronomicalDusk SolarEvent = "astronomical_dusk"
+)
+
+// solarEvents maps events to the sun altitude in degrees and the direction (true for the morning)
+var solarEvents = map[SolarEvent]struct {
+	altitude float64
+	morning  bool
+}{
+	Sunrise:          {altitude: -0.833, morning: true},
+	Sunset:           {altitude: -0.833},
+	CivilDawn:        {altitude: -6, morning: true},
+	CivilDusk:        {altitude: -6},
+	NauticalDawn:     {altitude: -12, morning: true},
+	NauticalDusk:     {altitude: -12},
+	AstronomicalDawn: {altitude: -18, morning: true},
+	AstronomicalDusk: {altitude: -18},
+}
+
+const (
+	julian2000   = 2451545.0
+	unixToJulian = 2440587.5
+	earthTilt    = 23.4397
+	secondsInDay = 24 * 60 * 60
+)
+
+// SolarTime returns the moment of the event on the calendar day of the date at the coordinates
+// Calculation is done with the sunrise equation and is accurate to a minute or two
+// false is returned when there is no such event on the day (polar day or night)
+func SolarTime(ev SolarEvent, date time.Time, lat, lon float64) (time.Time, bool) {
+	e, ok := solarEvents[ev]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	// days since J2000 for the noon of the calendar day
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/secondsInDay + unixToJulian - julian2000)
+
+	// mean solar time
+	jStar := n - lon/360
+	// solar mean anomaly
+	m := math.Mod(357.5291+0.98560028*jStar, 360)
+	mRad := rad(m)
+	// equation of the center
+	c := 1.9148*math.Sin(mRad) + 0.0200*math.Sin(2*mRad) + 0.0003*math.Sin(3*mRad)
+	// ecliptic longitude
+	lambda := rad(math.Mod(m+c+180+102.9372, 360))
+	// solar transit
+	transit := julian2000 + jStar + 0.0053*math.Sin(mRad) - 0.0069*math.Sin(2*lambda)
+	// declination of the sun
+	sinDelta := math.Sin(lambda) * math.Sin(rad(earthTilt))
+	cosDelta := math.Cos(math.Asin(sinDelta))
+	// hour angle
+	cosOmega := (math.Sin(rad(e.altitude)) - math.Sin(rad(lat))*sinDelta) / (math.Cos(rad(lat)) * cosDelta)
+	if cosOmega < -1 || cosOmega > 1 {
+		return time.Time{}, false
+	}
+	omega := deg(math.Acos(cosOmega)) / 360
+
+	j := transit + omega
+	if e.morning {
+		j = transit - omega
+	}
+
+	unix := (j - unixToJulian) * secondsInDay
+
+	return time.Unix(int64(math.Round(unix)), 0).In(date.Location()), true
+}
+
+func rad(d float64) float64 { return d * math.Pi / 180 }
+func deg(r float64) float64 { return r * 180 / math.Pi }
+
+// Solar is the trigger on the solar event with an offset, e.g. 'sunset+30m' or 'civil_dusk mon-fri'
+type Solar struct {
+	event    SolarEvent
+	offset   time.Duration
+	lat, lon float64
+	// days is the day-of-week filter in the cron format
+	days uint64
+}
+
+// IsSolar returns true if the expression starts with the solar event name
+func IsSolar(expr string) bool {
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return false
+	}
+	ev, _ := splitOffset(fields[0])
+	_, ok := solarEvents[SolarEvent(ev)]
+	return ok
+}
+
+// ParseSolar parses expression '<event>[+-offset] [days of week]' for the coordinates
+func ParseSolar(expr string, lat, lon float64) (*Solar, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("solar expression '%s' must be in the '<event>[+-offset] [days of week]' form", expr)
+	}
+
+	ev, offsetRaw := splitOffset(fields[0])
+	if _, ok := solarEvents[SolarEvent(ev)]; !ok {
+		return nil, fmt.Errorf("unknown solar event '%s'", ev)
+	}
+
+	s := &Solar{event: SolarEvent(ev), lat: lat, lon: lon, days: 0xff}
+
+	if offsetRaw != "" {
+		d, err := time.ParseDuration(offsetRaw)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse offset '%s': %w", offsetRaw, err)
+		}
+		s.offset = d
+	}
+
+	if len(fields) == 2 {
+		days, err := dowField.parse(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse days of week: %w", err)
+		}
+		if days&(1<<7) != 0 {
+			days |= 1
+		}
+		s.days = days
+	}
+
+	return s, nil
+}
+
+func splitOffset(raw string) (string, string) {
+	idx := strings.IndexAny(raw, "+-")
+	if idx == -1 {
+		return raw, ""
+	}
+	return raw[:idx], raw[idx:]
+}
+
+// Next returns the first event strictly after t in the location of t
+// Zero time is returned when there is no event in a year (e.g. polar regions)
+func (s *Solar) Next(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	// the offset could move the event to the previous day
+	day = day.AddDate(0, 0, -1)
+
+	for i := 0; i < 368; i++ {
+		d := day.AddDate(0, 0, i)
+		if s.days&(1<<uint(d.Weekday())) == 0 {
+			continue
+		}
+
+		ev, ok := SolarTime(s.event, d, s.lat, s.lon)
+		if !ok {
+			continue
+		}
+
+		ev = ev.Add(s.offset).Truncate(time.Minute)
+		if ev.After(t) {
+			return ev
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,83 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSolarTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	require.NoError(t, err)
+
+	// values are taken from the almanac tables (timeanddate.com) and are rounded to the minute
+	tests := []struct {
+		name     string
+		event    SolarEvent
+		date     time.Time
+		lat, lon float64
+		want     time.Time
+		wantOK   bool
+	}{
+		{name: "new york sunrise on summer solstice", event: Sunrise,
+			date: time.Date(2024, 6, 21, 0, 0, 0, 0, newYork), lat: 40.7128, lon: -74.0060,
+			want: time.Date(2024, 6, 21, 5, 25, 0, 0, newYork), wantOK: true},
+		{name: "new york sunset on summer solstice", event: Sunset,
+			date: time.Date(2024, 6, 21, 0, 0, 0, 0, newYork), lat: 40.7128, lon: -74.0060,
+			want: time.Date(2024, 6, 21, 20, 31, 0, 0, newYork), wantOK: true},
+		{name: "new york civil dusk on summer solstice", event: CivilDusk,
+			date: time.Date(2024, 6, 21, 0, 0, 0, 0, newYork), lat: 40.7128, lon: -74.0060,
+			want: time.Date(2024, 6, 21, 21, 3, 0, 0, newYork), wantOK: true},
+		{name: "london sunrise on winter solstice", event: Sunrise,
+			date: time.Date(2024, 12, 21, 0, 0, 0, 0, london), lat: 51.5074, lon: -0.1278,
+			want: time.Date(2024, 12, 21, 8, 4, 0, 0, london), wantOK: true},
+		{name: "london sunset on winter solstice", event: Sunset,
+			date: time.Date(2024, 12, 21, 0, 0, 0, 0, london), lat: 51.5074, lon: -0.1278,
+			want: time.Date(2024, 12, 21, 15, 53, 0, 0, london), wantOK: true},
+		{name: "moscow sunset on equinox", event: Sunset,
+			date: time.Date(2024, 3, 20, 0, 0, 0, 0, moscow), lat: 55.7558, lon: 37.6173,
+			want: time.Date(2024, 3, 20, 18, 43, 0, 0, moscow), wantOK: true},
+		{name: "sydney sunset in summer", event: Sunset,
+			date: time.Date(2024, 1, 1, 0, 0, 0, 0, sydney), lat: -33.8688, lon: 151.2093,
+			want: time.Date(2024, 1, 1, 20, 9, 0, 0, sydney), wantOK: true},
+		{name: "polar night in tromso", event: Sunrise,
+			date: time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), lat: 69.6496, lon: 18.9560,
+			wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := SolarTime(tt.event, tt.date, tt.lat, tt.lon)
+			require.Equal(t, tt.wantOK, ok)
+			if !ok {
+				return
+			}
+
+			require.WithinDuration(t, tt.want, got, 2*time.Minute, "got %s", got)
+		})
+	}
+}
+
+func TestSolar_Next(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+
+	// 2024-12-20 is Friday
+	from := time.Date(2024, 12, 20, 18, 0, 0, 0, london)
+
+	s, err := ParseSolar("sunset-30m mon-fri", 51.5074, -0.1278)
+	require.NoError(t, err)
+
+	got := s.Next(from)
+	require.Equal(t, time.Monday, got.Weekday())
+	require.WithinDuration(t, time.Date(2024, 12, 23, 15, 25, 0, 0, london), got, 2*time.Minute)
+
+	_, err = ParseSolar("noon", 51.5074, -0.1278)
+	require.Error(t, err)
+}
@@ -1,78 +1,319 @@
 package file
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
-	"github.com/mbobakov/khrushchevka/internal"
-	"github.com/mbobakov/khrushchevka/internal/lights"
 	"github.com/mbobakov/khrushchevka/internal/snapshot"
 	"github.com/spf13/afero"
 )
 
+// ext is the extension of the sequence files, they are the replay files of the version 1
+const ext = ".jsonl"
+
 type Options struct {
-	Path string `long:"path" env:"PATH" default:"./snapshot.json" description:"path to snapshot file"`
+	Dir string `long:"dir" env:"DIR" default:"./snapshots" description:"directory of the snapshot sequences, a sequence is the <name>.jsonl file"`
+	// Path is the snapshot file of the previous versions, it is imported as the default sequence
+	Path string `long:"path" env:"PATH" description:"deprecated, use --snap.dir. The file is imported as the default sequence"`
+}
+
+var _ snapshot.Store = &Dir{}
+
+// Dir keeps every sequence in its own file of the directory, a frame per line
+type Dir struct {
+	mu  sync.Mutex
+	fs  afero.Fs
+	dir string
+}
+
+func New(opts Options, fs afero.Fs) *Dir {
+	return &Dir{
+		fs:  fs,
+		dir: opts.Dir,
+	}
+}
+
+// Path returns the file of the sequence, it could be played by the replay as is
+func (d *Dir) Path(name string) string {
+	return filepath.Join(d.dir, name+ext)
+}
+
+// List returns the sequences sorted by name, broken files are skipped
+func (d *Dir) List() ([]snapshot.Sequence, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	infos, err := afero.ReadDir(d.fs, d.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read directory '%s': %w", d.dir, err)
+	}
+
+	result := []snapshot.Sequence{}
+	for _, info := range infos {
+		name, ok := strings.CutSuffix(info.Name(), ext)
+		if info.IsDir() || !ok || snapshot.ValidateName(name) != nil {
+			continue
+		}
+		seq, err := d.read(name)
+		if err != nil {
+			// a broken file doesn't hide the other sequences
+			slog.Warn("broken sequence is skipped", slog.String("path", d.Path(name)), slog.Any("err", err))
+			continue
+		}
+		result = append(result, seq)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result, nil
 }
 
-type JSON struct {
-	mu      sync.Mutex
-	fs      afero.Fs
-	path    string
-	lights  lights.ControllerI
-	mapping [][]internal.Light
+func (d *Dir) Get(name string) (snapshot.Sequence, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.read(name)
 }
 
-func New(opts Options, fs afero.Fs, l lights.ControllerI, mapping [][]internal.Light) *JSON {
-	return &JSON{
-		fs:      fs,
-		path:    opts.Path,
-		mapping: mapping,
-		lights:  l,
+func (d *Dir) Create(name string) error {
+	err := snapshot.ValidateName(name)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.exists(name) {
+		return fmt.Errorf("sequence '%s' already exists", name)
 	}
+	return d.write(snapshot.Sequence{Name: name})
 }
 
-func (j *JSON) Snapshot() error {
-	j.mu.Lock()
-	defer j.mu.Unlock()
+func (d *Dir) Append(name string, frame []snapshot.LightDTO) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	f, err := j.fs.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if !d.exists(name) {
+		return fmt.Errorf("couldn't append to '%s': %w", name, snapshot.ErrNotFound)
+	}
+
+	buf, err := json.Marshal(frame)
 	if err != nil {
-		return fmt.Errorf("couldn't open file '%s': %w", j.path, err)
+		return fmt.Errorf("couldn't marshal frame: %w", err)
+	}
+
+	f, err := d.fs.OpenFile(d.Path(name), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't open file '%s': %w", d.Path(name), err)
 	}
 	defer f.Close()
 
-	state := []snapshot.LightDTO{}
+	_, err = f.Write(append(buf, '\n'))
+	if err != nil {
+		return fmt.Errorf("couldn't write to file '%s': %w", d.Path(name), err)
+	}
 
-	for _, lvl := range j.mapping {
-		for _, light := range lvl {
-			if light.Addr.Pin == "" {
-				continue
-			}
-			isOn, err := j.lights.IsOn(light.Addr)
-			if err != nil {
-				return fmt.Errorf("couldn't get light state for '%v': %w", light.Addr, err)
-			}
+	return nil
+}
 
-			state = append(state, snapshot.LightDTO{Board: light.Addr.Board, Pin: light.Addr.Pin, IsOn: isOn})
-		}
+func (d *Dir) Rename(name, to string) error {
+	err := snapshot.ValidateName(to)
+	if err != nil {
+		return err
 	}
 
-	jsBuf, err := json.Marshal(state)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.exists(name) {
+		return fmt.Errorf("couldn't rename '%s': %w", name, snapshot.ErrNotFound)
+	}
+	if d.exists(to) {
+		return fmt.Errorf("sequence '%s' already exists", to)
+	}
+
+	err = d.fs.Rename(d.Path(name), d.Path(to))
 	if err != nil {
-		return fmt.Errorf("couldn't marshal state: %w", err)
+		return fmt.Errorf("couldn't rename '%s' to '%s': %w", name, to, err)
 	}
+	return nil
+}
 
-	_, err = f.Write(jsBuf)
+func (d *Dir) Duplicate(name, to string) error {
+	err := snapshot.ValidateName(to)
 	if err != nil {
-		return fmt.Errorf("couldn't write to file: %w", err)
+		return err
 	}
 
-	_, err = f.WriteString("\n")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seq, err := d.read(name)
 	if err != nil {
-		return fmt.Errorf("couldn't write endline to file: %w", err)
+		return err
+	}
+	if d.exists(to) {
+		return fmt.Errorf("sequence '%s' already exists", to)
+	}
+
+	seq.Name = to
+	return d.write(seq)
+}
+
+func (d *Dir) Delete(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.exists(name) {
+		return fmt.Errorf("couldn't delete '%s': %w", name, snapshot.ErrNotFound)
 	}
 
+	err := d.fs.Remove(d.Path(name))
+	if err != nil {
+		return fmt.Errorf("couldn't delete '%s': %w", name, err)
+	}
+	return nil
+}
+
+func (d *Dir) DeleteFrame(name string, frame int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seq, err := d.read(name)
+	if err != nil {
+		return err
+	}
+	if frame < 1 || frame > len(seq.Frames) {
+		return fmt.Errorf("frame must be in [1, %d], got %d", len(seq.Frames), frame)
+	}
+
+	seq.Frames = append(seq.Frames[:frame-1], seq.Frames[frame:]...)
+	return d.write(seq)
+}
+
+func (d *Dir) MoveFrame(name string, frame, to int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seq, err := d.read(name)
+	if err != nil {
+		return err
+	}
+
+	seq.Frames, err = snapshot.MoveFrame(seq.Frames, frame, to)
+	if err != nil {
+		return err
+	}
+	return d.write(seq)
+}
+
+func (d *Dir) exists(name string) bool {
+	if snapshot.ValidateName(name) != nil {
+		return false
+	}
+	ok, err := afero.Exists(d.fs, d.Path(name))
+	return err == nil && ok
+}
+
+// Import copies the snapshot file as the sequence. The existing sequence is kept, the missing file is skipped
+func (d *Dir) Import(path, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ok, err := afero.Exists(d.fs, path)
+	if err != nil {
+		return fmt.Errorf("couldn't check file '%s': %w", path, err)
+	}
+	if !ok || d.exists(name) {
+		return nil
+	}
+
+	frames, err := d.readFile(path)
+	if err != nil {
+		return err
+	}
+
+	return d.write(snapshot.Sequence{Name: name, Frames: frames})
+}
+
+func (d *Dir) read(name string) (snapshot.Sequence, error) {
+	if !d.exists(name) {
+		return snapshot.Sequence{}, fmt.Errorf("couldn't read '%s': %w", name, snapshot.ErrNotFound)
+	}
+
+	frames, err := d.readFile(d.Path(name))
+	if err != nil {
+		return snapshot.Sequence{}, err
+	}
+
+	return snapshot.Sequence{Name: name, Frames: frames}, nil
+}
+
+// readFile reads the frames of the file, a frame per line
+func (d *Dir) readFile(path string) ([][]snapshot.LightDTO, error) {
+	buf, err := afero.ReadFile(d.fs, path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read file '%s': %w", path, err)
+	}
+
+	frames := [][]snapshot.LightDTO{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		frame := []snapshot.LightDTO{}
+		err = json.Unmarshal(raw, &frame)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't unmarshal line %d of '%s': %w", line, path, err)
+		}
+		frames = append(frames, frame)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read file '%s': %w", path, err)
+	}
+
+	return frames, nil
+}
+
+// write replaces the file of the sequence at once, so the power cut doesn't leave it half-written
+func (d *Dir) write(seq snapshot.Sequence) error {
+	err := d.fs.MkdirAll(d.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("couldn't create directory '%s': %w", d.dir, err)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, frame := range seq.Frames {
+		line, err := json.Marshal(frame)
+		if err != nil {
+			return fmt.Errorf("couldn't marshal frame: %w", err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	path := d.Path(seq.Name)
+	err = afero.WriteFile(d.fs, path+".tmp", buf.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't write file '%s': %w", path+".tmp", err)
+	}
+	err = d.fs.Rename(path+".tmp", path)
+	if err != nil {
+		return fmt.Errorf("couldn't replace file '%s': %w", path, err)
+	}
 	return nil
 }
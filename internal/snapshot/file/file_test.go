@@ -0,0 +1,75 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDir(t *testing.T) {
+	var (
+		fs = afero.NewMemMapFs()
+		d  = New(Options{Dir: "snapshots"}, fs)
+	)
+	frame := func(window string) []snapshot.LightDTO {
+		return []snapshot.LightDTO{{Window: window, IsOn: true}}
+	}
+
+	seqs, err := d.List()
+	require.NoError(t, err)
+	require.Empty(t, seqs)
+
+	require.NoError(t, d.Create("evening"))
+	require.ErrorContains(t, d.Create("evening"), "already exists")
+	require.ErrorContains(t, d.Create("../evening"), "name of the sequence")
+	require.ErrorIs(t, d.Append("morning", frame("0-front-1-0")), snapshot.ErrNotFound)
+
+	for _, w := range []string{"0-front-1-0", "0-front-1-1", "0-front-1-2"} {
+		require.NoError(t, d.Append("evening", frame(w)))
+	}
+	require.NoError(t, d.MoveFrame("evening", 3, 1))
+	require.ErrorContains(t, d.MoveFrame("evening", 4, 1), "frame must be in [1, 3], got 4")
+	require.NoError(t, d.DeleteFrame("evening", 2))
+	require.NoError(t, d.Duplicate("evening", "night"))
+	require.NoError(t, d.Append("night", frame("1-back-6-0")))
+	require.NoError(t, d.Rename("evening", "morning"))
+	require.ErrorContains(t, d.Rename("morning", "night"), "already exists")
+
+	seqs, err = d.List()
+	require.NoError(t, err)
+	require.Equal(t, []snapshot.Sequence{
+		{Name: "morning", Frames: [][]snapshot.LightDTO{frame("0-front-1-2"), frame("0-front-1-1")}},
+		{Name: "night", Frames: [][]snapshot.LightDTO{frame("0-front-1-2"), frame("0-front-1-1"), frame("1-back-6-0")}},
+	}, seqs)
+
+	// sequence files are the replay files
+	buf, err := afero.ReadFile(fs, "snapshots/morning.jsonl")
+	require.NoError(t, err)
+	require.Equal(t, "[{\"window\":\"0-front-1-2\",\"is_on\":true}]\n[{\"window\":\"0-front-1-1\",\"is_on\":true}]\n", string(buf))
+
+	// the broken file is skipped
+	require.NoError(t, afero.WriteFile(fs, "snapshots/broken.jsonl", []byte("{broken\n"), 0644))
+	seqs, err = d.List()
+	require.NoError(t, err)
+	require.Len(t, seqs, 2)
+	_, err = d.Get("broken")
+	require.ErrorContains(t, err, "couldn't unmarshal line 1")
+
+	// the snapshot file of the previous versions is imported once
+	require.NoError(t, afero.WriteFile(fs, "snapshot.json", []byte("[{\"window\":\"0-front-1-0\",\"is_on\":true}]\n"), 0644))
+	require.NoError(t, d.Import("missing.json", "snapshot"))
+	require.NoError(t, d.Import("snapshot.json", "snapshot"))
+	require.NoError(t, d.Import("snapshot.json", "night"))
+	seq, err := d.Get("snapshot")
+	require.NoError(t, err)
+	require.Equal(t, [][]snapshot.LightDTO{frame("0-front-1-0")}, seq.Frames)
+	seq, err = d.Get("night")
+	require.NoError(t, err)
+	require.Len(t, seq.Frames, 3, "existing sequence is kept")
+
+	require.NoError(t, d.Delete("morning"))
+	_, err = d.Get("morning")
+	require.ErrorIs(t, err, snapshot.ErrNotFound)
+}
@@ -0,0 +1,27 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+// MappingHash returns the short hash of the layout of the windows of the building
+// Recordings made for another layout light up the wrong windows, the rewiring of the same layout keeps the hash
+func MappingHash(mapping [][]internal.Light) string {
+	w := NewWindows(mapping)
+	ids := make([]string, 0, len(w.byID))
+	for id := range w.byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	for _, id := range ids {
+		fmt.Fprintf(h, "%s;", id)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
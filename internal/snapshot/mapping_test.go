@@ -0,0 +1,48 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMappingHash(t *testing.T) {
+	mapping := [][]internal.Light{{
+		{Number: 1, Side: internal.SideFront, Addr: internal.LightAddress{Board: 32, Pin: "A0"}},
+		{Number: 2, Side: internal.SideBack, Addr: internal.LightAddress{Board: 32, Pin: "A1"}},
+	}}
+	tests := []struct {
+		name     string
+		mapping  [][]internal.Light
+		wantSame bool
+	}{
+		{
+			name: "rewired",
+			mapping: [][]internal.Light{{
+				{Number: 1, Side: internal.SideFront, Addr: internal.LightAddress{Board: 33, Pin: "B7"}},
+				{Number: 2, Side: internal.SideBack, Addr: internal.LightAddress{Board: 32, Pin: "A0"}},
+			}},
+			wantSame: true,
+		},
+		{
+			name: "another flat",
+			mapping: [][]internal.Light{{
+				{Number: 1, Side: internal.SideFront, Addr: internal.LightAddress{Board: 32, Pin: "A0"}},
+				{Number: 3, Side: internal.SideBack, Addr: internal.LightAddress{Board: 32, Pin: "A1"}},
+			}},
+		},
+		{
+			name: "window without the pin",
+			mapping: [][]internal.Light{{
+				{Number: 1, Side: internal.SideFront, Addr: internal.LightAddress{Board: 32, Pin: "A0"}},
+				{Number: 2, Side: internal.SideBack},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.wantSame, MappingHash(mapping) == MappingHash(tt.mapping))
+		})
+	}
+}
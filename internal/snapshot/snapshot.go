@@ -1,8 +1,10 @@
 package snapshot
 
 // LightDTO is a DTO for light record in the snapshots
+// The light is addressed by the window ID or by the board and the pin of the wiring
 type LightDTO struct {
-	Board uint8  `json:"board"`
-	Pin   string `json:"pin"`
-	IsOn  bool   `json:"is_on"`
+	Window string `json:"window,omitempty"`
+	Board  uint8  `json:"board,omitempty"`
+	Pin    string `json:"pin,omitempty"`
+	IsOn   bool   `json:"is_on"`
 }
@@ -0,0 +1,131 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+)
+
+// DefaultSequence is the sequence the snapshots are appended to when it isn't chosen
+const DefaultSequence = "snapshot"
+
+// ErrNotFound is returned by the store for the unknown sequence
+var ErrNotFound = errors.New("sequence isn't found")
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Sequence is the named list of the snapshots, every frame is the full state of the lights
+type Sequence struct {
+	Name   string       `json:"name"`
+	Frames [][]LightDTO `json:"frames"`
+}
+
+// Store keeps the named sequences of the snapshots
+// Frames are numbered from 1
+type Store interface {
+	// List returns the sequences sorted by name
+	List() ([]Sequence, error)
+	Get(name string) (Sequence, error)
+	Create(name string) error
+	Append(name string, frame []LightDTO) error
+	Rename(name, to string) error
+	Duplicate(name, to string) error
+	Delete(name string) error
+	DeleteFrame(name string, frame int) error
+	// MoveFrame moves the frame to the position, the frames between them are shifted
+	MoveFrame(name string, frame, to int) error
+}
+
+// ValidateName checks the name of the sequence, it is used as the file name by the backends
+func ValidateName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("name of the sequence must be up to 64 letters, digits, '-' or '_', got '%s'", name)
+	}
+	return nil
+}
+
+// Capture returns the state of the wired lights addressed by the windows
+func Capture(l lights.ControllerI, mapping [][]internal.Light) ([]LightDTO, error) {
+	windows := NewWindows(mapping)
+	state := []LightDTO{}
+	for _, row := range mapping {
+		for _, light := range row {
+			if light.Addr.Pin == "" {
+				continue
+			}
+			isOn, err := l.IsOn(light.Addr)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't get light state for '%v': %w", light.Addr, err)
+			}
+
+			// lights are written by the window, so the snapshots survive the rewiring
+			id, _ := windows.ID(light.Addr)
+			state = append(state, LightDTO{Window: id, IsOn: isOn})
+		}
+	}
+	return state, nil
+}
+
+// Snapshot captures the state of the lights and appends it to the sequence, the sequence is created if it doesn't exist
+func Snapshot(s Store, name string, l lights.ControllerI, mapping [][]internal.Light) error {
+	frame, err := Capture(l, mapping)
+	if err != nil {
+		return err
+	}
+
+	err = s.Append(name, frame)
+	if errors.Is(err, ErrNotFound) {
+		err = s.Create(name)
+		if err != nil {
+			return err
+		}
+		err = s.Append(name, frame)
+	}
+	return err
+}
+
+// SaveScene replaces the scene, the scene is the sequence of the single frame
+func SaveScene(s Store, name string, frame []LightDTO) error {
+	err := ValidateName(name)
+	if err != nil {
+		return err
+	}
+	err = s.Delete(name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	err = s.Create(name)
+	if err != nil {
+		return err
+	}
+	return s.Append(name, frame)
+}
+
+// LoadScene returns the state of the lights of the scene, it is the last frame of the sequence
+func LoadScene(s Store, name string) ([]LightDTO, error) {
+	seq, err := s.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq.Frames) == 0 {
+		return nil, fmt.Errorf("scene '%s' is empty", name)
+	}
+	return seq.Frames[len(seq.Frames)-1], nil
+}
+
+// MoveFrame moves the frame of the list to the position, the frames are numbered from 1
+func MoveFrame(frames [][]LightDTO, frame, to int) ([][]LightDTO, error) {
+	for _, n := range []int{frame, to} {
+		if n < 1 || n > len(frames) {
+			return nil, fmt.Errorf("frame must be in [1, %d], got %d", len(frames), n)
+		}
+	}
+
+	f := frames[frame-1]
+	frames = append(frames[:frame-1], frames[frame:]...)
+	frames = append(frames[:to-1], append([][]LightDTO{f}, frames[to-1:]...)...)
+	return frames, nil
+}
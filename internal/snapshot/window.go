@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"fmt"
+
+	"github.com/mbobakov/khrushchevka/internal"
+)
+
+var sides = map[internal.Side]string{
+	internal.SideFront: "front",
+	internal.SideRight: "right",
+	internal.SideBack:  "back",
+	internal.SideLeft:  "left",
+}
+
+// Windows resolves the window IDs through the mapping
+// The ID is floor-side-flat-index, e.g. 1-front-8-0 is the first window of the flat 8 on the front of the 1st floor
+// The ground floor is 0, the lights of the stairs belong to the flat 0
+// IDs depend only on the layout of the building, so the files using them survive the rewiring
+type Windows struct {
+	byID   map[string]internal.LightAddress
+	byAddr map[internal.LightAddress]string
+}
+
+func NewWindows(mapping [][]internal.Light) *Windows {
+	w := &Windows{
+		byID:   map[string]internal.LightAddress{},
+		byAddr: map[internal.LightAddress]string{},
+	}
+	for floor, row := range mapping {
+		counts := map[string]int{}
+		for _, l := range row {
+			if l.Addr.Pin == "" {
+				continue
+			}
+			group := fmt.Sprintf("%d-%s-%d", floor, sides[l.Side], l.Number)
+			id := fmt.Sprintf("%s-%d", group, counts[group])
+			counts[group]++
+
+			w.byID[id] = l.Addr
+			w.byAddr[l.Addr] = id
+		}
+	}
+	return w
+}
+
+// Address returns the wiring of the light, the window ID takes precedence over the board and the pin
+func (w *Windows) Address(d LightDTO) (internal.LightAddress, error) {
+	if d.Window == "" {
+		return internal.LightAddress{Board: d.Board, Pin: d.Pin}, nil
+	}
+	addr, ok := w.byID[d.Window]
+	if !ok {
+		return internal.LightAddress{}, fmt.Errorf("window '%s' isn't in the mapping", d.Window)
+	}
+	return addr, nil
+}
+
+// ID returns the window ID of the wired light
+func (w *Windows) ID(addr internal.LightAddress) (string, bool) {
+	id, ok := w.byAddr[addr]
+	return id, ok
+}
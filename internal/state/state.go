@@ -0,0 +1,258 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/flow/manual"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+	"github.com/spf13/afero"
+)
+
+type Options struct {
+	Path      string        `long:"path" env:"PATH" default:"./state.json" description:"path to the state file"`
+	NoRestore bool          `long:"no-restore" env:"NO_RESTORE" description:"don't restore modes and lights on the start"`
+	Lights    []string      `long:"lights" env:"LIGHTS" env-delim:"," default:"manual" description:"modes whose lights are saved and restored"`
+	MaxBoots  int           `long:"max-boots" env:"MAX_BOOTS" default:"3" description:"restore is skipped after this number of short runs in a row"`
+	Stable    time.Duration `long:"stable" env:"STABLE" default:"2m" description:"run is short if the service is stopped before this duration"`
+	Debounce  time.Duration `long:"debounce" env:"DEBOUNCE" default:"1s" description:"delay of the state file write after the change"`
+	// Buffer is the capacity of the subscription. Controller drops the changes when it is full, the state is saved after that
+	Buffer int `long:"buffer" env:"BUFFER" default:"256" description:"number of the light changes waiting for the save"`
+}
+
+// File is the content of the state file
+type File struct {
+	SavedAt time.Time           `json:"saved_at"`
+	Layers  []flow.Assignment   `json:"layers"`
+	Lights  []snapshot.LightDTO `json:"lights,omitempty"`
+	// SuspendedUntil is the end of the manual selection over the schedule
+	SuspendedUntil time.Time `json:"suspended_until,omitempty"`
+	// Boots is the number of short runs in a row. It guards against the crash loops caused by the restored state
+	Boots int `json:"boots"`
+}
+
+type Flows interface {
+	AssignFlow(ctx context.Context, name, group string, params flow.Params) error
+	Assignments() []flow.Assignment
+	Changes() <-chan struct{}
+	Owner(addr internal.LightAddress) string
+}
+
+type Schedule interface {
+	SuspendedUntil() time.Time
+	Restore(savedAt, suspendedUntil time.Time)
+}
+
+// Store saves the flows in execution and the lights of the selected modes on every change
+// and restores them on the start
+type Store struct {
+	fs      afero.Fs
+	opts    Options
+	flows   Flows
+	sched   Schedule
+	lights  lights.ControllerI
+	mapping [][]internal.Light
+	log     *slog.Logger
+
+	mu    sync.Mutex
+	boots int
+}
+
+func New(fs afero.Fs, flows Flows, sched Schedule, l lights.ControllerI, mapping [][]internal.Light, opts Options) *Store {
+	return &Store{
+		fs:      fs,
+		opts:    opts,
+		flows:   flows,
+		sched:   sched,
+		lights:  l,
+		mapping: mapping,
+		log:     slog.With("subsystem", "state"),
+	}
+}
+
+// Restore applies the saved state. Restore is skipped if it is disabled or the service is in the crash loop
+// Broken state file is ignored, so it couldn't prevent the start
+func (s *Store) Restore(ctx context.Context) error {
+	f, err := s.read()
+	if err != nil {
+		s.log.Error("couldn't read state, starting from scratch", slog.Any("err", err))
+	}
+
+	s.mu.Lock()
+	if f != nil {
+		s.boots = f.Boots
+	}
+	s.boots++
+	s.mu.Unlock()
+
+	switch {
+	case f == nil:
+		s.log.Info("nothing to restore", slog.String("path", s.opts.Path))
+	case s.opts.NoRestore:
+		s.log.Info("restore is disabled")
+	case f.Boots >= s.opts.MaxBoots:
+		s.log.Warn("restore is skipped, service is restarted too often", slog.Int("short_runs", f.Boots))
+	default:
+		s.apply(ctx, f)
+	}
+
+	// the run is counted as short until the service works for the stable period
+	return s.Save()
+}
+
+func (s *Store) apply(ctx context.Context, f *File) {
+	s.log.Info("restoring state", slog.Time("saved_at", f.SavedAt), slog.Int("layers", len(f.Layers)))
+
+	for _, l := range f.Lights {
+		err := s.lights.Set(internal.LightAddress{Board: l.Board, Pin: l.Pin}, l.IsOn)
+		if err != nil {
+			s.log.Error("couldn't restore light", slog.Any("light", l), slog.Any("err", err))
+		}
+	}
+
+	for _, l := range f.Layers {
+		params := flow.Params{"transition": string(flow.TransitionKeep)}
+		for k, v := range l.Params {
+			// the restored lights are kept, so the scene changed by hand isn't applied again
+			if l.Flow == manual.FlowName && k == manual.ParamScene {
+				continue
+			}
+			params[k] = v
+		}
+
+		err := s.flows.AssignFlow(ctx, l.Flow, l.Group, params)
+		if err != nil {
+			s.log.Error("couldn't restore flow", slog.String("flow", l.Flow), slog.String("group", l.Group), slog.Any("err", err))
+		}
+	}
+
+	if s.sched != nil {
+		s.sched.Restore(f.SavedAt, f.SuspendedUntil)
+	}
+}
+
+// Run saves the state on every change until the context is done
+func (s *Store) Run(ctx context.Context) error {
+	changes := make(chan internal.PinState, s.opts.Buffer)
+	s.lights.Subscribe(changes)
+
+	stable := time.NewTimer(s.opts.Stable)
+	defer stable.Stop()
+
+	var (
+		debounce *time.Timer
+		save     <-chan time.Time
+	)
+	schedule := func() {
+		if debounce == nil {
+			debounce = time.NewTimer(s.opts.Debounce)
+			save = debounce.C
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if debounce != nil {
+				debounce.Stop()
+			}
+			return nil
+		case <-stable.C:
+			s.mu.Lock()
+			s.boots = 0
+			s.mu.Unlock()
+			schedule()
+		case <-s.flows.Changes():
+			schedule()
+		case pin := <-changes:
+			// the buffer was full, so the changes of the saved lights could be dropped
+			missed := cap(changes) > 0 && len(changes) == cap(changes)-1
+			if missed || slices.Contains(s.opts.Lights, s.flows.Owner(pin.Addr)) {
+				schedule()
+			}
+		case <-save:
+			debounce, save = nil, nil
+			err := s.Save()
+			if err != nil {
+				s.log.Error("couldn't save state", slog.Any("err", err))
+			}
+		}
+	}
+}
+
+// Save writes the current state to the file
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := File{
+		SavedAt: time.Now(),
+		Layers:  s.flows.Assignments(),
+		Boots:   s.boots,
+	}
+
+	if s.sched != nil {
+		f.SuspendedUntil = s.sched.SuspendedUntil()
+	}
+
+	for _, row := range s.mapping {
+		for _, l := range row {
+			if l.Addr.Pin == "" || !slices.Contains(s.opts.Lights, s.flows.Owner(l.Addr)) {
+				continue
+			}
+
+			isOn, err := s.lights.IsOn(l.Addr)
+			if err != nil {
+				return fmt.Errorf("couldn't get state of the light '%v': %w", l.Addr, err)
+			}
+			f.Lights = append(f.Lights, snapshot.LightDTO{Board: l.Addr.Board, Pin: l.Addr.Pin, IsOn: isOn})
+		}
+	}
+
+	buf, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal state: %w", err)
+	}
+
+	// the file is replaced at once, so the power cut doesn't leave it half-written
+	tmp := s.opts.Path + ".tmp"
+	err = afero.WriteFile(s.fs, tmp, buf, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't write state file '%s': %w", tmp, err)
+	}
+
+	err = s.fs.Rename(tmp, s.opts.Path)
+	if err != nil {
+		return fmt.Errorf("couldn't replace state file '%s': %w", s.opts.Path, err)
+	}
+
+	return nil
+}
+
+func (s *Store) read() (*File, error) {
+	buf, err := afero.ReadFile(s.fs, s.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read state file '%s': %w", s.opts.Path, err)
+	}
+
+	f := &File{}
+	err = json.Unmarshal(buf, f)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal state file '%s': %w", s.opts.Path, err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,118 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFlows struct {
+	assigned []flow.Assignment
+	owner    map[internal.LightAddress]string
+}
+
+func (f *fakeFlows) AssignFlow(_ context.Context, name, group string, params flow.Params) error {
+	f.assigned = append(f.assigned, flow.Assignment{Flow: name, Group: group, Params: params})
+	return nil
+}
+
+func (f *fakeFlows) Assignments() []flow.Assignment { return f.assigned }
+func (f *fakeFlows) Changes() <-chan struct{}       { return nil }
+func (f *fakeFlows) Owner(addr internal.LightAddress) string {
+	return f.owner[addr]
+}
+
+type fakeSchedule struct {
+	savedAt, suspendedUntil time.Time
+}
+
+func (s *fakeSchedule) SuspendedUntil() time.Time { return s.suspendedUntil }
+func (s *fakeSchedule) Restore(savedAt, suspendedUntil time.Time) {
+	s.savedAt, s.suspendedUntil = savedAt, suspendedUntil
+}
+
+func TestStore_Restore(t *testing.T) {
+	var (
+		a1      = internal.LightAddress{Board: 0x20, Pin: "A0"}
+		a2      = internal.LightAddress{Board: 0x20, Pin: "A1"}
+		mapping = [][]internal.Light{{{Number: 1, Addr: a1}, {Number: 1, Addr: a2}}}
+		savedAt = time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)
+		opts    = Options{Path: "state.json", Lights: []string{"manual"}, MaxBoots: 3}
+		saved   = File{
+			SavedAt: savedAt,
+			Layers: []flow.Assignment{
+				{Flow: "manual", Group: flow.GroupAll, Params: flow.Params{"scene": "cosy"}},
+				{Flow: "live", Group: "back", Params: flow.Params{"max-delay": "1m"}},
+			},
+			Lights: []snapshot.LightDTO{{Board: 0x20, Pin: "A0", IsOn: true}},
+		}
+	)
+
+	tests := []struct {
+		name      string
+		file      *File
+		raw       string
+		noRestore bool
+		boots     int
+		want      []flow.Assignment
+		wantBoots int
+	}{
+		{name: "no file", wantBoots: 1},
+		{name: "broken file", raw: "{", wantBoots: 1},
+		{
+			name: "restored",
+			file: &saved,
+			want: []flow.Assignment{
+				{Flow: "manual", Group: flow.GroupAll, Params: flow.Params{"transition": "keep"}},
+				{Flow: "live", Group: "back", Params: flow.Params{"transition": "keep", "max-delay": "1m"}},
+			},
+			wantBoots: 1,
+		},
+		{name: "disabled", file: &saved, noRestore: true, wantBoots: 1},
+		{name: "crash loop", file: &saved, boots: 3, wantBoots: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.file != nil {
+				f := *tt.file
+				f.Boots = tt.boots
+				buf, err := json.Marshal(f)
+				require.NoError(t, err)
+				require.NoError(t, afero.WriteFile(fs, opts.Path, buf, 0644))
+			}
+			if tt.raw != "" {
+				require.NoError(t, afero.WriteFile(fs, opts.Path, []byte(tt.raw), 0644))
+			}
+
+			hw := lights.NewTestController(nil)
+			flows := &fakeFlows{owner: map[internal.LightAddress]string{}}
+			sched := &fakeSchedule{}
+			o := opts
+			o.NoRestore = tt.noRestore
+
+			s := New(fs, flows, sched, hw, mapping, o)
+			require.NoError(t, s.Restore(context.Background()))
+			require.Equal(t, tt.want, flows.assigned)
+
+			if tt.want != nil {
+				isOn, err := hw.IsOn(a1)
+				require.NoError(t, err)
+				require.True(t, isOn)
+				require.Equal(t, savedAt, sched.savedAt)
+			}
+
+			written, err := s.read()
+			require.NoError(t, err)
+			require.Equal(t, tt.wantBoots, written.Boots)
+		})
+	}
+}
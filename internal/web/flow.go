@@ -5,6 +5,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+
+	"github.com/mbobakov/khrushchevka/internal/flow"
 )
 
 func (s *Server) setFlow(w http.ResponseWriter, r *http.Request) {
@@ -23,23 +25,62 @@ func (s *Server) setFlow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	active := params.Get("selected")
+	wasLayered := len(s.flows.Running()) > 1
 
-	err = s.flows.SelectFlow(s.mainCtx, active)
+	err = s.flows.AssignFlow(s.mainCtx, active, flow.GroupAll, transitionParams(params))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "couldn't select Flow: %v", err)
 		return
 	}
 
-	fctx := &flowContext{
-		Names:    s.flows.FlowNames(),
-		Selected: active,
+	if s.sched != nil {
+		s.sched.Suspend()
+	}
+
+	if wasLayered {
+		// owners of the lights are changed, the whole page has to be updated
+		w.Header().Set("HX-Refresh", "true")
 	}
 
-	err = s.indexTmpl.ExecuteTemplate(w, "flows.gotmpl", fctx)
+	err = s.indexTmpl.ExecuteTemplate(w, "flows.gotmpl", s.flowContext())
 	if err != nil {
 		fmt.Fprintf(w, "couldn't execute template: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
+
+// assignFlow runs the flow on the group of lights
+// Page is reloaded after the assignment because the owners of the lights are changed
+func (s *Server) assignFlow(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	err = s.flows.AssignFlow(s.mainCtx, params.Get("flow"), params.Get("group"), transitionParams(params))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't assign flow: %v", err)
+		return
+	}
+
+	if s.sched != nil {
+		s.sched.Suspend()
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+}
+
+// transitionParams returns the transition selected on the page, empty one means the default transition
+func transitionParams(params url.Values) flow.Params {
+	t := params.Get("transition")
+	if t == "" {
+		return nil
+	}
+
+	return flow.Params{"transition": t}
+}
@@ -8,6 +8,8 @@ import (
 	"slices"
 
 	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/flow/live"
 )
 
 type lightContext struct {
@@ -16,19 +18,38 @@ type lightContext struct {
 	FlatNumber int
 	Class      string
 	Addr       internal.LightAddress
+	Owner      string
+	OwnerClass string
+	Persona    string
 }
+
+type ownerContext struct {
+	Name  string
+	Class string
+}
+
 type flowContext struct {
-	Names    []string
-	Selected string
+	Names       []string
+	Selected    string
+	Groups      []string
+	Running     []ownerContext
+	Errors      map[string]string
+	Overlays    []string
+	Transitions []flow.Transition
+	Occupancy   bool
+	Events      []string
+	Replay      *replayContext
 }
 
 type indexContext struct {
-	Active string
-	Front  [][]*lightContext
-	Right  [][]*lightContext
-	Back   [][]*lightContext
-	Left   [][]*lightContext
-	Flows  *flowContext
+	Active    string
+	Sequences []string
+	Scenes    []string
+	Front     [][]*lightContext
+	Right     [][]*lightContext
+	Back      [][]*lightContext
+	Left      [][]*lightContext
+	Flows     *flowContext
 }
 
 func (s *Server) index(w http.ResponseWriter, r *http.Request) {
@@ -53,11 +74,10 @@ func (s *Server) index(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) indexContext(mapping [][]internal.Light) (*indexContext, error) {
 	result := &indexContext{
-		Active: "index",
-		Flows: &flowContext{
-			Names:    s.flows.FlowNames(),
-			Selected: s.flows.Active(),
-		},
+		Active:    "index",
+		Sequences: s.sequenceNames(),
+		Scenes:    s.sceneNames(),
+		Flows:     s.flowContext(),
 	}
 
 	for _, ll := range mapping {
@@ -97,6 +117,52 @@ func (s *Server) indexContext(mapping [][]internal.Light) (*indexContext, error)
 	return result, nil
 }
 
+func (s *Server) flowContext() *flowContext {
+	result := &flowContext{
+		Names:     s.flows.FlowNames(),
+		Selected:  s.flows.Active(),
+		Groups:    s.flows.Groups(),
+		Errors:    s.flowErrors(),
+		Overlays:  s.flows.OverlayNames(),
+		Occupancy: s.live != nil,
+		Transitions: []flow.Transition{
+			flow.TransitionNone, flow.TransitionKeep, flow.TransitionWipe, flow.TransitionDissolve, flow.TransitionCrossfade,
+		},
+	}
+	if s.live != nil {
+		result.Events = live.EventNames()
+	}
+	if s.replay != nil {
+		p, ok := s.replay.Position()
+		result.Replay = &replayContext{Running: ok, Position: p}
+	}
+
+	running := s.flows.Running()
+	if len(running) > 1 {
+		for _, n := range running {
+			result.Running = append(result.Running, ownerContext{Name: n, Class: s.ownerClass(n)})
+		}
+	}
+
+	return result
+}
+
+// ownerClass returns css class to distinguish flows on the page
+// classes are used only when the building is split between several flows
+func (s *Server) ownerClass(owner string) string {
+	if owner == "" || len(s.flows.Running()) < 2 {
+		return ""
+	}
+
+	palette := []string{"border-primary", "border-success", "border-danger", "border-info", "border-dark", "border-light"}
+	idx := slices.Index(s.flows.FlowNames(), owner)
+	if idx == -1 {
+		return ""
+	}
+
+	return "border border-2 " + palette[idx%len(palette)]
+}
+
 func cssClassByType(t internal.LightType) string {
 	switch t {
 	case internal.LightTypeServiceNoManLand:
@@ -127,12 +193,16 @@ func (s *Server) lightContext(l internal.Light) (*lightContext, error) {
 			return nil, nil
 		}
 	}
+	owner := s.flows.Owner(l.Addr)
 	return &lightContext{
 		ID:         lightID(l),
 		IsOn:       isOn,
 		FlatNumber: l.Number,
 		Class:      cssClassByType(l.Kind),
 		Addr:       l.Addr,
+		Owner:      owner,
+		OwnerClass: s.ownerClass(owner),
+		Persona:    s.persona(l.Number),
 	}, nil
 
 }
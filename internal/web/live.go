@@ -0,0 +1,156 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/flow/live"
+)
+
+type LiveStatus interface {
+	// Occupancy reports false if the live mode isn't running
+	Occupancy() (live.Occupancy, bool)
+	// Personas returns the personas of the flats by number
+	Personas() map[int]string
+	// Status reports false if the live mode isn't running
+	Status() (live.Status, bool)
+	// Trigger starts the building event now
+	Trigger(name string) error
+	// DryRun executes the live mode with the params on the virtual clock for the span
+	DryRun(ctx context.Context, from, to time.Time, p flow.Params) (live.Timeline, error)
+}
+
+type occupancyContext struct {
+	Running bool
+	live.Occupancy
+	Percent int
+	Event   string
+}
+
+// liveOccupancy renders the target of the awake flats for the current hour, the panel polls it
+func (s *Server) liveOccupancy(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.live.Occupancy()
+	octx := occupancyContext{Running: ok, Occupancy: o, Percent: int(o.Fraction * 100)}
+	if st, ok := s.live.Status(); ok {
+		octx.Event = st.Event
+	}
+
+	buf := &bytes.Buffer{}
+
+	err := s.indexTmpl.ExecuteTemplate(buf, "occupancy.gotmpl", octx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't execute template: %v", err)
+		return
+	}
+
+	w.Write(buf.Bytes()) //nolint: errcheck
+}
+
+// liveStatus returns the stays of the flats in the live mode:
+// curl http://khrushchevka/live/status
+func (s *Server) liveStatus(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.live.Status()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "live mode isn't running")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(st)
+	if err != nil {
+		slog.Error("couldn't encode live status", slog.Any("err", err))
+	}
+}
+
+// liveEvent starts the building event in the live mode:
+// curl -d 'event=outage' http://khrushchevka/live/events
+func (s *Server) liveEvent(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	err = s.live.Trigger(params.Get("event"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't start event: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// liveDryRun previews the live mode for the span without the hardware, 18:00-02:00 of today by default:
+// curl 'http://khrushchevka/live/dry-run?from=18:00&to=02:00&date=2024-12-31&format=csv&params=seed%3D42'
+func (s *Server) liveDryRun(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	get := func(key, def string) string {
+		if v := q.Get(key); v != "" {
+			return v
+		}
+		return def
+	}
+
+	day := time.Now()
+	if raw := q.Get("date"); raw != "" {
+		var err error
+		day, err = time.ParseInLocation(time.DateOnly, raw, time.Local)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "couldn't parse date: %v", err)
+			return
+		}
+	}
+
+	from, to, err := live.ParseSpan(day, get("from", "18:00"), get("to", "02:00"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't parse span: %v", err)
+		return
+	}
+
+	fp, err := flow.ParseParams(q.Get("params"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't parse params: %v", err)
+		return
+	}
+
+	t, err := s.live.DryRun(r.Context(), from, to, fp)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't run live mode: %v", err)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	format := get("format", live.FormatJSON)
+	err = t.Write(buf, format)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't write timeline: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", live.ContentType(format))
+	w.Write(buf.Bytes()) //nolint: errcheck
+}
+
+// persona returns the persona of the flat in the live mode
+func (s *Server) persona(flat int) string {
+	if s.live == nil || flat == 0 {
+		return ""
+	}
+
+	return s.live.Personas()[flat]
+}
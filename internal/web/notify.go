@@ -7,12 +7,18 @@ import (
 	"log/slog"
 
 	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow/replay"
 	"github.com/r3labs/sse"
 )
 
 func (s *Server) NotifyViaSSE(ctx context.Context) error {
 	ch := make(chan internal.PinState)
 	s.lights.Subscribe(ch)
+	var positions chan replay.Position
+	if s.replay != nil {
+		positions = make(chan replay.Position)
+		s.replay.Subscribe(positions)
+	}
 	log := slog.With(slog.String("subsystem", "sse"))
 	log.Info("starting SSE notifications")
 	for {
@@ -20,6 +26,13 @@ func (s *Server) NotifyViaSSE(ctx context.Context) error {
 		case <-ctx.Done():
 			log.Info("stopping SSE notifications")
 			return nil
+		case p := <-positions:
+			data, err := s.replayFragment(p)
+			if err != nil {
+				log.Error("couldn't render replay position", slog.Any("err", err))
+				continue
+			}
+			s.sse.Publish("lights", &sse.Event{Event: []byte("replay"), Data: data})
 		case pin := <-ch:
 			log.Debug("got pin state", slog.Any("pin", pin))
 			lctx, err := s.lightContextByPinState(pin)
@@ -51,12 +64,16 @@ func (s *Server) lightContextByPinState(pin internal.PinState) (*lightContext, e
 				continue
 			}
 
+			owner := s.flows.Owner(wnd.Addr)
 			return &lightContext{
 				ID:         lightID(wnd),
 				IsOn:       pin.IsOn,
 				FlatNumber: wnd.Number,
 				Class:      cssClassByType(wnd.Kind),
 				Addr:       wnd.Addr,
+				Owner:      owner,
+				OwnerClass: s.ownerClass(owner),
+				Persona:    s.persona(wnd.Number),
 			}, nil
 		}
 	}
@@ -0,0 +1,54 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/flow"
+)
+
+const defaultOverlayDuration = 5 * time.Second
+
+// triggerOverlay runs the overlay on top of the flows, e.g. from the doorbell button:
+// curl -d 'overlay=doorbell&group=front&duration=10s' http://khrushchevka/overlays
+func (s *Server) triggerOverlay(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	group := params.Get("group")
+	if group == "" {
+		group = flow.GroupAll
+	}
+
+	d := defaultOverlayDuration
+	if raw := strings.TrimSpace(params.Get("duration")); raw != "" {
+		d, err = time.ParseDuration(raw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "couldn't parse duration: %v", err)
+			return
+		}
+	}
+
+	fp, err := flow.ParseParams(params.Get("params"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't parse params: %v", err)
+		return
+	}
+
+	err = s.flows.Overlay(s.mainCtx, params.Get("overlay"), group, d, fp)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't start overlay: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/mbobakov/khrushchevka/internal/flow/playlist"
+)
+
+type Playlists interface {
+	List() []playlist.Definition
+	Save(def playlist.Definition) error
+	Delete(name string) error
+}
+
+type playlistContext struct {
+	Name    string
+	Shuffle bool
+	Steps   string
+}
+
+type playlistsContext struct {
+	Active    string
+	Error     string
+	Flows     []string
+	Playlists []playlistContext
+}
+
+func (s *Server) playlistsPage(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+
+	err := s.indexTmpl.ExecuteTemplate(buf, "playlists.gotmpl", s.playlistsContext(""))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't execute template: %v", err)
+		return
+	}
+
+	w.Write(buf.Bytes()) //nolint: errcheck
+}
+
+func (s *Server) playlistSave(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	steps, err := playlist.ParseSteps(params.Get("steps"))
+	if err != nil {
+		s.renderPlaylistsBody(w, err.Error())
+		return
+	}
+
+	err = s.playlists.Save(playlist.Definition{
+		Name:    params.Get("name"),
+		Steps:   steps,
+		Shuffle: params.Get("shuffle") == "on",
+	})
+	if err != nil {
+		s.renderPlaylistsBody(w, err.Error())
+		return
+	}
+
+	s.renderPlaylistsBody(w, "")
+}
+
+func (s *Server) playlistDelete(w http.ResponseWriter, r *http.Request) {
+	err := s.playlists.Delete(chi.URLParam(r, "name"))
+	if err != nil {
+		s.renderPlaylistsBody(w, err.Error())
+		return
+	}
+
+	s.renderPlaylistsBody(w, "")
+}
+
+func (s *Server) renderPlaylistsBody(w http.ResponseWriter, errMsg string) {
+	err := s.indexTmpl.ExecuteTemplate(w, "playlists-body.gotmpl", s.playlistsContext(errMsg))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't execute template: %v", err)
+		return
+	}
+}
+
+func (s *Server) playlistsContext(errMsg string) *playlistsContext {
+	result := &playlistsContext{
+		Active: "playlists",
+		Error:  errMsg,
+		Flows:  s.flows.FlowNames(),
+	}
+
+	for _, def := range s.playlists.List() {
+		result.Playlists = append(result.Playlists, playlistContext{
+			Name:    def.Name,
+			Shuffle: def.Shuffle,
+			Steps:   playlist.FormatSteps(def.Steps),
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mbobakov/khrushchevka/internal/flow/replay"
+)
+
+type ReplayControl interface {
+	// Position reports false if the replay isn't running
+	Position() (replay.Position, bool)
+	// Subscribe sends the position on every change
+	Subscribe(ch chan<- replay.Position)
+	Pause() error
+	Resume() error
+	Step(by int) error
+	Seek(frame int) error
+	SetSpeed(speed float64) error
+	SetPasses(passes int) error
+	SetMode(mode string) error
+}
+
+type replayContext struct {
+	Running bool
+	replay.Position
+}
+
+// replayPosition returns the state of the playback:
+// curl http://khrushchevka/replay
+func (s *Server) replayPosition(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.replay.Position()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "replay isn't running")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(p)
+	if err != nil {
+		slog.Error("couldn't encode replay position", slog.Any("err", err))
+	}
+}
+
+// replayControl executes the transport command of the replay:
+// curl -d 'action=speed&value=2' http://khrushchevka/replay
+// Actions are pause, resume, step (value is the number of the frames, negative goes back), seek (frame from 1),
+// speed (multiplier), passes (0 is endless) and mode (forward, reverse, ping-pong)
+func (s *Server) replayControl(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	err = s.replayCommand(params.Get("action"), strings.TrimSpace(params.Get("value")))
+	if errors.Is(err, replay.ErrNotRunning) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't control replay: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) replayCommand(action, value string) error {
+	number := func() (int, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't parse value '%s': %w", value, err)
+		}
+		return n, nil
+	}
+
+	switch action {
+	case "pause":
+		return s.replay.Pause()
+	case "resume":
+		return s.replay.Resume()
+	case "step":
+		n := 1
+		if value != "" {
+			var err error
+			n, err = number()
+			if err != nil {
+				return err
+			}
+		}
+		return s.replay.Step(n)
+	case "seek":
+		n, err := number()
+		if err != nil {
+			return err
+		}
+		return s.replay.Seek(n)
+	case "speed":
+		speed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("couldn't parse speed '%s': %w", value, err)
+		}
+		return s.replay.SetSpeed(speed)
+	case "passes":
+		n, err := number()
+		if err != nil {
+			return err
+		}
+		return s.replay.SetPasses(n)
+	case "mode":
+		return s.replay.SetMode(value)
+	}
+
+	return fmt.Errorf("unknown action '%s'", action)
+}
+
+// replayFragment renders the position of the replay for the panel
+func (s *Server) replayFragment(p replay.Position) ([]byte, error) {
+	buf := &strings.Builder{}
+	err := s.indexTmpl.ExecuteTemplate(buf, "replay.gotmpl", replayContext{Running: p.Total > 0, Position: p})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute template: %w", err)
+	}
+	return []byte(strings.ReplaceAll(buf.String(), "\n", "")), nil
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/flow/manual"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
+)
+
+type sceneDTO struct {
+	Name   string              `json:"name"`
+	Lights []snapshot.LightDTO `json:"lights"`
+}
+
+// scenesList returns the scenes with their lights:
+// curl http://khrushchevka/scenes
+func (s *Server) scenesList(w http.ResponseWriter, r *http.Request) {
+	sequences, err := s.scenes.List()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't list scenes: %v", err)
+		return
+	}
+
+	result := []sceneDTO{}
+	for _, seq := range sequences {
+		if len(seq.Frames) > 0 {
+			result = append(result, sceneDTO{Name: seq.Name, Lights: seq.Frames[len(seq.Frames)-1]})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		slog.Error("couldn't encode scenes", slog.Any("err", err))
+	}
+}
+
+// sceneSave saves the current state of the lights as the scene, the scene with the same name is replaced:
+// curl -d 'name=cosy-evening' http://khrushchevka/scenes
+func (s *Server) sceneSave(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	frame, err := snapshot.Capture(s.lights, s.mapping)
+	if err == nil {
+		err = snapshot.SaveScene(s.scenes, params.Get("name"), frame)
+	}
+	s.renderSnapshotsBody(w, err, "")
+}
+
+// sceneApply switches the building to the manual mode with the state of the scene, e.g. from a button:
+// curl -d ” http://khrushchevka/scenes/cosy-evening/apply
+func (s *Server) sceneApply(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	// the scene is checked before the assignment, so the running flows aren't stopped for the missing one
+	_, err := snapshot.LoadScene(s.scenes, name)
+	if errors.Is(err, snapshot.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "scene '%s' isn't found", name)
+		return
+	}
+	if err == nil {
+		err = s.flows.AssignFlow(s.mainCtx, manual.FlowName, flow.GroupAll, flow.Params{manual.ParamScene: name})
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't apply scene: %v", err)
+		return
+	}
+
+	if s.sched != nil {
+		s.sched.Suspend()
+	}
+
+	// the mode and the owners of the lights are changed, the whole page has to be updated
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) sceneDelete(w http.ResponseWriter, r *http.Request) {
+	s.renderSnapshotsBody(w, s.scenes.Delete(chi.URLParam(r, "name")), "")
+}
+
+// sceneNames returns the names of the scenes for the lights page
+func (s *Server) sceneNames() []string {
+	if s.scenes == nil {
+		return nil
+	}
+	sequences, err := s.scenes.List()
+	if err != nil {
+		slog.Error("couldn't list scenes", slog.Any("err", err))
+	}
+
+	result := []string{}
+	for _, seq := range sequences {
+		result = append(result, seq.Name)
+	}
+	return result
+}
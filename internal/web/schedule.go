@@ -0,0 +1,232 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/schedule"
+)
+
+const upcomingTransitions = 10
+
+type Scheduler interface {
+	Config() schedule.Config
+	Update(cfg schedule.Config) error
+	Upcoming(after time.Time, n int) []schedule.Transition
+	SuspendedUntil() time.Time
+	Suspend()
+}
+
+type ruleContext struct {
+	Idx      int
+	Name     string
+	When     string
+	Flow     string
+	Params   string
+	Holidays string
+}
+
+type transitionContext struct {
+	At   string
+	Rule string
+	Flow string
+}
+
+type scheduleContext struct {
+	Active         string
+	Error          string
+	Timezone       string
+	Latitude       string
+	Longitude      string
+	Holidays       string
+	SuspendedUntil string
+	Flows          []string
+	Rules          []ruleContext
+	Upcoming       []transitionContext
+}
+
+func (s *Server) schedulePage(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+
+	err := s.indexTmpl.ExecuteTemplate(buf, "schedule.gotmpl", s.scheduleContext(""))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't execute template: %v", err)
+		return
+	}
+
+	w.Write(buf.Bytes()) //nolint: errcheck
+}
+
+func (s *Server) scheduleAddRule(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	fp, err := flow.ParseParams(params.Get("params"))
+	if err != nil {
+		s.renderScheduleBody(w, err.Error())
+		return
+	}
+
+	rule := schedule.Rule{
+		Name:     params.Get("name"),
+		Flow:     params.Get("flow"),
+		Params:   fp,
+		Holidays: schedule.HolidayPolicy(params.Get("holidays")),
+	}
+
+	when := strings.TrimSpace(params.Get("when"))
+	if schedule.IsSolar(when) {
+		rule.At = when
+	} else {
+		rule.Cron = when
+	}
+
+	cfg := s.sched.Config()
+	cfg.Rules = append(append([]schedule.Rule{}, cfg.Rules...), rule)
+
+	err = s.sched.Update(cfg)
+	if err != nil {
+		s.renderScheduleBody(w, err.Error())
+		return
+	}
+
+	s.renderScheduleBody(w, "")
+}
+
+func (s *Server) scheduleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	idx, err := strconv.Atoi(chi.URLParam(r, "idx"))
+	cfg := s.sched.Config()
+	if err != nil || idx < 0 || idx >= len(cfg.Rules) {
+		s.renderScheduleBody(w, fmt.Sprintf("rule '%s' not found", chi.URLParam(r, "idx")))
+		return
+	}
+
+	rules := append([]schedule.Rule{}, cfg.Rules[:idx]...)
+	cfg.Rules = append(rules, cfg.Rules[idx+1:]...)
+
+	err = s.sched.Update(cfg)
+	if err != nil {
+		s.renderScheduleBody(w, err.Error())
+		return
+	}
+
+	s.renderScheduleBody(w, "")
+}
+
+func (s *Server) scheduleSettings(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	cfg := s.sched.Config()
+	cfg.Timezone = strings.TrimSpace(params.Get("timezone"))
+	cfg.Latitude, err = parseCoordinate(params.Get("latitude"))
+	if err != nil {
+		s.renderScheduleBody(w, fmt.Sprintf("couldn't parse latitude: %v", err))
+		return
+	}
+	cfg.Longitude, err = parseCoordinate(params.Get("longitude"))
+	if err != nil {
+		s.renderScheduleBody(w, fmt.Sprintf("couldn't parse longitude: %v", err))
+		return
+	}
+	cfg.Holidays = strings.FieldsFunc(params.Get("holidays"), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+
+	err = s.sched.Update(cfg)
+	if err != nil {
+		s.renderScheduleBody(w, err.Error())
+		return
+	}
+
+	s.renderScheduleBody(w, "")
+}
+
+func (s *Server) renderScheduleBody(w http.ResponseWriter, errMsg string) {
+	err := s.indexTmpl.ExecuteTemplate(w, "schedule-body.gotmpl", s.scheduleContext(errMsg))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't execute template: %v", err)
+		return
+	}
+}
+
+func (s *Server) scheduleContext(errMsg string) *scheduleContext {
+	cfg := s.sched.Config()
+	result := &scheduleContext{
+		Active:   "schedule",
+		Error:    errMsg,
+		Timezone: cfg.Timezone,
+		Holidays: strings.Join(cfg.Holidays, "\n"),
+		Flows:    s.flows.FlowNames(),
+	}
+
+	if cfg.Latitude != 0 || cfg.Longitude != 0 {
+		result.Latitude = strconv.FormatFloat(cfg.Latitude, 'f', -1, 64)
+		result.Longitude = strconv.FormatFloat(cfg.Longitude, 'f', -1, 64)
+	}
+
+	if until := s.sched.SuspendedUntil(); !until.IsZero() {
+		result.SuspendedUntil = until.Format(time.DateTime)
+	}
+
+	for i, r := range cfg.Rules {
+		when := r.Cron
+		if r.At != "" {
+			when = r.At
+		}
+		result.Rules = append(result.Rules, ruleContext{
+			Idx:      i,
+			Name:     r.Name,
+			When:     when,
+			Flow:     r.Flow,
+			Params:   r.Params.String(),
+			Holidays: string(r.Holidays),
+		})
+	}
+
+	for _, t := range s.sched.Upcoming(time.Now(), upcomingTransitions) {
+		result.Upcoming = append(result.Upcoming, transitionContext{
+			At:   t.At.Format("Mon " + time.DateTime + " MST"),
+			Rule: t.Rule.Name,
+			Flow: t.Rule.Flow,
+		})
+	}
+
+	return result
+}
+
+func parseCoordinate(raw string) (float64, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseFloat(raw, 64)
+}
+
+func readForm(r *http.Request) (url.Values, error) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read body: %w", err)
+	}
+
+	return url.ParseQuery(string(buf))
+}
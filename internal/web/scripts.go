@@ -0,0 +1,65 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mbobakov/khrushchevka/internal/flow/script"
+)
+
+type ScriptsStatus interface {
+	Status() []script.Status
+}
+
+type scriptContext struct {
+	Name     string
+	Path     string
+	LoadedAt string
+	Err      string
+}
+
+type scriptsContext struct {
+	Active  string
+	Scripts []scriptContext
+}
+
+func (s *Server) scriptsPage(w http.ResponseWriter, r *http.Request) {
+	sctx := &scriptsContext{Active: "scripts"}
+	for _, st := range s.scripts.Status() {
+		sctx.Scripts = append(sctx.Scripts, scriptContext{
+			Name:     st.Name,
+			Path:     st.Path,
+			LoadedAt: st.LoadedAt.Format(time.DateTime),
+			Err:      st.Err,
+		})
+	}
+
+	buf := &bytes.Buffer{}
+
+	err := s.indexTmpl.ExecuteTemplate(buf, "scripts.gotmpl", sctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't execute template: %v", err)
+		return
+	}
+
+	w.Write(buf.Bytes()) //nolint: errcheck
+}
+
+// flowErrors returns errors of the flows by name to show them near the flow
+func (s *Server) flowErrors() map[string]string {
+	result := map[string]string{}
+	if s.scripts == nil {
+		return result
+	}
+
+	for _, st := range s.scripts.Status() {
+		if st.Err != "" {
+			result[st.Name] = st.Err
+		}
+	}
+
+	return result
+}
@@ -61,10 +61,8 @@ SEARCH:
 		return
 	}
 
-	err = s.lights.Set(internal.LightAddress{
-		Pin:   pin,
-		Board: uint8(board),
-	}, mustOn)
+	// the click goes through the owner, so it is arbitrated and shadowed by the overlays
+	err = s.flows.SetLight(light.Addr, mustOn)
 
 	if err != nil {
 		fmt.Fprintf(w, "couldn't set lights: %v", err)
@@ -1,18 +1,285 @@
 package web
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/mbobakov/khrushchevka/internal/flow"
+	"github.com/mbobakov/khrushchevka/internal/flow/replay"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
 )
 
+type frameContext struct {
+	Number int
+	Lit    int
+	Total  int
+	// Up and Down are the positions the frame is moved to, 0 if it couldn't be moved
+	Up   int
+	Down int
+}
+
+type sequenceContext struct {
+	Name   string
+	Frames []frameContext
+}
+
+type sceneContext struct {
+	Name  string
+	Lit   int
+	Total int
+}
+
+type snapshotsContext struct {
+	Active    string
+	Error     string
+	Open      string
+	Sequences []sequenceContext
+	// Scenes are nil if the scenes aren't available
+	Scenes []sceneContext
+}
+
+// snapshot appends the state of the lights to the sequence, the default one is used without the sequence param:
+// curl -d 'sequence=evening' http://khrushchevka/lights/snapshot
 func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
-	if s.snap == nil {
+	if s.snaps == nil {
+		return
+	}
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
 		return
 	}
-	err := s.snap.Snapshot()
+
+	name := params.Get("sequence")
+	if name == "" {
+		name = snapshot.DefaultSequence
+	}
+	err = snapshot.ValidateName(name)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+
+	err = snapshot.Snapshot(s.snaps, name, s.lights, s.mapping)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "couldn't snapshot: %v", err)
 		return
 	}
 }
+
+func (s *Server) snapshotsPage(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+
+	err := s.indexTmpl.ExecuteTemplate(buf, "snapshots.gotmpl", s.snapshotsContext("", r.URL.Query().Get("open")))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't execute template: %v", err)
+		return
+	}
+
+	w.Write(buf.Bytes()) //nolint: errcheck
+}
+
+// sequenceGet returns the frames of the sequence:
+// curl http://khrushchevka/snapshots/evening
+func (s *Server) sequenceGet(w http.ResponseWriter, r *http.Request) {
+	seq, err := s.snaps.Get(chi.URLParam(r, "name"))
+	if errors.Is(err, snapshot.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(seq)
+	if err != nil {
+		slog.Error("couldn't encode sequence", slog.Any("err", err))
+	}
+}
+
+// sequenceCreate creates the empty sequence:
+// curl -d 'name=evening' http://khrushchevka/snapshots
+func (s *Server) sequenceCreate(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	name := params.Get("name")
+	s.renderSnapshotsBody(w, s.snaps.Create(name), name)
+}
+
+// sequenceAction executes the action on the sequence:
+// curl -d 'to=morning' http://khrushchevka/snapshots/evening/rename
+// Actions are rename and duplicate with the new name in to and play, which runs the replay of the sequence on all lights
+func (s *Server) sequenceAction(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	name, to := chi.URLParam(r, "name"), params.Get("to")
+	switch chi.URLParam(r, "action") {
+	case "rename":
+		s.renderSnapshotsBody(w, s.snaps.Rename(name, to), to)
+	case "duplicate":
+		s.renderSnapshotsBody(w, s.snaps.Duplicate(name, to), to)
+	case "play":
+		err = s.flows.AssignFlow(s.mainCtx, replay.FlowName, flow.GroupAll, flow.Params{"sequence": name})
+		if err == nil && s.sched != nil {
+			s.sched.Suspend()
+		}
+		s.renderSnapshotsBody(w, err, name)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "unknown action '%s'", chi.URLParam(r, "action"))
+	}
+}
+
+func (s *Server) sequenceDelete(w http.ResponseWriter, r *http.Request) {
+	s.renderSnapshotsBody(w, s.snaps.Delete(chi.URLParam(r, "name")), "")
+}
+
+func (s *Server) frameDelete(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	frame, err := strconv.Atoi(chi.URLParam(r, "frame"))
+	if err == nil {
+		err = s.snaps.DeleteFrame(name, frame)
+	}
+	s.renderSnapshotsBody(w, err, name)
+}
+
+// frameMove moves the frame of the sequence to the position:
+// curl -d 'to=1' http://khrushchevka/snapshots/evening/frames/3/move
+func (s *Server) frameMove(w http.ResponseWriter, r *http.Request) {
+	params, err := readForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "couldn't read params: %v", err)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	frame, err := strconv.Atoi(chi.URLParam(r, "frame"))
+	if err != nil {
+		s.renderSnapshotsBody(w, fmt.Errorf("couldn't parse frame: %w", err), name)
+		return
+	}
+	to, err := strconv.Atoi(params.Get("to"))
+	if err != nil {
+		s.renderSnapshotsBody(w, fmt.Errorf("couldn't parse position: %w", err), name)
+		return
+	}
+
+	s.renderSnapshotsBody(w, s.snaps.MoveFrame(name, frame, to), name)
+}
+
+// renderSnapshotsBody shows the error of the action or the sequences with the open one
+// Failed action is answered with 404 for the missing sequence or scene and with 400 for the others
+func (s *Server) renderSnapshotsBody(w http.ResponseWriter, actionErr error, open string) {
+	errMsg, status := "", http.StatusOK
+	switch {
+	case errors.Is(actionErr, snapshot.ErrNotFound):
+		errMsg, status = actionErr.Error(), http.StatusNotFound
+	case actionErr != nil:
+		errMsg, status = actionErr.Error(), http.StatusBadRequest
+	}
+
+	buf := &bytes.Buffer{}
+	err := s.indexTmpl.ExecuteTemplate(buf, "snapshots-body.gotmpl", s.snapshotsContext(errMsg, open))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "couldn't execute template: %v", err)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(buf.Bytes()) //nolint: errcheck
+}
+
+func (s *Server) snapshotsContext(errMsg, open string) *snapshotsContext {
+	result := &snapshotsContext{
+		Active: "snapshots",
+		Error:  errMsg,
+		Open:   open,
+	}
+
+	sequences, err := s.snaps.List()
+	if err != nil && result.Error == "" {
+		result.Error = err.Error()
+	}
+	for _, seq := range sequences {
+		sc := sequenceContext{Name: seq.Name}
+		for i, frame := range seq.Frames {
+			fc := frameContext{Number: i + 1, Total: len(frame), Up: i}
+			if i+1 < len(seq.Frames) {
+				fc.Down = i + 2
+			}
+			for _, l := range frame {
+				if l.IsOn {
+					fc.Lit++
+				}
+			}
+			sc.Frames = append(sc.Frames, fc)
+		}
+		result.Sequences = append(result.Sequences, sc)
+	}
+
+	if s.scenes == nil {
+		return result
+	}
+	scenes, err := s.scenes.List()
+	if err != nil && result.Error == "" {
+		result.Error = err.Error()
+	}
+	result.Scenes = []sceneContext{}
+	for _, seq := range scenes {
+		sc := sceneContext{Name: seq.Name}
+		if n := len(seq.Frames); n > 0 {
+			sc.Total = len(seq.Frames[n-1])
+			for _, l := range seq.Frames[n-1] {
+				if l.IsOn {
+					sc.Lit++
+				}
+			}
+		}
+		result.Scenes = append(result.Scenes, sc)
+	}
+
+	return result
+}
+
+// sequenceNames returns the names of the sequences for the snapshot button
+func (s *Server) sequenceNames() []string {
+	if s.snaps == nil {
+		return nil
+	}
+	sequences, err := s.snaps.List()
+	if err != nil {
+		slog.Error("couldn't list snapshot sequences", slog.Any("err", err))
+	}
+
+	result := []string{}
+	for _, seq := range sequences {
+		result = append(result, seq.Name)
+	}
+	return result
+}
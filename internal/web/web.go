@@ -11,7 +11,9 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/mbobakov/khrushchevka/internal"
+	"github.com/mbobakov/khrushchevka/internal/flow"
 	"github.com/mbobakov/khrushchevka/internal/lights"
+	"github.com/mbobakov/khrushchevka/internal/snapshot"
 	"github.com/r3labs/sse"
 )
 
@@ -23,12 +25,16 @@ var staticFS embed.FS
 
 type FlowController interface {
 	SelectFlow(ctx context.Context, name string) error
+	AssignFlow(ctx context.Context, name, group string, params flow.Params) error
 	FlowNames() []string
+	Groups() []string
+	Owner(addr internal.LightAddress) string
+	// SetLight changes the light on behalf of its owner
+	SetLight(addr internal.LightAddress, isOn bool) error
+	Running() []string
 	Active() string
-}
-
-type Snapshoter interface {
-	Snapshot() error
+	Overlay(ctx context.Context, name, group string, d time.Duration, params flow.Params) error
+	OverlayNames() []string
 }
 
 // Server deals with all incomming requests and performs calls to the various internal subsystems
@@ -37,14 +43,65 @@ type Server struct {
 	indexTmpl           *template.Template
 	lights              lights.ControllerI
 	flows               FlowController
-	snap                Snapshoter
+	snaps               snapshot.Store
+	scenes              snapshot.Store
+	sched               Scheduler
+	scripts             ScriptsStatus
+	playlists           Playlists
+	live                LiveStatus
+	replay              ReplayControl
 	mapping             [][]internal.Light
 	sse                 *sse.Server
 	mainCtx             context.Context
 	validateSelectBoard uint8
 }
 
-func NewServer(l lights.ControllerI, f FlowController, snap Snapshoter, mapping [][]internal.Light) (*Server, error) {
+// Option configures optional subsystems of the server
+type Option func(s *Server)
+
+// WithScheduler enables the schedule page
+func WithScheduler(sched Scheduler) Option {
+	return func(s *Server) {
+		s.sched = sched
+	}
+}
+
+// WithScripts enables the scripts page and reporting of the script errors
+func WithScripts(scripts ScriptsStatus) Option {
+	return func(s *Server) {
+		s.scripts = scripts
+	}
+}
+
+// WithPlaylists enables the playlists page
+func WithPlaylists(playlists Playlists) Option {
+	return func(s *Server) {
+		s.playlists = playlists
+	}
+}
+
+// WithLive enables reporting of the occupancy of the live mode
+func WithLive(l LiveStatus) Option {
+	return func(s *Server) {
+		s.live = l
+	}
+}
+
+// WithReplay enables the transport controls of the replay
+func WithReplay(r ReplayControl) Option {
+	return func(s *Server) {
+		s.replay = r
+	}
+}
+
+// WithScenes enables the scenes applied in the manual mode
+func WithScenes(scenes snapshot.Store) Option {
+	return func(s *Server) {
+		s.scenes = scenes
+	}
+}
+
+func NewServer(l lights.ControllerI, f FlowController, snaps snapshot.Store, mapping [][]internal.Light, opts ...Option) (*Server, error) {
 	// templates
 	indexTmpl, err := template.ParseFS(templatesFS, "templates/*.gotmpl")
 	if err != nil {
@@ -57,14 +114,20 @@ func NewServer(l lights.ControllerI, f FlowController, snap Snapshoter, mapping
 	sseSrv.EventTTL = 0
 	sseSrv.CreateStream("lights")
 
-	return &Server{
+	srv := &Server{
 		lights:    l,
 		flows:     f,
 		indexTmpl: indexTmpl,
 		sse:       sseSrv,
 		mapping:   mapping,
-		snap:      snap,
-	}, nil
+		snaps:     snaps,
+	}
+
+	for _, o := range opts {
+		o(srv)
+	}
+
+	return srv, nil
 }
 
 func (s *Server) Listen(ctx context.Context, addr string) error {
@@ -80,7 +143,55 @@ func (s *Server) Listen(ctx context.Context, addr string) error {
 	r.Post("/lights/set", s.setLigts)
 	r.Post("/lights/snapshot", s.snapshot)
 
+	if s.snaps != nil {
+		r.Get("/snapshots", s.snapshotsPage)
+		r.Post("/snapshots", s.sequenceCreate)
+		r.Get("/snapshots/{name}", s.sequenceGet)
+		r.Delete("/snapshots/{name}", s.sequenceDelete)
+		r.Post("/snapshots/{name}/{action}", s.sequenceAction)
+		r.Delete("/snapshots/{name}/frames/{frame}", s.frameDelete)
+		r.Post("/snapshots/{name}/frames/{frame}/move", s.frameMove)
+	}
+
+	if s.scenes != nil {
+		r.Get("/scenes", s.scenesList)
+		r.Post("/scenes", s.sceneSave)
+		r.Post("/scenes/{name}/apply", s.sceneApply)
+		r.Delete("/scenes/{name}", s.sceneDelete)
+	}
+
 	r.Put("/flows", s.setFlow)
+	r.Post("/flows/assign", s.assignFlow)
+	r.Post("/overlays", s.triggerOverlay)
+
+	if s.sched != nil {
+		r.Get("/schedule", s.schedulePage)
+		r.Post("/schedule/rules", s.scheduleAddRule)
+		r.Delete("/schedule/rules/{idx}", s.scheduleDeleteRule)
+		r.Post("/schedule/settings", s.scheduleSettings)
+	}
+
+	if s.scripts != nil {
+		r.Get("/scripts", s.scriptsPage)
+	}
+
+	if s.playlists != nil {
+		r.Get("/playlists", s.playlistsPage)
+		r.Post("/playlists", s.playlistSave)
+		r.Delete("/playlists/{name}", s.playlistDelete)
+	}
+
+	if s.live != nil {
+		r.Get("/live/occupancy", s.liveOccupancy)
+		r.Get("/live/status", s.liveStatus)
+		r.Post("/live/events", s.liveEvent)
+		r.Get("/live/dry-run", s.liveDryRun)
+	}
+
+	if s.replay != nil {
+		r.Get("/replay", s.replayPosition)
+		r.Post("/replay", s.replayControl)
+	}
 
 	r.Get("/static/*", http.FileServer(http.FS(staticFS)).ServeHTTP)
 
@@ -101,7 +212,7 @@ func stopWhenDone(ctx context.Context, server *http.Server) {
 	<-ctx.Done()
 	err := server.Shutdown(context.Background())
 	if err != nil {
-		slog.Error("Failed to gracefully shutdown HTTP server:%v", err)
+		slog.Error("Failed to gracefully shutdown HTTP server", slog.Any("err", err))
 	}
 }
 